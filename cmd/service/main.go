@@ -1,41 +1,231 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
-	"net/http"
-	"time"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/gorilla/mux"
 
 	authentication "github.com/qredo-external/go-rnov/pkg/auth"
+	"github.com/qredo-external/go-rnov/pkg/config"
+	"github.com/qredo-external/go-rnov/pkg/health"
+	"github.com/qredo-external/go-rnov/pkg/http/decoder"
 	"github.com/qredo-external/go-rnov/pkg/http/handler"
 	"github.com/qredo-external/go-rnov/pkg/http/middleware"
+	"github.com/qredo-external/go-rnov/pkg/http/server"
+	"github.com/qredo-external/go-rnov/pkg/http/tlsconfig"
+	"github.com/qredo-external/go-rnov/pkg/logger"
+	"github.com/qredo-external/go-rnov/pkg/metrics"
+	"github.com/qredo-external/go-rnov/pkg/ratelimit"
 	"github.com/qredo-external/go-rnov/pkg/service"
 	"github.com/qredo-external/go-rnov/pkg/storage"
+	"github.com/qredo-external/go-rnov/pkg/trace"
 )
 
 func main() {
-	// note this data should be loaded from a config file and a vault like service as well as host and port
-	secret := "jdnfksdmfksd"
-	td := time.Minute * 60
+	cfg, printConfig, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if printConfig {
+		if err := cfg.Write(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	// note server side deadlines per route, past them the computation stops and the client gets a 504
+	sumTimeout := cfg.Server.SumTimeout
+	batchTimeout := cfg.Server.BatchTimeout
+	// note bodies are bounded per route, a batch by its total size and each of its documents by the document size
+	maxDocument := int64(cfg.Server.MaxDocumentSize)
+	maxBatch := int64(cfg.Server.MaxBatchSize)
+
+	level := cfg.Log.Level
+	lg := logger.NewJSON(os.Stdout, level)
+
+	exporter, closeExporter, err := traceExporter(cfg.Trace.Exporter, cfg.Trace.File)
+	if err != nil {
+		lg.Error("unable to configure tracing", logger.F("error", err))
+		os.Exit(1)
+	}
+	tracer := trace.NewTracer(exporter)
+
+	registry := metrics.NewRegistry()
+	mt := metrics.NewCollector(registry)
 
 	virtualStorage := storage.NewUserAccess()
-	auth := authentication.NewAuth(secret, td)
+	registry.GaugeFunc("auth_tokens_stored", "Tokens held by the token store.", func() float64 {
+		return float64(virtualStorage.Len())
+	})
+	auth := authentication.NewAuth(cfg.Auth.Secret.Value(), cfg.Auth.TokenTTL)
+
+	// note the nonces of the DPoP proofs are derived from the secret, so replicas sharing it accept each other's
+	dpopBase, err := cfg.Auth.DPoPBase()
+	if err != nil {
+		lg.Error("unable to configure dpop", logger.F("error", err))
+		os.Exit(1)
+	}
+	dpop := authentication.NewDPoP(cfg.Auth.Secret.Value(), storage.NewReplays(), cfg.Auth.DPoPWindow, cfg.Auth.DPoPNonce, dpopBase)
 
-	authMid := middleware.NewAuthMiddleware(auth, virtualStorage)
+	authMid := middleware.NewAuthMiddleware(auth, virtualStorage, dpop, lg, mt)
 
-	authSrv := service.NewAuthService(auth, virtualStorage)
-	opSrv := service.NewOperationsService()
+	authSrv := service.NewAuthService(auth, virtualStorage, lg, mt)
+	resultCache := storage.NewResultLRU(cfg.Cache.Size, cfg.Cache.TTL)
+	opSrv := service.NewOperationsService(service.WithCache(resultCache), service.WithLogger(lg),
+		service.WithParallelism(cfg.Sum.Workers, cfg.Sum.SplitThreshold))
+	rcSrv := service.NewReceiptService(auth)
+	histSrv := service.NewHistoryService(storage.NewHistory(cfg.History.MaxPerSubject))
+	quota := service.Quota{Operations: cfg.Quota.Operations, Bytes: cfg.Quota.Bytes, Period: cfg.Quota.Period}
+	quotaSrv := service.NewQuotaService(storage.NewUsages(), quota)
+	jobSrv := service.NewJobService(opSrv, storage.NewJobs(cfg.Jobs.Retention), histSrv, quotaSrv, cfg.Jobs.Workers, cfg.Jobs.QueueSize, lg)
 
-	ha := handler.NewAuthHandler(authSrv)
-	ho := handler.NewOperationHandler(opSrv)
+	ha := handler.NewAuthHandler(authSrv, dpop)
+	ho := handler.NewOperationHandler(opSrv, rcSrv, histSrv, quotaSrv, mt, decoder.Default(), cfg.Server.MaxDocumentSize)
+	hr := handler.NewReceiptHandler(rcSrv)
+	hj := handler.NewJobHandler(jobSrv, decoder.Default())
+	hh := handler.NewHistoryHandler(histSrv)
+	hu := handler.NewUsageHandler(quotaSrv)
+
+	// note readiness, token store reachable, signing keys usable and not shutting down
+	shutdown := &health.Shutdown{}
+	checks := health.NewRegistry(health.DefaultTimeout)
+	checks.Register("token-store", health.CheckerFunc(virtualStorage.Ping))
+	checks.Register("signing-keys", health.CheckerFunc(auth.CheckKeys))
+	checks.Register("shutdown", shutdown)
+	hhc := handler.NewHealthHandler(checks)
 
 	r := mux.NewRouter()
-	r.HandleFunc("/auth", ha.CreateAuthHandler).Methods("POST")
-	r.HandleFunc("/sum", middleware.Authentication(*authMid, ho.SumHandler)).Methods("POST")
+	r.Use(middleware.RequestID, middleware.Logging(lg), middleware.Instrument(mt), middleware.Tracing(tracer))
+	// note the metrics are not protected, the port should only be reachable by the scraper
+	r.Handle("/metrics", registry).Methods("GET")
+	r.HandleFunc("/healthz", hhc.LivenessHandler).Methods("GET")
+	r.HandleFunc("/readyz", hhc.ReadinessHandler).Methods("GET")
+	// note limits are taken per subject once authenticated, so they wrap the handlers inside Authentication
+	rl := middleware.NewRateLimiter(ratelimit.NewLimiter(ratelimit.NewMemory()), lg)
+	limits := cfg.RateLimit
+	r.HandleFunc("/auth", middleware.RateLimit(*rl, "auth", limits.Auth, middleware.MaxBytes(maxDocument, ha.CreateAuthHandler))).Methods("POST")
+	r.HandleFunc("/sum", middleware.Authentication(*authMid, middleware.RateLimit(*rl, "sum", limits.Sum, middleware.MaxBytes(maxDocument, hj.SubmitSumHandler)))).Methods("POST").Queries("async", "true")
+	r.HandleFunc("/sum", middleware.Authentication(*authMid, middleware.RateLimit(*rl, "sum", limits.Sum, middleware.MaxBytes(maxDocument, middleware.Deadline(sumTimeout, ho.SumHandler))))).Methods("POST")
+	r.HandleFunc("/sum", middleware.Authentication(*authMid, middleware.RateLimit(*rl, "read", limits.Read, hh.ListHistoryHandler))).Methods("GET")
+	r.HandleFunc("/sum/{id}", middleware.Authentication(*authMid, middleware.RateLimit(*rl, "read", limits.Read, hh.GetComputationHandler))).Methods("GET")
+	r.HandleFunc("/sum/batch", middleware.Authentication(*authMid, middleware.RateLimit(*rl, "batch", limits.Batch, middleware.MaxBytes(maxBatch, middleware.Deadline(batchTimeout, ho.BatchSumHandler))))).Methods("POST")
+	r.HandleFunc("/aggregate/{operation}", middleware.Authentication(*authMid, middleware.RateLimit(*rl, "sum", limits.Sum, middleware.MaxBytes(maxDocument, middleware.Deadline(sumTimeout, ho.AggregateHandler))))).Methods("POST")
+	r.HandleFunc("/usage", middleware.Authentication(*authMid, middleware.RateLimit(*rl, "read", limits.Read, hu.GetUsageHandler))).Methods("GET")
+	r.HandleFunc("/jobs/{id}", middleware.Authentication(*authMid, middleware.RateLimit(*rl, "read", limits.Read, hj.GetJobHandler))).Methods("GET")
+	r.HandleFunc("/jobs/{id}", middleware.Authentication(*authMid, middleware.RateLimit(*rl, "read", limits.Read, hj.CancelJobHandler))).Methods("DELETE")
+	r.HandleFunc("/receipts/verify", middleware.RateLimit(*rl, "read", limits.Read, middleware.MaxBytes(maxDocument, hr.VerifyReceiptHandler))).Methods("POST")
+
+	srvCfg := server.Config{
+		Addr:              cfg.Server.Addr,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		DrainDelay:        cfg.Server.DrainDelay,
+		ShutdownTimeout:   cfg.Server.ShutdownTimeout,
+	}
+	var reloader *tlsconfig.Reloader
+	if cfg.TLS.Enabled() {
+		opts, err := cfg.TLS.Options()
+		if err == nil {
+			reloader, err = tlsconfig.NewReloader(opts, lg)
+		}
+		if err != nil {
+			lg.Error("unable to configure tls", logger.F("error", err))
+			os.Exit(1)
+		}
+		srvCfg.TLS = reloader.TLSConfig()
+	}
+	// note readiness flips as soon as the shutdown starts, so the orchestrator stops routing while requests drain
+	srv := server.New(srvCfg, r, lg, shutdown.Start)
 
-	fmt.Println("starting server")
+	lg.Info("starting server", logger.F("addr", srvCfg.Addr), logger.F("level", level.String()))
 	// Fire up the server
-	log.Fatal(http.ListenAndServe(":8080", r))
+	ctx, stop := signalContext(syscall.SIGINT, syscall.SIGTERM)
+	if reloader != nil {
+		// note new handshakes use the new certificate, established connections are left as they are
+		go reloader.Watch(ctx, cfg.TLS.ReloadInterval)
+		go reloadOnSignal(ctx, reloader, lg, syscall.SIGHUP)
+	}
+	exitCode := 0
+	if err := srv.Run(ctx); err != nil {
+		lg.Error("server stopped", logger.F("error", err))
+		exitCode = 1
+	}
+	stop()
+
+	// note requests are drained, background work is stopped and flushed within the same budget
+	closeCtx, cancel := context.WithTimeout(context.Background(), srvCfg.ShutdownTimeout)
+	if err := jobSrv.Shutdown(closeCtx); err != nil {
+		lg.Warn("jobs not finished", logger.F("error", err))
+	}
+	cancel()
+	if err := closeExporter(); err != nil {
+		lg.Warn("unable to close trace exporter", logger.F("error", err))
+	}
+	lg.Info("shutdown complete")
+	os.Exit(exitCode)
+}
+
+// signalContext - context done once one of the signals is received, a second signal kills the process as usual.
+func signalContext(sig ...os.Signal) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sig...)
+	go func() {
+		select {
+		case <-ch:
+			signal.Stop(ch)
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+	return ctx, cancel
+}
+
+// reloadOnSignal - reloads the certificate each time one of the signals is received until ctx is done.
+func reloadOnSignal(ctx context.Context, reloader *tlsconfig.Reloader, lg logger.Logger, sig ...os.Signal) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sig...)
+	defer signal.Stop(ch)
+	for {
+		select {
+		case <-ch:
+			if err := reloader.Reload(); err != nil {
+				lg.Error("tls reload failed, keeping the previous certificate", logger.F("error", err))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// traceExporter - exporter of the spans: none (the trace context is still propagated), stdout or otlp, which appends
+// OTLP/JSON to file, along with the func closing it.
+func traceExporter(kind, file string) (trace.Exporter, func() error, error) {
+	nop := func() error { return nil }
+	switch kind {
+	case "none":
+		return nil, nop, nil
+	case "stdout":
+		return trace.NewJSONExporter(os.Stdout), nop, nil
+	case "otlp":
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, nil, err
+		}
+		return trace.NewOTLPExporter(f, "go-rnov"), f.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown trace exporter %q", kind)
+	}
 }
@@ -1,8 +1,17 @@
 module github.com/qredo-external/go-rnov
 
-go 1.14
+go 1.20
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
+	github.com/fxamacker/cbor/v2 v2.9.4
 	github.com/gorilla/mux v1.8.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
 )
@@ -1,10 +1,11 @@
 package auth
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"time"
 
+	"github.com/qredo-external/go-rnov/pkg/trace"
 	"github.com/qredo-external/go-rnov/pkg/user"
 
 	"github.com/dgrijalva/jwt-go"
@@ -24,51 +25,112 @@ func NewAuth(secret string, td time.Duration) *Auth {
 	}
 }
 
+// Claims - data carried by a valid access token that is relevant to the rest of the service.
+type Claims struct {
+	Subject   string
+	ExpiresAt int64
+	// Confirmation - key the token is bound to, the holder must prove possession of it along with the token.
+	Confirmation Confirmation
+}
+
 // Operations - defines all the business logic operations for authorization.
 type Operations interface {
-	CreateJWT(usr user.User) (string, error)
-	ValidateJWT(JWT string) error
+	CreateJWT(ctx context.Context, usr user.User, cnf Confirmation) (string, error)
+	ValidateJWT(ctx context.Context, JWT string) (*Claims, error)
 }
 
 // verifyJWT - Parse, validate, and return a token.
 // keyFunc will receive the parsed token and should return the key for validating.
 func (a Auth) verifyJWT(tokenString string) (*jwt.Token, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(a.secret), nil
-	})
+	token, err := jwt.Parse(tokenString, a.keyFunc)
 	if err != nil {
-		return nil, err
+		return nil, tokenError(err)
 	}
 	return token, nil
 }
 
-// ValidateJWT - validates a given JWT based on its metadata.
-func (a Auth) ValidateJWT(JWT string) error {
+// keyFunc - returns the key used to verify tokens signed by the service, only HMAC is accepted.
+func (a Auth) keyFunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return []byte(a.secret), nil
+}
+
+// ValidateJWT - validates a given JWT based on its metadata and returns its claims, errors wrap one of the ErrToken
+// errors of the package.
+func (a Auth) ValidateJWT(ctx context.Context, JWT string) (*Claims, error) {
+	_, span := trace.Start(ctx, "Auth.ValidateJWT", trace.KindInternal)
+	defer span.End()
+	claims, err := a.validateJWT(JWT)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	span.SetAttributes(trace.Attr("enduser.id", claims.Subject))
+	return claims, nil
+}
+
+func (a Auth) validateJWT(JWT string) (*Claims, error) {
 	token, err := a.verifyJWT(JWT)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if _, ok := token.Claims.(jwt.Claims); !ok || !token.Valid {
-		return errors.New("invalid token")
+	mc, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, ErrTokenClaims
 	}
-	return nil
+	// note receipts are signed with the same key, they must never be accepted as access tokens.
+	if typ, _ := token.Header["typ"].(string); typ == receiptType {
+		return nil, ErrTokenType
+	}
+	claims := &Claims{}
+	if claims.Subject, _ = mc["sub"].(string); claims.Subject == "" {
+		// note tokens issued before `sub` was added only carry `user_id`.
+		claims.Subject, _ = mc["user_id"].(string)
+	}
+	if exp, ok := mc["exp"].(float64); ok {
+		claims.ExpiresAt = int64(exp)
+	}
+	claims.Confirmation = confirmation(mc)
+	return claims, nil
 }
 
-// CreateJWT - given a user create a valid JWT.
-func (a Auth) CreateJWT(usr user.User) (string, error) {
+// CreateJWT - given a user create a valid JWT, bound to the key of cnf when it is not the zero value.
+func (a Auth) CreateJWT(ctx context.Context, usr user.User, cnf Confirmation) (string, error) {
+	_, span := trace.Start(ctx, "Auth.CreateJWT", trace.KindInternal, trace.Attr("enduser.id", usr.UserName))
+	defer span.End()
 	var err error
 	//Creating Access Token
 	atClaims := jwt.MapClaims{}
 	atClaims["authorized"] = true
 	atClaims["user_id"] = usr.UserName
+	atClaims["sub"] = usr.UserName
 	atClaims["exp"] = time.Now().Add(a.duration).Unix()
+	if cnf.Bound() {
+		atClaims["cnf"] = cnf.claim()
+		span.SetAttributes(trace.Attr("auth.bound", true))
+	}
 	at := jwt.NewWithClaims(jwt.SigningMethodHS256, atClaims)
 	token, err := at.SignedString([]byte(a.secret))
 	if err != nil {
+		span.RecordError(err)
 		return "", err
 	}
 	return token, nil
 }
+
+// CheckKeys - checks the signing key is loaded and usable by signing and verifying a probe token.
+func (a Auth) CheckKeys(ctx context.Context) error {
+	if a.secret == "" {
+		return ErrNoSigningKey
+	}
+	probe, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"probe": true}).SignedString([]byte(a.secret))
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrNoSigningKey, err.Error())
+	}
+	if _, err := a.verifyJWT(probe); err != nil {
+		return fmt.Errorf("%w: %s", ErrNoSigningKey, err.Error())
+	}
+	return nil
+}
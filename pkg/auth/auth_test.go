@@ -0,0 +1,243 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"github.com/qredo-external/go-rnov/pkg/storage"
+	"github.com/qredo-external/go-rnov/pkg/user"
+)
+
+func TestAuth_ValidateJWT(t *testing.T) {
+	a := NewAuth("aSecret", time.Minute)
+	usr := user.User{UserName: "qwerty", Password: "mnbvc"}
+	valid, _ := a.CreateJWT(context.Background(), usr, Confirmation{})
+	forged, _ := NewAuth("anotherSecret", time.Minute).CreateJWT(context.Background(), usr, Confirmation{})
+	expired, _ := NewAuth("aSecret", -time.Minute).CreateJWT(context.Background(), usr, Confirmation{})
+	receipt, _ := a.SignReceipt(Receipt{Result: "aHash", Digest: "aDigest", Subject: "qwerty", IssuedAt: 1})
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"sub": "qwerty"}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	tests := []struct {
+		name        string
+		token       string
+		expectedErr error
+	}{
+		{name: "valid token", token: valid},
+		{name: "error - malformed", token: "notAToken", expectedErr: ErrTokenMalformed},
+		{name: "error - signed with another key", token: forged, expectedErr: ErrTokenSignature},
+		{name: "error - unsigned", token: unsigned, expectedErr: ErrTokenSignature},
+		{name: "error - expired", token: expired, expectedErr: ErrTokenExpired},
+		{name: "error - receipt", token: receipt, expectedErr: ErrTokenType},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := a.ValidateJWT(context.Background(), test.token)
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error %v got %v", test.expectedErr, err)
+			}
+			if err == nil && claims.Subject != "qwerty" {
+				t.Errorf("expected subject qwerty got %s", claims.Subject)
+			}
+		})
+	}
+}
+
+func TestAuth_CertificateBinding(t *testing.T) {
+	a := NewAuth("aSecret", time.Minute)
+	usr := user.User{UserName: "qwerty", Password: "mnbvc"}
+	clientCert, otherCert := &x509.Certificate{Raw: []byte("client")}, &x509.Certificate{Raw: []byte("other")}
+	bound, _ := a.CreateJWT(context.Background(), usr, Confirmation{X5tS256: CertThumbprint(clientCert)})
+	bearer, _ := a.CreateJWT(context.Background(), usr, Confirmation{})
+	tests := []struct {
+		name        string
+		token       string
+		cert        *x509.Certificate
+		expectedErr error
+	}{
+		{name: "bound token over the same certificate", token: bound, cert: clientCert},
+		{name: "bearer token without certificate", token: bearer},
+		{name: "bearer token over a certificate", token: bearer, cert: otherCert},
+		{name: "error - bound token over another certificate", token: bound, cert: otherCert, expectedErr: ErrTokenBinding},
+		{name: "error - bound token without certificate", token: bound, expectedErr: ErrTokenBinding},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := a.ValidateJWT(context.Background(), test.token)
+			if err != nil {
+				t.Fatalf("non-nil error : %s", err.Error())
+			}
+			if err := claims.Confirmation.VerifyCertificate(test.cert); !errors.Is(err, test.expectedErr) {
+				t.Errorf("expected error %v got %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestAuth_CheckKeys(t *testing.T) {
+	if err := NewAuth("aSecret", time.Minute).CheckKeys(context.Background()); err != nil {
+		t.Errorf("expected usable keys got %v", err)
+	}
+	if err := NewAuth("", time.Minute).CheckKeys(context.Background()); !errors.Is(err, ErrNoSigningKey) {
+		t.Errorf("expected %v got %v", ErrNoSigningKey, err)
+	}
+}
+
+// ecJWK - the public JWK of key, with its private part when private is set.
+func ecJWK(key *ecdsa.PrivateKey, private bool) map[string]interface{} {
+	pad := func(b []byte) string {
+		return base64.RawURLEncoding.EncodeToString(append(make([]byte, 32-len(b)), b...))
+	}
+	jwk := map[string]interface{}{"kty": "EC", "crv": "P-256", "x": pad(key.X.Bytes()), "y": pad(key.Y.Bytes())}
+	if private {
+		jwk["d"] = pad(key.D.Bytes())
+	}
+	return jwk
+}
+
+// newProof - a DPoP proof with claims signed by key, edit changes the token before it is signed.
+func newProof(t *testing.T, key *ecdsa.PrivateKey, claims jwt.MapClaims, edit func(tk *jwt.Token)) string {
+	tk := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	tk.Header["typ"] = dpopType
+	tk.Header["jwk"] = ecJWK(key, false)
+	var signingKey interface{} = key
+	if edit != nil {
+		edit(tk)
+		if _, ok := tk.Method.(*jwt.SigningMethodHMAC); ok {
+			signingKey = []byte("aSecret")
+		}
+	}
+	proof, err := tk.SignedString(signingKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proof
+}
+
+func TestDPoP_VerifyProof(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Truncate(time.Second)
+	d := NewDPoP("aSecret", storage.NewReplays(), time.Minute, true, nil)
+	d.now = func() time.Time { return now }
+	nonce := d.Nonce()
+	req := ProofRequest{Method: "POST", URL: "https://localhost/sum", AccessToken: "aToken"}
+	claims := func(edit func(c jwt.MapClaims)) jwt.MapClaims {
+		c := jwt.MapClaims{"jti": "aProof", "htm": "POST", "htu": "https://localhost/sum", "iat": now.Unix(),
+			"ath": accessTokenHash("aToken"), "nonce": nonce}
+		if edit != nil {
+			edit(c)
+		}
+		return c
+	}
+	tests := []struct {
+		name        string
+		proof       string
+		expectedErr error
+	}{
+		{name: "valid proof", proof: newProof(t, key, claims(nil), nil)},
+		{name: "error - replayed", proof: newProof(t, key, claims(nil), nil), expectedErr: ErrDPoPProof},
+		{name: "query of htu ignored", proof: newProof(t, key, claims(func(c jwt.MapClaims) {
+			c["jti"], c["htu"] = "query", "https://LOCALHOST/sum?async=true"
+		}), nil)},
+		{name: "iat slightly ahead", proof: newProof(t, key, claims(func(c jwt.MapClaims) {
+			c["jti"], c["iat"] = "ahead", now.Add(time.Second*30).Unix()
+		}), nil)},
+		{name: "error - missing", expectedErr: ErrDPoPProof},
+		{name: "error - another method", proof: newProof(t, key, claims(func(c jwt.MapClaims) { c["htm"] = "GET" }), nil), expectedErr: ErrDPoPProof},
+		{name: "error - another url", proof: newProof(t, key, claims(func(c jwt.MapClaims) { c["htu"] = "https://localhost/auth" }), nil), expectedErr: ErrDPoPProof},
+		{name: "error - stale", proof: newProof(t, key, claims(func(c jwt.MapClaims) { c["iat"] = now.Add(-time.Minute * 2).Unix() }), nil), expectedErr: ErrDPoPProof},
+		{name: "error - without jti", proof: newProof(t, key, claims(func(c jwt.MapClaims) { delete(c, "jti") }), nil), expectedErr: ErrDPoPProof},
+		{name: "error - another access token", proof: newProof(t, key, claims(func(c jwt.MapClaims) { c["ath"] = accessTokenHash("another") }), nil), expectedErr: ErrDPoPProof},
+		{name: "error - without nonce", proof: newProof(t, key, claims(func(c jwt.MapClaims) { delete(c, "nonce") }), nil), expectedErr: ErrDPoPNonce},
+		{name: "error - expired nonce", proof: newProof(t, key, claims(func(c jwt.MapClaims) { c["nonce"] = d.nonceAt(d.period() - 2) }), nil), expectedErr: ErrDPoPNonce},
+		{name: "error - another type", proof: newProof(t, key, claims(nil), func(tk *jwt.Token) { tk.Header["typ"] = "JWT" }), expectedErr: ErrDPoPProof},
+		{name: "error - private key in header", proof: newProof(t, key, claims(nil), func(tk *jwt.Token) { tk.Header["jwk"] = ecJWK(key, true) }), expectedErr: ErrDPoPProof},
+		{name: "error - signed with hmac", proof: newProof(t, key, claims(nil), func(tk *jwt.Token) { tk.Method = jwt.SigningMethodHS256 }), expectedErr: ErrDPoPProof},
+		{name: "error - signed by another key", proof: newProof(t, key, claims(nil), func(tk *jwt.Token) {
+			other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			tk.Header["jwk"] = ecJWK(other, false)
+		}), expectedErr: ErrDPoPProof},
+	}
+	var jkt string
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := d.VerifyProof(context.Background(), test.proof, req)
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error %v got %v", test.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if jkt == "" {
+				jkt = p.JKT
+			}
+			if p.JKT == "" || p.JKT != jkt {
+				t.Errorf("expected the thumbprint of the key got %s", p.JKT)
+			}
+			if err := (Confirmation{JKT: jkt}).VerifyProof(p); err != nil {
+				t.Errorf("expected proof of the bound key got %v", err)
+			}
+		})
+	}
+	if err := (Confirmation{JKT: "anotherKey"}).VerifyProof(&Proof{JKT: jkt}); !errors.Is(err, ErrTokenBinding) {
+		t.Errorf("expected %v got %v", ErrTokenBinding, err)
+	}
+
+	// note without nonces the proof is accepted with none, and Nonce has none to offer
+	d = NewDPoP("aSecret", storage.NewReplays(), time.Minute, false, nil)
+	if _, err := d.VerifyProof(context.Background(), newProof(t, key, jwt.MapClaims{"jti": "aProof", "htm": "POST",
+		"htu": "https://localhost/auth", "iat": time.Now().Unix()}, nil), ProofRequest{Method: "POST", URL: "https://localhost/auth"}); err != nil || d.Nonce() != "" {
+		t.Errorf("expected proof without nonce to be accepted got %v %s", err, d.Nonce())
+	}
+
+	// note behind a proxy terminating TLS the request arrives over http on an internal host and without the prefix
+	base, _ := url.Parse("https://api.example.com/rnov/")
+	d = NewDPoP("aSecret", storage.NewReplays(), time.Minute, false, base)
+	internal := ProofRequest{Method: "POST", URL: "http://10.0.0.1:8080/auth"}
+	if _, err := d.VerifyProof(context.Background(), newProof(t, key, jwt.MapClaims{"jti": "external", "htm": "POST",
+		"htu": "https://api.example.com/rnov/auth", "iat": time.Now().Unix()}, nil), internal); err != nil {
+		t.Errorf("expected proof of the external URL to be accepted got %v", err)
+	}
+	if _, err := d.VerifyProof(context.Background(), newProof(t, key, jwt.MapClaims{"jti": "internal", "htm": "POST",
+		"htu": "http://10.0.0.1:8080/auth", "iat": time.Now().Unix()}, nil), internal); !errors.Is(err, ErrDPoPProof) {
+		t.Errorf("expected proof of the internal URL to be rejected got %v", err)
+	}
+}
+
+func TestParseJWK(t *testing.T) {
+	// note the example of RFC 7638 section 3.1
+	jwk := map[string]interface{}{
+		"kty": "RSA",
+		"n": "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3o" +
+			"knjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu" +
+			"6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		"e":   "AQAB",
+		"alg": "RS256",
+		"kid": "2011-04-29",
+	}
+	if _, thumbprint, err := parseJWK(jwk); err != nil || thumbprint != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Errorf("expected RFC 7638 thumbprint got %s %v", thumbprint, err)
+	}
+	for _, invalid := range []map[string]interface{}{
+		{"kty": "oct", "k": "aSecret"},
+		{"kty": "EC", "crv": "P-256", "x": "AQ", "y": "AQ"},
+		{"kty": "RSA", "n": "AQAB", "e": "AQAB"},
+	} {
+		if _, _, err := parseJWK(invalid); err == nil {
+			t.Errorf("expected %v to be refused", invalid)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// x5tS256 - confirmation method of RFC 8705 binding a token to the certificate of the client.
+	x5tS256 = "x5t#S256"
+	// jkt - confirmation method of RFC 9449 binding a token to the key of the DPoP proofs of the client.
+	jkt = "jkt"
+)
+
+// Confirmation - key the token is bound to, carried as the RFC 7800 `cnf` claim. The zero value issues a bearer
+// token usable by whoever holds it.
+type Confirmation struct {
+	// X5tS256 - thumbprint of the client certificate the token was requested with (RFC 8705), see CertThumbprint.
+	X5tS256 string
+	// JKT - RFC 7638 thumbprint of the public key of the DPoP proof the token was requested with (RFC 9449).
+	JKT string
+}
+
+// CertThumbprint - base64url SHA-256 digest of the DER certificate, as the RFC 8705 `x5t#S256` confirmation.
+func CertThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Bound - whether the token can only be used along with a proof of possession of a key.
+func (c Confirmation) Bound() bool {
+	return c.X5tS256 != "" || c.JKT != ""
+}
+
+// VerifyCertificate - checks the token is presented over a connection authenticated by the certificate it is bound
+// to, cert is nil when the client presented none. Tokens not bound to a certificate are accepted.
+func (c Confirmation) VerifyCertificate(cert *x509.Certificate) error {
+	if c.X5tS256 == "" {
+		return nil
+	}
+	if cert == nil {
+		return ErrTokenBinding
+	}
+	if subtle.ConstantTimeCompare([]byte(c.X5tS256), []byte(CertThumbprint(cert))) != 1 {
+		return ErrTokenBinding
+	}
+	return nil
+}
+
+// VerifyProof - checks the request carries a DPoP proof signed by the key the token is bound to, p is nil when the
+// request carries none. Tokens not bound to a DPoP key are accepted.
+func (c Confirmation) VerifyProof(p *Proof) error {
+	if c.JKT == "" {
+		return nil
+	}
+	if p == nil || subtle.ConstantTimeCompare([]byte(c.JKT), []byte(p.JKT)) != 1 {
+		return ErrTokenBinding
+	}
+	return nil
+}
+
+// claim - the `cnf` claim, nil when the token is not bound.
+func (c Confirmation) claim() map[string]interface{} {
+	if !c.Bound() {
+		return nil
+	}
+	cnf := map[string]interface{}{}
+	if c.X5tS256 != "" {
+		cnf[x5tS256] = c.X5tS256
+	}
+	if c.JKT != "" {
+		cnf[jkt] = c.JKT
+	}
+	return cnf
+}
+
+// confirmation - the confirmation of the `cnf` claim of mc, if any.
+func confirmation(mc jwt.MapClaims) Confirmation {
+	cnf, _ := mc["cnf"].(map[string]interface{})
+	c := Confirmation{}
+	c.X5tS256, _ = cnf[x5tS256].(string)
+	c.JKT, _ = cnf[jkt].(string)
+	return c
+}
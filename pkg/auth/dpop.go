@@ -0,0 +1,294 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"github.com/qredo-external/go-rnov/pkg/storage"
+	"github.com/qredo-external/go-rnov/pkg/trace"
+)
+
+const (
+	// DPoPHeader - request header carrying the DPoP proof.
+	DPoPHeader = "DPoP"
+	// DPoPNonceHeader - response header carrying the nonce the next proofs must include.
+	DPoPNonceHeader = "DPoP-Nonce"
+
+	// dpopType - JWS `typ` header of DPoP proofs.
+	dpopType = "dpop+jwt"
+	// minRSABits - smallest RSA key accepted to sign proofs.
+	minRSABits = 2048
+)
+
+// dpopMethods - asymmetric algorithms proofs may be signed with, the key comes with the proof so HMAC and none make
+// no sense.
+var dpopMethods = []string{"ES256", "ES384", "ES512", "RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}
+
+// Proof - a verified DPoP proof, JKT is the RFC 7638 thumbprint of the key it was signed with.
+type Proof struct {
+	JKT      string
+	ID       string
+	Method   string
+	URL      string
+	IssuedAt time.Time
+}
+
+// ProofRequest - the request a DPoP proof is presented with, AccessToken is empty when requesting a token.
+type ProofRequest struct {
+	Method      string
+	URL         string
+	AccessToken string
+}
+
+// NewProofRequest - the request r as its proofs must describe it, the URL without query nor fragment.
+func NewProofRequest(r *http.Request, accessToken string) ProofRequest {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	u := url.URL{Scheme: scheme, Host: r.Host, Path: r.URL.Path}
+	return ProofRequest{Method: r.Method, URL: u.String(), AccessToken: accessToken}
+}
+
+// ProofVerifier - verifies DPoP proofs (RFC 9449), errors wrap ErrDPoPProof or ErrDPoPNonce.
+type ProofVerifier interface {
+	VerifyProof(ctx context.Context, proof string, req ProofRequest) (*Proof, error)
+	// Nonce - the nonce proofs must currently carry, empty when none is required.
+	Nonce() string
+}
+
+// DPoP - verifies DPoP proofs, each is accepted once within window of its `iat`. When nonces are required they are
+// derived from the secret and the time, so replicas sharing the secret accept each other's.
+type DPoP struct {
+	replay   storage.ReplayCache
+	window   time.Duration
+	nonceKey []byte
+	base     *url.URL
+	now      func() time.Time
+}
+
+// NewDPoP - DPoP constructor, replay records the `jti` of the proofs and requireNonce makes proofs carry a nonce
+// issued by the service, which rotates every window. base is the URL clients reach the service at when it is behind
+// a proxy, e.g. one terminating TLS: `htu` is then checked against it rather than the scheme and host the request
+// arrived with. nil checks it against the request.
+func NewDPoP(secret string, replay storage.ReplayCache, window time.Duration, requireNonce bool, base *url.URL) *DPoP {
+	d := &DPoP{
+		replay: replay,
+		window: window,
+		base:   base,
+		now:    time.Now,
+	}
+	if requireNonce {
+		mac := hmac.New(sha256.New, []byte(secret))
+		_, _ = mac.Write([]byte("dpop-nonce"))
+		d.nonceKey = mac.Sum(nil)
+	}
+	return d
+}
+
+// VerifyProof - checks proof is a DPoP proof of req, signed by the key in its header, recent, not replayed and
+// carrying the current nonce if required.
+func (d *DPoP) VerifyProof(ctx context.Context, proof string, req ProofRequest) (*Proof, error) {
+	ctx, span := trace.Start(ctx, "DPoP.VerifyProof", trace.KindInternal, trace.Attr("http.method", req.Method))
+	defer span.End()
+	p, err := d.verifyProof(ctx, proof, req)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	return p, nil
+}
+
+func (d *DPoP) verifyProof(ctx context.Context, proof string, req ProofRequest) (*Proof, error) {
+	if proof == "" {
+		return nil, fmt.Errorf("%w: missing %s header", ErrDPoPProof, DPoPHeader)
+	}
+	p := &Proof{}
+	// note the time claims are checked below against the window, jwt-go would reject an `iat` slightly ahead
+	parser := &jwt.Parser{ValidMethods: dpopMethods, SkipClaimsValidation: true}
+	token, err := parser.Parse(proof, func(t *jwt.Token) (interface{}, error) {
+		if typ, _ := t.Header["typ"].(string); typ != dpopType {
+			return nil, fmt.Errorf("unexpected type %q", t.Header["typ"])
+		}
+		key, thumbprint, err := parseJWK(t.Header["jwk"])
+		p.JKT = thumbprint
+		return key, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDPoPProof, err.Error())
+	}
+	mc, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("%w: invalid claims", ErrDPoPProof)
+	}
+	p.ID, _ = mc["jti"].(string)
+	p.Method, _ = mc["htm"].(string)
+	p.URL, _ = mc["htu"].(string)
+	iat, _ := mc["iat"].(float64)
+	p.IssuedAt = time.Unix(int64(iat), 0)
+	if p.ID == "" || p.Method == "" || p.URL == "" || iat == 0 {
+		return nil, fmt.Errorf("%w: jti, htm, htu and iat are required", ErrDPoPProof)
+	}
+	if p.Method != req.Method {
+		return nil, fmt.Errorf("%w: htm %s does not match the request", ErrDPoPProof, p.Method)
+	}
+	if !sameURL(p.URL, d.externalURL(req.URL)) {
+		return nil, fmt.Errorf("%w: htu %s does not match the request", ErrDPoPProof, p.URL)
+	}
+	now := d.now()
+	if p.IssuedAt.Before(now.Add(-d.window)) || p.IssuedAt.After(now.Add(d.window)) {
+		return nil, fmt.Errorf("%w: iat out of the accepted window", ErrDPoPProof)
+	}
+	if req.AccessToken != "" {
+		ath, _ := mc["ath"].(string)
+		if !hmac.Equal([]byte(ath), []byte(accessTokenHash(req.AccessToken))) {
+			return nil, fmt.Errorf("%w: ath does not match the access token", ErrDPoPProof)
+		}
+	}
+	if d.nonceKey != nil {
+		nonce, _ := mc["nonce"].(string)
+		if !d.validNonce(nonce) {
+			return nil, ErrDPoPNonce
+		}
+	}
+	// note a proof is rejected once past the window, so its jti only needs to be remembered until then
+	fresh, err := d.replay.Use(ctx, "dpop:"+p.JKT+":"+p.ID, p.IssuedAt.Add(d.window+time.Second))
+	if err != nil {
+		return nil, fmt.Errorf("error checking dpop replay: %w", err)
+	}
+	if !fresh {
+		return nil, fmt.Errorf("%w: proof replayed", ErrDPoPProof)
+	}
+	return p, nil
+}
+
+// Nonce - the nonce proofs must currently carry, empty when none is required.
+func (d *DPoP) Nonce() string {
+	if d.nonceKey == nil {
+		return ""
+	}
+	return d.nonceAt(d.period())
+}
+
+// validNonce - the nonce of the current period or, so one handed out just before the rotation is usable, of the
+// previous one.
+func (d *DPoP) validNonce(nonce string) bool {
+	period := d.period()
+	return hmac.Equal([]byte(nonce), []byte(d.nonceAt(period))) || hmac.Equal([]byte(nonce), []byte(d.nonceAt(period-1)))
+}
+
+func (d *DPoP) period() int64 {
+	if d.window <= 0 {
+		return 0
+	}
+	return d.now().UnixNano() / int64(d.window)
+}
+
+func (d *DPoP) nonceAt(period int64) string {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(period))
+	mac := hmac.New(sha256.New, d.nonceKey)
+	_, _ = mac.Write(b[:])
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// accessTokenHash - the `ath` claim of proofs presented with token.
+func accessTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// externalURL - the URL of a request as clients reach it, the base URL, if any, replaces its scheme and host and
+// prefixes its path.
+func (d *DPoP) externalURL(u string) string {
+	if d.base == nil {
+		return u
+	}
+	ru, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	ext := url.URL{Scheme: d.base.Scheme, Host: d.base.Host, Path: strings.TrimSuffix(d.base.Path, "/") + ru.Path}
+	return ext.String()
+}
+
+// sameURL - whether the URLs are equal once query and fragment are dropped, scheme and host are case insensitive.
+func sameURL(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(ua.Scheme, ub.Scheme) && strings.EqualFold(ua.Host, ub.Host) && ua.EscapedPath() == ub.EscapedPath()
+}
+
+// parseJWK - the public key of a JWK (RFC 7517) header along with its RFC 7638 thumbprint.
+func parseJWK(v interface{}) (interface{}, string, error) {
+	jwk, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, "", errors.New("missing jwk header")
+	}
+	if _, private := jwk["d"]; private {
+		return nil, "", errors.New("jwk holds a private key")
+	}
+	member := func(name string) string {
+		s, _ := jwk[name].(string)
+		return s
+	}
+	var key interface{}
+	var canonical string
+	switch member("kty") {
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[member("crv")]
+		if !ok {
+			return nil, "", fmt.Errorf("unsupported curve %q", member("crv"))
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(member("x"))
+		y, errY := base64.RawURLEncoding.DecodeString(member("y"))
+		size := (curve.Params().BitSize + 7) / 8
+		if errX != nil || errY != nil || len(x) != size || len(y) != size {
+			return nil, "", errors.New("invalid ec coordinates")
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, "", errors.New("ec point not on the curve")
+		}
+		key = pub
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, member("crv"), member("x"), member("y"))
+	case "RSA":
+		n, errN := base64.RawURLEncoding.DecodeString(member("n"))
+		e, errE := base64.RawURLEncoding.DecodeString(member("e"))
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			return nil, "", errors.New("invalid rsa key")
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if pub.N.BitLen() < minRSABits {
+			return nil, "", fmt.Errorf("rsa key shorter than %d bits", minRSABits)
+		}
+		key = pub
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, member("e"), member("n"))
+	default:
+		return nil, "", fmt.Errorf("unsupported key type %q", member("kty"))
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return key, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	// ErrTokenMalformed - the token is not a well formed JWT.
+	ErrTokenMalformed = errors.New("malformed token")
+	// ErrTokenSignature - the token was not signed by the service, or with an unexpected algorithm.
+	ErrTokenSignature = errors.New("invalid token signature")
+	// ErrTokenExpired - the token was valid but its expiration time passed.
+	ErrTokenExpired = errors.New("token expired")
+	// ErrTokenNotValidYet - the token is not valid before a time in the future.
+	ErrTokenNotValidYet = errors.New("token not valid yet")
+	// ErrTokenType - the token is not an access token, e.g. a receipt.
+	ErrTokenType = errors.New("invalid token type")
+	// ErrTokenClaims - the claims of the token are not valid.
+	ErrTokenClaims = errors.New("invalid token claims")
+	// ErrTokenBinding - the token is bound to a key the client did not prove possession of.
+	ErrTokenBinding = errors.New("token not bound to the presented key")
+	// ErrDPoPProof - the DPoP proof is missing, malformed, signed by another key or for another request, or replayed.
+	ErrDPoPProof = errors.New("invalid dpop proof")
+	// ErrDPoPNonce - the DPoP proof does not carry the current nonce, the client must retry with the one provided.
+	ErrDPoPNonce = errors.New("dpop nonce required")
+	// ErrNoSigningKey - the service has no key to sign tokens with.
+	ErrNoSigningKey = errors.New("no signing key")
+)
+
+// tokenError - classifies the errors of jwt-go as the errors of the package, the original error is kept in the
+// message. Signature problems take precedence, the time claims of a forged token mean nothing.
+func tokenError(err error) error {
+	var ve *jwt.ValidationError
+	if !errors.As(err, &ve) {
+		return fmt.Errorf("%w: %s", ErrTokenClaims, err.Error())
+	}
+	var kind error
+	switch {
+	case ve.Errors&jwt.ValidationErrorMalformed != 0:
+		kind = ErrTokenMalformed
+	case ve.Errors&(jwt.ValidationErrorSignatureInvalid|jwt.ValidationErrorUnverifiable) != 0:
+		kind = ErrTokenSignature
+	case ve.Errors&jwt.ValidationErrorExpired != 0:
+		kind = ErrTokenExpired
+	case ve.Errors&(jwt.ValidationErrorNotValidYet|jwt.ValidationErrorIssuedAt) != 0:
+		kind = ErrTokenNotValidYet
+	default:
+		kind = ErrTokenClaims
+	}
+	return fmt.Errorf("%w: %s", kind, err.Error())
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// receiptType - JWS `typ` header that tells receipts apart from access tokens signed with the same key.
+const receiptType = "receipt+jwt"
+
+// Receipt - signed statement that the service computed Result over the document identified by Digest for Subject,
+// Options holds the canonical form of the request options that changed how the result was computed, if any.
+type Receipt struct {
+	Result   string `json:"result"`
+	Digest   string `json:"digest"`
+	Options  string `json:"opts,omitempty"`
+	Subject  string `json:"sub"`
+	IssuedAt int64  `json:"iat"`
+}
+
+// Valid - satisfies jwt.Claims, a receipt never expires but must be complete.
+func (rc Receipt) Valid() error {
+	if rc.Result == "" || rc.Digest == "" || rc.Subject == "" || rc.IssuedAt == 0 {
+		return errors.New("incomplete receipt")
+	}
+	return nil
+}
+
+// Covers - checks whether the receipt was issued for the given raw document.
+func (rc Receipt) Covers(document []byte) bool {
+	return subtle.ConstantTimeCompare([]byte(rc.Digest), []byte(DocumentDigest(document))) == 1
+}
+
+// DocumentDigest - hex encoded SHA256 of the raw document, as referenced by receipts.
+func DocumentDigest(document []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(document))
+}
+
+// Receipts - defines the operations to issue and verify signed receipts.
+type Receipts interface {
+	SignReceipt(rc Receipt) (string, error)
+	VerifyReceipt(receipt string) (*Receipt, error)
+}
+
+// SignReceipt - creates a compact JWS (HS256) over the receipt using the service key.
+func (a Auth) SignReceipt(rc Receipt) (string, error) {
+	if err := rc.Valid(); err != nil {
+		return "", err
+	}
+	t := jwt.NewWithClaims(jwt.SigningMethodHS256, rc)
+	t.Header["typ"] = receiptType
+	return t.SignedString([]byte(a.secret))
+}
+
+// VerifyReceipt - checks the signature and type of a receipt and returns its content.
+func (a Auth) VerifyReceipt(receipt string) (*Receipt, error) {
+	rc := &Receipt{}
+	token, err := jwt.ParseWithClaims(receipt, rc, a.keyFunc)
+	if err != nil {
+		return nil, tokenError(err)
+	}
+	if typ, _ := token.Header["typ"].(string); typ != receiptType || !token.Valid {
+		return nil, ErrTokenType
+	}
+	return rc, nil
+}
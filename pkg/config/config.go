@@ -0,0 +1,303 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/qredo-external/go-rnov/pkg/http/tlsconfig"
+	"github.com/qredo-external/go-rnov/pkg/logger"
+	"github.com/qredo-external/go-rnov/pkg/ratelimit"
+	"github.com/qredo-external/go-rnov/pkg/service"
+)
+
+// Config - settings of the service. Every setting has a key (e.g. `server.addr`) used in files, as a flag
+// (`--server.addr`) and, upper cased with dots as underscores, as an environment variable (`SERVER_ADDR`).
+type Config struct {
+	Server    Server
+	TLS       TLS
+	Auth      Auth
+	Log       Log
+	Trace     Trace
+	Sum       Sum
+	Jobs      Jobs
+	History   History
+	Cache     Cache
+	RateLimit RateLimit
+	Quota     Quota
+}
+
+// Server - address and timeouts of the HTTP server, deadlines of the routes and sizes of the bodies they read.
+type Server struct {
+	Addr              string
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	DrainDelay        time.Duration
+	ShutdownTimeout   time.Duration
+	SumTimeout        time.Duration
+	BatchTimeout      time.Duration
+	MaxDocumentSize   int
+	MaxBatchSize      int
+}
+
+// TLS - certificate of the server, plaintext HTTP is served when CertFile is empty, and verification of the client
+// certificates.
+type TLS struct {
+	CertFile       string
+	KeyFile        string
+	ClientCAFile   string
+	ClientAuth     string
+	MinVersion     string
+	CipherSuites   string
+	ReloadInterval time.Duration
+}
+
+// Enabled - whether HTTPS is served.
+func (t TLS) Enabled() bool {
+	return t.CertFile != ""
+}
+
+// Options - the settings parsed for tlsconfig.NewReloader.
+func (t TLS) Options() (tlsconfig.Options, error) {
+	opts := tlsconfig.Options{CertFile: t.CertFile, KeyFile: t.KeyFile, ClientCAFile: t.ClientCAFile}
+	var err error
+	if opts.ClientAuth, err = tlsconfig.ParseClientAuth(t.ClientAuth); err != nil {
+		return opts, err
+	}
+	if opts.MinVersion, err = tlsconfig.ParseVersion(t.MinVersion); err != nil {
+		return opts, err
+	}
+	opts.CipherSuites, err = tlsconfig.ParseCipherSuites(t.CipherSuites)
+	return opts, err
+}
+
+// Auth - key tokens and receipts are signed with, lifetime of the tokens and verification of the DPoP proofs.
+// DPoPBaseURL is the URL clients reach the service at when it is behind a proxy, empty when they reach it directly.
+type Auth struct {
+	Secret      Secret
+	TokenTTL    time.Duration
+	DPoPWindow  time.Duration
+	DPoPNonce   bool
+	DPoPBaseURL string
+}
+
+// DPoPBase - the base URL parsed for auth.NewDPoP, nil when not set. It must be an absolute http or https URL without
+// query nor fragment.
+func (a Auth) DPoPBase() (*url.URL, error) {
+	if a.DPoPBaseURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(a.DPoPBaseURL)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("%s is not an absolute http or https URL without query nor fragment", a.DPoPBaseURL)
+	}
+	return u, nil
+}
+
+type Log struct {
+	Level logger.Level
+}
+
+// Trace - span exporter, `none`, `stdout` or `otlp` writing to File.
+type Trace struct {
+	Exporter string
+	File     string
+}
+
+// Sum - goroutines walking a document, 0 for GOMAXPROCS, and elements a container needs to be split among them.
+type Sum struct {
+	Workers        int
+	SplitThreshold int
+}
+
+// Jobs - workers computing asynchronous sums, jobs they can have waiting and time finished jobs are kept.
+type Jobs struct {
+	Workers   int
+	QueueSize int
+	Retention time.Duration
+}
+
+// History - computations kept per subject, the oldest are removed past it, zero keeps them all.
+type History struct {
+	MaxPerSubject int
+}
+
+// Cache - entries of the result cache and time they are kept.
+type Cache struct {
+	Size int
+	TTL  time.Duration
+}
+
+// RateLimit - requests each client can make to the routes, `off` lets them all through.
+type RateLimit struct {
+	Auth  ratelimit.Limit
+	Sum   ratelimit.Limit
+	Batch ratelimit.Limit
+	Read  ratelimit.Limit
+}
+
+// Quota - operations and bytes processed each subject is allowed per period, `day` or `month`, zero does not limit.
+type Quota struct {
+	Operations int
+	Bytes      int
+	Period     string
+}
+
+// Secret - a sensitive setting, formatted as logger.Redacted when set so it never ends up in logs or output by
+// mistake.
+type Secret string
+
+// Value - the secret itself.
+func (s Secret) Value() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return logger.Redacted
+}
+
+func (s Secret) GoString() string {
+	return s.String()
+}
+
+// Default - settings when no source sets them, the secret has no default.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Addr:              ":8080",
+			ReadHeaderTimeout: time.Second * 10,
+			ReadTimeout:       time.Minute * 6,
+			WriteTimeout:      time.Minute * 6,
+			IdleTimeout:       time.Minute * 2,
+			DrainDelay:        time.Second * 5,
+			ShutdownTimeout:   time.Second * 30,
+			SumTimeout:        time.Second * 30,
+			BatchTimeout:      time.Minute * 5,
+			MaxDocumentSize:   10 << 20,
+			MaxBatchSize:      100 << 20,
+		},
+		TLS:     TLS{ClientAuth: "none", MinVersion: "1.2", ReloadInterval: time.Second * 30},
+		Auth:    Auth{TokenTTL: time.Hour, DPoPWindow: time.Minute, DPoPNonce: true},
+		Log:     Log{Level: logger.LevelInfo},
+		Trace:   Trace{Exporter: "none", File: "traces.jsonl"},
+		Sum:     Sum{SplitThreshold: service.DefaultSplitThreshold},
+		Jobs:    Jobs{Workers: 4, QueueSize: 100, Retention: time.Hour},
+		History: History{MaxPerSubject: 1000},
+		Cache:   Cache{Size: 1024, TTL: time.Minute * 10},
+		RateLimit: RateLimit{
+			Auth:  ratelimit.Limit{Requests: 10, Period: time.Minute},
+			Sum:   ratelimit.Limit{Requests: 60, Period: time.Minute},
+			Batch: ratelimit.Limit{Requests: 10, Period: time.Minute},
+			Read:  ratelimit.Limit{Requests: 300, Period: time.Minute},
+		},
+		Quota: Quota{Period: "month"},
+	}
+}
+
+// setting - a setting of Config, value points to its field.
+type setting struct {
+	key   string
+	help  string
+	value interface{}
+}
+
+// settings - every setting of c, in the order they are printed.
+func (c *Config) settings() []setting {
+	return []setting{
+		{"server.addr", "address the server listens on", &c.Server.Addr},
+		{"server.read_header_timeout", "time to read the request headers", &c.Server.ReadHeaderTimeout},
+		{"server.read_timeout", "time to read the whole request, must exceed server.batch_timeout", &c.Server.ReadTimeout},
+		{"server.write_timeout", "time to write the response, must exceed server.batch_timeout", &c.Server.WriteTimeout},
+		{"server.idle_timeout", "time a keep-alive connection waits for the next request", &c.Server.IdleTimeout},
+		{"server.drain_delay", "time readiness fails before the listener closes on shutdown", &c.Server.DrainDelay},
+		{"server.shutdown_timeout", "time in-flight requests get to finish on shutdown", &c.Server.ShutdownTimeout},
+		{"server.sum_timeout", "deadline of /sum and /aggregate/{operation}", &c.Server.SumTimeout},
+		{"server.batch_timeout", "deadline of /sum/batch", &c.Server.BatchTimeout},
+		{"server.max_document_size", "bytes of a request body, and of each document of a batch", &c.Server.MaxDocumentSize},
+		{"server.max_batch_size", "bytes of the body of /sum/batch", &c.Server.MaxBatchSize},
+		{"tls.cert_file", "PEM certificate chain, HTTPS is served when set", &c.TLS.CertFile},
+		{"tls.key_file", "PEM private key of the certificate", &c.TLS.KeyFile},
+		{"tls.client_ca_file", "PEM bundle client certificates are verified against", &c.TLS.ClientCAFile},
+		{"tls.client_auth", "none, request, verify_if_given or require", &c.TLS.ClientAuth},
+		{"tls.min_version", "1.2 or 1.3", &c.TLS.MinVersion},
+		{"tls.cipher_suites", "comma separated TLS 1.2 suites, Go defaults when empty", &c.TLS.CipherSuites},
+		{"tls.reload_interval", "time between checks of the certificate files for changes", &c.TLS.ReloadInterval},
+		{"auth.secret", "key signing tokens, at least 32 bytes, may be a file:// or env:// reference", &c.Auth.Secret},
+		{"auth.token_ttl", "lifetime of the tokens", &c.Auth.TokenTTL},
+		{"auth.dpop_window", "time a DPoP proof is accepted around its iat, and a nonce before it rotates", &c.Auth.DPoPWindow},
+		{"auth.dpop_nonce", "whether DPoP proofs must carry a nonce issued by the service", &c.Auth.DPoPNonce},
+		{"auth.dpop_base_url", "URL clients reach the service at behind a proxy, DPoP proofs are checked against it", &c.Auth.DPoPBaseURL},
+		{"log.level", "debug, info, warn or error", &c.Log.Level},
+		{"trace.exporter", "none, stdout or otlp", &c.Trace.Exporter},
+		{"trace.file", "file the otlp exporter appends to", &c.Trace.File},
+		{"sum.workers", "goroutines walking a document, 0 for GOMAXPROCS and 1 to walk it sequentially", &c.Sum.Workers},
+		{"sum.split_threshold", "elements of an array or object from which it is split among the goroutines", &c.Sum.SplitThreshold},
+		{"jobs.workers", "workers computing asynchronous sums", &c.Jobs.Workers},
+		{"jobs.queue_size", "jobs waiting for a worker", &c.Jobs.QueueSize},
+		{"jobs.retention", "time finished jobs can be polled before they are removed", &c.Jobs.Retention},
+		{"history.max_per_subject", "computations kept per subject, 0 keeps them all", &c.History.MaxPerSubject},
+		{"cache.size", "entries of the result cache", &c.Cache.Size},
+		{"cache.ttl", "time results are cached", &c.Cache.TTL},
+		{"ratelimit.auth", "requests/period to /auth per IP, or off", &c.RateLimit.Auth},
+		{"ratelimit.sum", "requests/period to /sum and /aggregate/{operation} per subject, or off", &c.RateLimit.Sum},
+		{"ratelimit.batch", "requests/period to /sum/batch per subject, or off", &c.RateLimit.Batch},
+		{"ratelimit.read", "requests/period to the history, jobs, receipts and usage routes per subject, or off", &c.RateLimit.Read},
+		{"quota.operations", "sums and aggregations each subject can make per period, 0 for no quota", &c.Quota.Operations},
+		{"quota.bytes", "bytes of documents each subject can send per period, 0 for no quota", &c.Quota.Bytes},
+		{"quota.period", "day or month, quotas reset at its start in UTC", &c.Quota.Period},
+	}
+}
+
+// set - parses v into the field of the setting.
+func (s setting) set(v string) error {
+	var err error
+	switch p := s.value.(type) {
+	case *string:
+		*p = v
+	case *Secret:
+		*p = Secret(v)
+	case *time.Duration:
+		*p, err = time.ParseDuration(v)
+	case *int:
+		*p, err = strconv.Atoi(v)
+	case *bool:
+		*p, err = strconv.ParseBool(v)
+	case *logger.Level:
+		*p, err = logger.ParseLevel(v)
+	case *ratelimit.Limit:
+		*p, err = ratelimit.ParseLimit(v)
+	default:
+		err = fmt.Errorf("unsupported type %T", p)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.key, err)
+	}
+	return nil
+}
+
+// get - the field of the setting formatted as set parses it, secrets redacted.
+func (s setting) get() string {
+	switch p := s.value.(type) {
+	case *string:
+		return *p
+	case *time.Duration:
+		return p.String()
+	case *int:
+		return fmt.Sprint(*p)
+	case *bool:
+		return strconv.FormatBool(*p)
+	case fmt.Stringer:
+		return p.String()
+	default:
+		return fmt.Sprint(p)
+	}
+}
@@ -0,0 +1,226 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qredo-external/go-rnov/pkg/logger"
+	"github.com/qredo-external/go-rnov/pkg/ratelimit"
+)
+
+const aSecret = "aSecretOfAtLeastThirtyTwoBytes.."
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func env(vars map[string]string) func(string) string {
+	return func(name string) string {
+		return vars[name]
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	yamlFile := writeFile(t, dir, "config.yaml", `
+server:
+  addr: ":9090"
+  sum_timeout: 10s
+auth:
+  secret: file://`+writeFile(t, dir, "secret", aSecret+"\n")+`
+log:
+  level: debug
+jobs:
+  workers: 2
+sum:
+  split_threshold: 1024
+`)
+	tomlFile := writeFile(t, dir, "config.toml", `
+[server]
+addr = ":7070"
+[auth]
+secret = "env://JWT_SECRET"
+token_ttl = "5m"
+`)
+
+	tests := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		expected func(c *Config)
+	}{
+		{
+			name: "yaml file",
+			args: []string{"--config", yamlFile},
+			expected: func(c *Config) {
+				c.Server.Addr, c.Server.SumTimeout, c.Log.Level, c.Jobs.Workers = ":9090", time.Second*10, logger.LevelDebug, 2
+				c.Sum.SplitThreshold = 1024
+			},
+		},
+		{
+			name: "env over file",
+			env:  map[string]string{"CONFIG_FILE": yamlFile, "SERVER_ADDR": ":6060", "JOBS_WORKERS": "8", "SUM_WORKERS": "3"},
+			expected: func(c *Config) {
+				c.Server.Addr, c.Server.SumTimeout, c.Log.Level, c.Jobs.Workers = ":6060", time.Second*10, logger.LevelDebug, 8
+				c.Sum.Workers, c.Sum.SplitThreshold = 3, 1024
+			},
+		},
+		{
+			name: "flags over env",
+			args: []string{"--config", yamlFile, "--server.addr", ":5050", "--log.level=warn", "--ratelimit.batch", "off", "--sum.split_threshold", "100"},
+			env:  map[string]string{"SERVER_ADDR": ":6060", "RATELIMIT_BATCH": "5/s", "RATELIMIT_SUM": "5/s", "SUM_SPLIT_THRESHOLD": "512"},
+			expected: func(c *Config) {
+				c.Server.Addr, c.Server.SumTimeout, c.Log.Level, c.Jobs.Workers = ":5050", time.Second*10, logger.LevelWarn, 2
+				c.Sum.SplitThreshold = 100
+				c.RateLimit.Sum, c.RateLimit.Batch = ratelimit.Limit{Requests: 5, Period: time.Second}, ratelimit.Limit{}
+			},
+		},
+		{
+			name: "toml file with env secret",
+			args: []string{"--config", tomlFile},
+			env:  map[string]string{"JWT_SECRET": aSecret},
+			expected: func(c *Config) {
+				c.Server.Addr, c.Auth.TokenTTL = ":7070", time.Minute*5
+			},
+		},
+		{
+			name: "literal secret",
+			env:  map[string]string{"AUTH_SECRET": aSecret},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, printConfig, err := Load(test.args, env(test.env))
+			if err != nil || printConfig {
+				t.Fatalf("non-nil error : %v", err)
+			}
+			expected := Default()
+			expected.Auth.Secret = aSecret
+			if test.expected != nil {
+				test.expected(expected)
+			}
+			if fmt.Sprintf("%+v", *cfg) != fmt.Sprintf("%+v", *expected) || cfg.Auth.Secret.Value() != aSecret {
+				t.Errorf("expected %+v got %+v", *expected, *cfg)
+			}
+		})
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	typo := writeFile(t, dir, "typo.yaml", "server:\n  adr: \":9090\"\n")
+
+	tests := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		expected string
+	}{
+		{name: "missing secret", expected: "auth.secret must be at least 32 bytes"},
+		{name: "short secret", env: map[string]string{"AUTH_SECRET": "jdnfksdmfksd"}, expected: "auth.secret must be at least"},
+		{name: "unset env secret", env: map[string]string{"AUTH_SECRET": "env://NOPE"}, expected: "NOPE is not set"},
+		{name: "missing secret file", args: []string{"--auth.secret", "file://" + filepath.Join(dir, "nope")}, expected: "auth.secret"},
+		{name: "unknown key", args: []string{"--config", typo}, env: map[string]string{"AUTH_SECRET": aSecret}, expected: "unknown setting server.adr"},
+		{name: "bad duration", env: map[string]string{"AUTH_SECRET": aSecret, "CACHE_TTL": "soon"}, expected: "cache.ttl"},
+		{name: "bad bool", env: map[string]string{"AUTH_SECRET": aSecret, "AUTH_DPOP_NONCE": "maybe"}, expected: "auth.dpop_nonce"},
+		{name: "bad rate limit", env: map[string]string{"AUTH_SECRET": aSecret, "RATELIMIT_SUM": "60 per minute"}, expected: "ratelimit.sum"},
+		{name: "bad level", args: []string{"--log.level", "loud"}, env: map[string]string{"AUTH_SECRET": aSecret}, expected: "log.level"},
+		{name: "tls key without cert", env: map[string]string{"AUTH_SECRET": aSecret, "TLS_KEY_FILE": "key.pem"}, expected: "tls.cert_file and tls.key_file must be set together"},
+		{
+			name:     "tls client verification",
+			args:     []string{"--tls.cert_file", "cert.pem", "--tls.key_file", "key.pem", "--tls.client_auth", "require", "--tls.min_version", "1.1"},
+			env:      map[string]string{"AUTH_SECRET": aSecret},
+			expected: "tls.client_ca_file is required to verify client certificates; tls.min_version must be 1.2 or 1.3",
+		},
+		{name: "batch smaller than a document", env: map[string]string{"AUTH_SECRET": aSecret, "SERVER_MAX_BATCH_SIZE": "1024"}, expected: "server.max_batch_size must not be below server.max_document_size"},
+		{name: "bad sum parallelism", env: map[string]string{"AUTH_SECRET": aSecret, "SUM_WORKERS": "-1", "SUM_SPLIT_THRESHOLD": "0"}, expected: "sum.workers must not be negative; sum.split_threshold must be positive"},
+		{name: "bad quota period", env: map[string]string{"AUTH_SECRET": aSecret, "QUOTA_PERIOD": "week", "QUOTA_BYTES": "-1"}, expected: "quota.bytes must not be negative; quota.period must be day or month"},
+		{name: "relative dpop base url", env: map[string]string{"AUTH_SECRET": aSecret, "AUTH_DPOP_BASE_URL": "api.example.com"}, expected: "auth.dpop_base_url: api.example.com is not an absolute http or https URL"},
+		{name: "insecure cipher suite", env: map[string]string{"AUTH_SECRET": aSecret, "TLS_CIPHER_SUITES": "TLS_RSA_WITH_RC4_128_SHA"}, expected: "insecure cipher suite"},
+		{
+			name:     "every problem",
+			args:     []string{"--server.read_timeout", "1m", "--server.write_timeout", "1m", "--trace.exporter", "zipkin", "--jobs.workers", "0"},
+			env:      map[string]string{"AUTH_SECRET": aSecret},
+			expected: "server.read_timeout must exceed server.batch_timeout; server.write_timeout must exceed server.batch_timeout; trace.exporter must be none, stdout or otlp; jobs.workers must be positive",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := Load(test.args, env(test.env))
+			if !errors.Is(err, ErrInvalid) || !strings.Contains(err.Error(), test.expected) {
+				t.Errorf("expected invalid configuration with %q got %v", test.expected, err)
+			}
+		})
+	}
+	if _, _, err := Load([]string{"--help"}, env(nil)); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("expected help got %v", err)
+	}
+}
+
+func TestConfig_Write(t *testing.T) {
+	cfg, printConfig, err := Load([]string{"--print-config", "--server.addr", ":9090", "--sum.workers", "2"}, env(map[string]string{"AUTH_SECRET": aSecret}))
+	if err != nil || !printConfig {
+		t.Fatalf("expected configuration to print got %v %t", err, printConfig)
+	}
+	if s := fmt.Sprintf("%v %+v %#v", cfg.Auth.Secret, cfg.Auth, cfg.Auth.Secret); strings.Contains(s, aSecret) {
+		t.Errorf("expected secret to be redacted got %s", s)
+	}
+	buf := &bytes.Buffer{}
+	if err := cfg.Write(buf); err != nil {
+		t.Fatalf("non-nil error : %s", err.Error())
+	}
+	if strings.Contains(buf.String(), aSecret) || !strings.Contains(buf.String(), "secret: '[REDACTED]'") {
+		t.Errorf("expected secret to be redacted got %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "sum:\n  workers: 2 #") || !strings.Contains(buf.String(), "split_threshold: 8192 #") {
+		t.Errorf("expected sum parallelism to be printed got %s", buf.String())
+	}
+
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	printed := writeFile(t, dir, "printed.yaml", buf.String())
+	loaded, _, err := Load([]string{"--config", printed}, env(map[string]string{"AUTH_SECRET": aSecret}))
+	if err != nil {
+		t.Fatalf("expected printed configuration to load got %v", err)
+	}
+	if fmt.Sprintf("%+v", *loaded) != fmt.Sprintf("%+v", *cfg) {
+		t.Errorf("expected %+v got %+v", *cfg, *loaded)
+	}
+}
+
+func TestAuth_DPoPBase(t *testing.T) {
+	if u, err := (Auth{}).DPoPBase(); u != nil || err != nil {
+		t.Errorf("expected no base URL got %v %v", u, err)
+	}
+	if u, err := (Auth{DPoPBaseURL: "https://api.example.com"}).DPoPBase(); err != nil || u.Host != "api.example.com" {
+		t.Errorf("expected base URL got %v %v", u, err)
+	}
+	for _, s := range []string{"api.example.com", "/rnov", "ftp://api.example.com", "https://api.example.com/?a=b", "https://api.example.com/#a"} {
+		if _, err := (Auth{DPoPBaseURL: s}).DPoPBase(); err == nil {
+			t.Errorf("expected %s to be rejected", s)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package config
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	"github.com/qredo-external/go-rnov/pkg/http/tlsconfig"
+)
+
+const (
+	// fileEnv - environment variable naming the config file, the --config flag takes precedence.
+	fileEnv = "CONFIG_FILE"
+
+	fileRef = "file://"
+	envRef  = "env://"
+)
+
+// ErrInvalid - the configuration can not be used, the error lists every problem found.
+var ErrInvalid = errors.New("invalid configuration")
+
+// Load - the configuration from, in increasing precedence, the defaults, the file given by --config or CONFIG_FILE
+// (YAML, or TOML when it ends in .toml), the environment and the flags of args. Secret references are then resolved
+// and the result validated. printConfig tells whether --print-config was given, the configuration is then returned as
+// loaded so it can be printed even when it is not valid. flag.ErrHelp is returned for --help.
+func Load(args []string, getenv func(string) string) (cfg *Config, printConfig bool, err error) {
+	cfg = Default()
+	settings := cfg.settings()
+
+	fs := flag.NewFlagSet("service", flag.ContinueOnError)
+	file := fs.String("config", getenv(fileEnv), "YAML or TOML config file")
+	fs.BoolVar(&printConfig, "print-config", false, "print the effective configuration, secrets redacted, and exit")
+	flags := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		flags[s.key] = fs.String(s.key, "", fmt.Sprintf("%s (env %s)", s.help, envName(s.key)))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, false, err
+	}
+
+	if *file != "" {
+		values, err := readFile(*file)
+		if err != nil {
+			return nil, false, err
+		}
+		if err := apply(settings, values); err != nil {
+			return nil, false, fmt.Errorf("%w: %s: %s", ErrInvalid, *file, err.Error())
+		}
+	}
+
+	env := make(map[string]string)
+	for _, s := range settings {
+		if v := getenv(envName(s.key)); v != "" {
+			env[s.key] = v
+		}
+	}
+	if err := apply(settings, env); err != nil {
+		return nil, false, fmt.Errorf("%w: environment: %s", ErrInvalid, err.Error())
+	}
+
+	set := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		if v, ok := flags[f.Name]; ok {
+			set[f.Name] = *v
+		}
+	})
+	if err := apply(settings, set); err != nil {
+		return nil, false, fmt.Errorf("%w: flags: %s", ErrInvalid, err.Error())
+	}
+
+	if printConfig {
+		return cfg, true, nil
+	}
+	if err := cfg.resolveSecrets(getenv); err != nil {
+		return nil, false, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, false, err
+	}
+	return cfg, printConfig, nil
+}
+
+// envName - environment variable of a setting key, e.g. SERVER_ADDR for server.addr.
+func envName(key string) string {
+	return strings.ToUpper(strings.Replace(key, ".", "_", -1))
+}
+
+// apply - sets the settings of values, keys that are not settings are an error so typos do not go unnoticed.
+func apply(settings []setting, values map[string]string) error {
+	byKey := make(map[string]setting, len(settings))
+	for _, s := range settings {
+		byKey[s.key] = s
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s, ok := byKey[k]
+		if !ok {
+			return fmt.Errorf("unknown setting %s", k)
+		}
+		if err := s.set(values[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFile - the settings of a YAML or TOML file flattened to their keys.
+func readFile(name string) (map[string]string, error) {
+	raw, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	doc := map[string]interface{}{}
+	if strings.EqualFold(filepath.Ext(name), ".toml") {
+		err = toml.Unmarshal(raw, &doc)
+	} else {
+		err = yaml.Unmarshal(raw, &doc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %s", ErrInvalid, name, err.Error())
+	}
+	values := make(map[string]string)
+	flatten("", doc, values)
+	return values, nil
+}
+
+func flatten(prefix string, doc map[string]interface{}, values map[string]string) {
+	for k, v := range doc {
+		key := prefix + k
+		if nested, ok := v.(map[string]interface{}); ok {
+			flatten(key+".", nested, values)
+			continue
+		}
+		if v == nil {
+			// note empty values, e.g. `cipher_suites:` as printed for an empty string
+			values[key] = ""
+			continue
+		}
+		values[key] = fmt.Sprint(v)
+	}
+}
+
+// resolveSecrets - replaces file:// references by the content of the file, without its trailing new line, and
+// env:// references by the value of the variable.
+func (c *Config) resolveSecrets(getenv func(string) string) error {
+	for _, s := range c.settings() {
+		p, ok := s.value.(*Secret)
+		if !ok {
+			continue
+		}
+		v := p.Value()
+		switch {
+		case strings.HasPrefix(v, fileRef):
+			raw, err := ioutil.ReadFile(strings.TrimPrefix(v, fileRef))
+			if err != nil {
+				return fmt.Errorf("%w: %s: %s", ErrInvalid, s.key, err.Error())
+			}
+			*p = Secret(strings.TrimRight(string(raw), "\r\n"))
+		case strings.HasPrefix(v, envRef):
+			name := strings.TrimPrefix(v, envRef)
+			if *p = Secret(getenv(name)); *p == "" {
+				return fmt.Errorf("%w: %s: environment variable %s is not set", ErrInvalid, s.key, name)
+			}
+		}
+	}
+	return nil
+}
+
+// minSecretSize - RFC 7518 asks HS256 keys to be at least as long as the hash.
+const minSecretSize = 32
+
+// Validate - checks the settings can be used together, every problem is reported.
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+	check(c.Server.Addr != "" && strings.Contains(c.Server.Addr, ":"), "server.addr must be host:port")
+	for _, s := range c.settings() {
+		if d, ok := s.value.(*time.Duration); ok {
+			// note a zero drain delay closes the listener right away, other zero durations would disable a timeout.
+			check(*d > 0 || (*d == 0 && s.key == "server.drain_delay"), "%s must be positive", s.key)
+		}
+	}
+	check(c.Server.ReadTimeout > c.Server.BatchTimeout, "server.read_timeout must exceed server.batch_timeout")
+	check(c.Server.WriteTimeout > c.Server.BatchTimeout, "server.write_timeout must exceed server.batch_timeout")
+	check(c.Server.MaxDocumentSize > 0, "server.max_document_size must be positive")
+	check(c.Server.MaxBatchSize >= c.Server.MaxDocumentSize, "server.max_batch_size must not be below server.max_document_size")
+	check((c.TLS.CertFile == "") == (c.TLS.KeyFile == ""), "tls.cert_file and tls.key_file must be set together")
+	clientAuth, err := tlsconfig.ParseClientAuth(c.TLS.ClientAuth)
+	check(err == nil, "tls.client_auth must be none, request, verify_if_given or require")
+	check(clientAuth < tls.VerifyClientCertIfGiven || c.TLS.ClientCAFile != "",
+		"tls.client_ca_file is required to verify client certificates")
+	check(clientAuth == tls.NoClientCert || c.TLS.Enabled(), "tls.client_auth requires tls.cert_file")
+	_, err = tlsconfig.ParseVersion(c.TLS.MinVersion)
+	check(err == nil, "tls.min_version must be 1.2 or 1.3")
+	_, err = tlsconfig.ParseCipherSuites(c.TLS.CipherSuites)
+	check(err == nil, "tls.cipher_suites: %v", err)
+	check(len(c.Auth.Secret) >= minSecretSize, "auth.secret must be at least %d bytes", minSecretSize)
+	_, err = c.Auth.DPoPBase()
+	check(err == nil, "auth.dpop_base_url: %v", err)
+	check(c.Trace.Exporter == "none" || c.Trace.Exporter == "stdout" || c.Trace.Exporter == "otlp",
+		"trace.exporter must be none, stdout or otlp")
+	check(c.Trace.Exporter != "otlp" || c.Trace.File != "", "trace.file is required by the otlp exporter")
+	check(c.Sum.Workers >= 0, "sum.workers must not be negative")
+	check(c.Sum.SplitThreshold > 0, "sum.split_threshold must be positive")
+	check(c.Jobs.Workers > 0, "jobs.workers must be positive")
+	check(c.Jobs.QueueSize > 0, "jobs.queue_size must be positive")
+	check(c.History.MaxPerSubject >= 0, "history.max_per_subject must not be negative")
+	check(c.Cache.Size >= 0, "cache.size must not be negative")
+	check(c.Quota.Operations >= 0, "quota.operations must not be negative")
+	check(c.Quota.Bytes >= 0, "quota.bytes must not be negative")
+	check(c.Quota.Period == "day" || c.Quota.Period == "month", "quota.period must be day or month")
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalid, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Write - writes the configuration as YAML, loadable with --config once the secrets are set back.
+func (c *Config) Write(w io.Writer) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	sections := map[string]*yaml.Node{}
+	for _, s := range c.settings() {
+		i := strings.Index(s.key, ".")
+		section, name := s.key[:i], s.key[i+1:]
+		node, ok := sections[section]
+		if !ok {
+			node = &yaml.Node{Kind: yaml.MappingNode}
+			sections[section] = node
+			root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: section}, node)
+		}
+		node.Content = append(node.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: name},
+			&yaml.Node{Kind: yaml.ScalarNode, Value: s.get(), LineComment: s.help},
+		)
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(root); err != nil {
+		return err
+	}
+	return enc.Close()
+}
@@ -0,0 +1,138 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTimeout - time a check is given before it is considered failed.
+const DefaultTimeout = time.Second * 2
+
+// ErrShuttingDown - the service is draining, it must not receive new traffic.
+var ErrShuttingDown = errors.New("shutting down")
+
+// Checker - a dependency the service needs to serve traffic, Check returns nil when it is usable.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc - adapts a function to a Checker, e.g. `health.CheckerFunc(store.Ping)`.
+type CheckerFunc func(ctx context.Context) error
+
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Result - outcome of one check.
+type Result struct {
+	Name     string
+	Err      error
+	Duration time.Duration
+}
+
+// Report - outcome of every check, the service is ready when none failed.
+type Report struct {
+	Ready   bool
+	Results []Result
+}
+
+// Registry - named checkers run to decide readiness.
+type Registry struct {
+	mu       sync.RWMutex
+	checkers map[string]Checker
+	timeout  time.Duration
+}
+
+// NewRegistry - registry constructor, each check is bounded by timeout (DefaultTimeout when not positive).
+func NewRegistry(timeout time.Duration) *Registry {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Registry{checkers: make(map[string]Checker), timeout: timeout}
+}
+
+// Register - adds a checker, replacing the one with the same name if any.
+func (r *Registry) Register(name string, c Checker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checkers[name] = c
+}
+
+// Check - runs every checker concurrently, results are sorted by name. A checker still running when its timeout
+// expires fails with the context error.
+func (r *Registry) Check(ctx context.Context) Report {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.checkers))
+	for name := range r.checkers {
+		names = append(names, name)
+	}
+	checkers := make(map[string]Checker, len(r.checkers))
+	for k, v := range r.checkers {
+		checkers[k] = v
+	}
+	r.mu.RUnlock()
+	sort.Strings(names)
+
+	rep := Report{Ready: true, Results: make([]Result, len(names))}
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			rep.Results[i] = r.run(ctx, name, checkers[name])
+		}(i, name)
+	}
+	wg.Wait()
+	for _, res := range rep.Results {
+		if res.Err != nil {
+			rep.Ready = false
+		}
+	}
+	return rep
+}
+
+// run - runs a checker bounded by the timeout, a checker that panics fails.
+func (r *Registry) run(ctx context.Context, name string, c Checker) Result {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- fmt.Errorf("check panicked: %v", p)
+			}
+		}()
+		done <- c.Check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return Result{Name: name, Err: err, Duration: time.Since(start)}
+}
+
+// Shutdown - checker that fails once the service starts shutting down, so readiness flips before it stops serving.
+type Shutdown struct {
+	draining int32
+}
+
+// Start - the service is shutting down.
+func (s *Shutdown) Start() {
+	atomic.StoreInt32(&s.draining, 1)
+}
+
+// Check - fails with ErrShuttingDown once Start was called.
+func (s *Shutdown) Check(context.Context) error {
+	if atomic.LoadInt32(&s.draining) == 1 {
+		return ErrShuttingDown
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRegistry_Check(t *testing.T) {
+	shutdown := &Shutdown{}
+	r := NewRegistry(time.Millisecond * 50)
+	r.Register("ok", CheckerFunc(func(ctx context.Context) error { return nil }))
+	r.Register("shutdown", shutdown)
+
+	if rep := r.Check(context.Background()); !rep.Ready || len(rep.Results) != 2 {
+		t.Fatalf("expected ready with 2 results got %+v", rep)
+	}
+
+	shutdown.Start()
+	r.Register("failing", CheckerFunc(func(ctx context.Context) error { return errors.New("unreachable") }))
+	r.Register("slow", CheckerFunc(func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}))
+	r.Register("panicking", CheckerFunc(func(ctx context.Context) error { panic("boom") }))
+
+	rep := r.Check(context.Background())
+	if rep.Ready {
+		t.Fatalf("expected not ready got %+v", rep)
+	}
+	expected := map[string]error{
+		"failing":   errors.New("unreachable"),
+		"ok":        nil,
+		"panicking": errors.New("check panicked: boom"),
+		"shutdown":  ErrShuttingDown,
+		"slow":      context.DeadlineExceeded,
+	}
+	names := []string{"failing", "ok", "panicking", "shutdown", "slow"}
+	for i, res := range rep.Results {
+		if res.Name != names[i] {
+			t.Errorf("expected results sorted by name, %s at %d got %s", names[i], i, res.Name)
+		}
+		if want := expected[res.Name]; (want == nil) != (res.Err == nil) || (want != nil && want.Error() != res.Err.Error()) {
+			t.Errorf("error in check %s: expected %v got %v", res.Name, want, res.Err)
+		}
+	}
+}
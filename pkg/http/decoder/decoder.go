@@ -0,0 +1,225 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"mime"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// ErrUnsupportedMediaType - there is no decoder registered for the content type.
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+	// ErrNonFinite - the document holds an infinite or NaN number, which the operations can not handle.
+	ErrNonFinite = errors.New("non finite number")
+)
+
+// Decoder - turns a raw document into the value model consumed by the operations, the one produced by encoding/json
+// into an interface{}: nil, bool, float64, string, []interface{} and map[string]interface{}.
+type Decoder interface {
+	Decode(raw []byte) (interface{}, error)
+}
+
+// Func - adapts a function to a Decoder.
+type Func func(raw []byte) (interface{}, error)
+
+// Decode - calls f(raw).
+func (f Func) Decode(raw []byte) (interface{}, error) {
+	return f(raw)
+}
+
+// Registry - decoders by media type, the zero value is not usable, use NewRegistry or Default.
+type Registry struct {
+	decoders map[string]Decoder
+	fallback string
+}
+
+// NewRegistry - registry constructor, fallback is the media type used when a request has no content type.
+func NewRegistry(fallback string) *Registry {
+	return &Registry{
+		decoders: make(map[string]Decoder),
+		fallback: fallback,
+	}
+}
+
+// Default - registry with the json, yaml, cbor, msgpack and toml decoders, json being the fallback.
+func Default() *Registry {
+	r := NewRegistry("application/json")
+	r.Register(Func(JSON), "application/json")
+	r.Register(Func(YAML), "application/yaml", "application/x-yaml", "text/yaml")
+	r.Register(Func(CBOR), "application/cbor")
+	r.Register(Func(MessagePack), "application/msgpack", "application/x-msgpack", "application/vnd.msgpack")
+	r.Register(Func(TOML), "application/toml")
+	return r
+}
+
+// Register - sets the decoder for the given media types, replacing any previous one.
+func (r *Registry) Register(d Decoder, mediaTypes ...string) {
+	for _, mt := range mediaTypes {
+		r.decoders[strings.ToLower(mt)] = d
+	}
+}
+
+// Lookup - returns the decoder for a Content-Type header value, parameters are ignored and structured syntax
+// suffixes (`application/foo+json`) fall back to the suffix type.
+func (r *Registry) Lookup(contentType string) (Decoder, error) {
+	mt := r.fallback
+	if contentType != "" {
+		var err error
+		if mt, _, err = mime.ParseMediaType(contentType); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedMediaType, err.Error())
+		}
+	}
+	if d, ok := r.decoders[mt]; ok {
+		return d, nil
+	}
+	if i := strings.LastIndex(mt, "+"); i >= 0 {
+		if d, ok := r.decoders["application/"+mt[i+1:]]; ok {
+			return d, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedMediaType, mt)
+}
+
+// JSON - numbers are float64, trailing data after the first value is ignored.
+func JSON(raw []byte) (interface{}, error) {
+	var v interface{}
+	// note due the nature of v (interface{}) if we send a broken json structure it will not be detected by decode
+	// it's out of scope and has no trivial solution.
+	if err := json.NewDecoder(bytes.NewReader(raw)).Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// YAML - only the first document of a stream is decoded. Integers (any base) and floats become float64, `.inf`
+// and `.nan` are rejected, timestamps become RFC 3339 strings and non string keys are formatted as strings.
+func YAML(raw []byte) (interface{}, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	return normalize(v)
+}
+
+// CBOR - integers, bignums (tags 2 and 3) and half, single and double precision floats become float64, other tags
+// are replaced by their content, byte strings become base64 strings and undefined becomes nil.
+func CBOR(raw []byte) (interface{}, error) {
+	var v interface{}
+	if err := cbor.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	return normalize(v)
+}
+
+// MessagePack - integers of any width and float32/float64 become float64, binary becomes a base64 string and
+// timestamps become RFC 3339 strings.
+func MessagePack(raw []byte) (interface{}, error) {
+	var v interface{}
+	if err := msgpack.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	return normalize(v)
+}
+
+// TOML - the document is always a table. Integers and floats become float64, `inf` and `nan` are rejected and
+// date/time values become RFC 3339 strings (local ones as UTC).
+func TOML(raw []byte) (interface{}, error) {
+	var v map[string]interface{}
+	if err := toml.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	return normalize(v)
+}
+
+// normalize - converts the values produced by the format libraries into the encoding/json value model.
+// note integers beyond 2^53 lose precision exactly as they do when decoded from json.
+func normalize(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case nil, bool, string:
+		return v, nil
+	case float64:
+		return finite(v)
+	case float32:
+		return finite(float64(v))
+	case int:
+		return float64(v), nil
+	case int8:
+		return float64(v), nil
+	case int16:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint:
+		return float64(v), nil
+	case uint8:
+		return float64(v), nil
+	case uint16:
+		return float64(v), nil
+	case uint32:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case big.Int:
+		return normalize(&v)
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(v).Float64()
+		return finite(f)
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case cbor.Tag:
+		return normalize(v.Content)
+	case cbor.SimpleValue:
+		return nil, nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		res := make([]interface{}, rv.Len())
+		for i := range res {
+			e, err := normalize(rv.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			res[i] = e
+		}
+		return res, nil
+	case reflect.Map:
+		res := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			e, err := normalize(iter.Value().Interface())
+			if err != nil {
+				return nil, err
+			}
+			res[fmt.Sprint(iter.Key().Interface())] = e
+		}
+		return res, nil
+	default:
+		return nil, fmt.Errorf("unsupported value of type %T", v)
+	}
+}
+
+func finite(f float64) (interface{}, error) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return nil, ErrNonFinite
+	}
+	return f, nil
+}
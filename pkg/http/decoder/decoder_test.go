@@ -0,0 +1,143 @@
+package decoder
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func mustCBOR(t *testing.T, v interface{}) []byte {
+	b, err := cbor.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func mustMsgpack(t *testing.T, v interface{}) []byte {
+	b, err := msgpack.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDecoders(t *testing.T) {
+	bigNum, _ := new(big.Int).SetString("18446744073709551616", 10)
+	ts := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		decode      Func
+		raw         []byte
+		expected    interface{}
+		expectedErr error
+	}{
+		{
+			name:     "json",
+			decode:   JSON,
+			raw:      []byte(`{"a":[1,2.5,"3"],"b":null}`),
+			expected: map[string]interface{}{"a": []interface{}{float64(1), 2.5, "3"}, "b": nil},
+		},
+		{
+			name:     "yaml integers in any base and floats",
+			decode:   YAML,
+			raw:      []byte("a: [1, 0x1F, 0o17, 2.5, '3']\nb: ~\n1: true\n"),
+			expected: map[string]interface{}{"a": []interface{}{float64(1), float64(31), float64(15), 2.5, "3"}, "b": nil, "1": true},
+		},
+		{
+			name:     "yaml timestamps become strings",
+			decode:   YAML,
+			raw:      []byte("a: 2021-03-01T10:00:00Z\n"),
+			expected: map[string]interface{}{"a": "2021-03-01T10:00:00Z"},
+		},
+		{
+			name:        "yaml infinity",
+			decode:      YAML,
+			raw:         []byte("a: .inf\n"),
+			expectedErr: ErrNonFinite,
+		},
+		{
+			name:     "cbor integers, floats, bignums, tags and byte strings",
+			decode:   CBOR,
+			raw:      mustCBOR(t, []interface{}{uint64(7), int64(-3), float32(1.5), bigNum, cbor.Tag{Number: 1000, Content: 4}, []byte{1}}),
+			expected: []interface{}{float64(7), float64(-3), 1.5, 18446744073709551616.0, float64(4), "AQ=="},
+		},
+		{
+			name:     "cbor non string keys",
+			decode:   CBOR,
+			raw:      mustCBOR(t, map[int]int{1: 2}),
+			expected: map[string]interface{}{"1": float64(2)},
+		},
+		{
+			name:     "msgpack integer widths, floats and timestamps",
+			decode:   MessagePack,
+			raw:      mustMsgpack(t, map[string]interface{}{"a": []interface{}{int8(-1), uint16(300), int64(1) << 40, float32(0.5)}, "t": ts}),
+			expected: map[string]interface{}{"a": []interface{}{float64(-1), float64(300), float64(1 << 40), 0.5}, "t": "2021-03-01T10:00:00Z"},
+		},
+		{
+			name:     "toml tables, arrays of tables and dates",
+			decode:   TOML,
+			raw:      []byte("a = 1\nb = 0xff\nd = 1979-05-27\n[[items]]\nprice = 2.5\n[[items]]\nprice = 3\n"),
+			expected: map[string]interface{}{"a": float64(1), "b": float64(255), "d": "1979-05-27T00:00:00Z", "items": []interface{}{map[string]interface{}{"price": 2.5}, map[string]interface{}{"price": float64(3)}}},
+		},
+		{
+			name:        "toml nan",
+			decode:      TOML,
+			raw:         []byte("a = nan\n"),
+			expectedErr: ErrNonFinite,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := test.decode(test.raw)
+			if test.expectedErr != nil {
+				if !errors.Is(err, test.expectedErr) {
+					t.Errorf("expected error %v got %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("non-nil error : %s", err.Error())
+			}
+			if !reflect.DeepEqual(res, test.expected) {
+				t.Errorf("expected %#v got %#v", test.expected, res)
+			}
+		})
+	}
+}
+
+func TestRegistry_Lookup(t *testing.T) {
+	tests := []struct {
+		contentType string
+		expectedErr error
+	}{
+		{contentType: ""},
+		{contentType: "application/json; charset=utf-8"},
+		{contentType: "application/problem+json"},
+		{contentType: "Application/YAML"},
+		{contentType: "application/cbor"},
+		{contentType: "application/vnd.msgpack"},
+		{contentType: "application/toml"},
+		{contentType: "text/plain", expectedErr: ErrUnsupportedMediaType},
+		{contentType: "not a media type", expectedErr: ErrUnsupportedMediaType},
+	}
+
+	r := Default()
+	for _, test := range tests {
+		t.Run(test.contentType, func(t *testing.T) {
+			d, err := r.Lookup(test.contentType)
+			if !errors.Is(err, test.expectedErr) {
+				t.Errorf("expected error %v got %v", test.expectedErr, err)
+			}
+			if err == nil && d == nil {
+				t.Error("expected a decoder")
+			}
+		})
+	}
+}
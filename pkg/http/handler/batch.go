@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/qredo-external/go-rnov/pkg/http/decoder"
+	response "github.com/qredo-external/go-rnov/pkg/http/json"
+	"github.com/qredo-external/go-rnov/pkg/http/problem"
+	"github.com/qredo-external/go-rnov/pkg/service"
+)
+
+const (
+	ndjsonType  = "application/x-ndjson"
+	jsonSeqType = "application/json-seq"
+	// recordSeparator - RFC 7464 json-seq record prefix.
+	recordSeparator = 0x1E
+)
+
+// batchReaders - how each batch media type is split into records, records longer than max are skipped.
+var batchReaders = map[string]func(r *bufio.Reader, max int) ([]byte, error){
+	ndjsonType:              readLine,
+	"application/jsonl":     readLine,
+	"application/jsonlines": readLine,
+	jsonSeqType:             readSeqRecord,
+}
+
+// BatchSumHandler - handler for Sum over a batch of documents, one per line (ndjson) or record (json-seq). Results
+// are streamed in the same framing, in order, as soon as each one is computed; a document that fails only produces
+// an error for its own line, a document over the quota of the subject or too large included. A batch cut off by its
+// deadline ends with an error for the first line not computed.
+func (oh *OperationHandler) BatchSumHandler(w http.ResponseWriter, r *http.Request) {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	read, ok := batchReaders[mt]
+	if err != nil || !ok {
+		problem.Write(w, r, fmt.Errorf("%w: %s", errUnsupportedMediaType, r.Header.Get("Content-Type")))
+		return
+	}
+	opts := optionsFromQuery(r.URL.Query())
+	respType := ndjsonType
+	if mt == jsonSeqType {
+		respType = jsonSeqType
+	}
+
+	w.Header().Set("Content-Type", respType)
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	in := bufio.NewReader(r.Body)
+	for line := 1; ; line++ {
+		record, readErr := read(in, oh.maxRecord)
+		var res *response.BatchResult
+		switch {
+		case errors.Is(readErr, errDocumentTooLarge):
+			res, readErr = &response.BatchResult{Line: line, Error: readErr.Error()}, nil
+		case (readErr == nil || readErr == io.EOF) && len(bytes.TrimSpace(record)) > 0:
+			// note a record cut off by a read error is incomplete, only the error is reported
+			sum := oh.sumRecord(r, line, record, opts)
+			res = &sum
+		}
+		if res != nil {
+			if err := writeBatchResult(w, respType, *res); err != nil {
+				// note the client is gone, nothing else to do
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if readErr == io.EOF {
+			return
+		}
+		if err := r.Context().Err(); err != nil {
+			// note deadline reached or client gone, the client is told the remaining lines are not computed
+			_ = writeBatchResult(w, respType, response.BatchResult{
+				Line:  line + 1,
+				Error: service.AsError(err).Message + ": the documents from this line on were not computed",
+			})
+			return
+		}
+		if readErr != nil {
+			msg := "unable to read body: " + readErr.Error()
+			var tooLarge *http.MaxBytesError
+			if errors.As(readErr, &tooLarge) {
+				msg = fmt.Sprintf("batch too large: over %d bytes", tooLarge.Limit)
+			}
+			_ = writeBatchResult(w, respType, response.BatchResult{Line: line, Error: msg})
+			return
+		}
+	}
+}
+
+// sumRecord - sums a document of a batch, the result or the error of its line.
+func (oh *OperationHandler) sumRecord(r *http.Request, line int, record []byte, opts service.Options) response.BatchResult {
+	res := response.BatchResult{Line: line}
+	// note the framing is not part of the document, so it is neither counted against the quota nor recorded
+	doc := bytes.TrimSpace(record)
+	start := time.Now()
+	data, err := decoder.JSON(record)
+	if err != nil {
+		res.Error = "invalid document: " + err.Error()
+		return res
+	}
+	rsv, err := oh.reserve(r, len(doc))
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	if sum, err := oh.operations.Sum(r.Context(), data, opts); err != nil {
+		res.Error = err.Error()
+	} else if res.ID, err = oh.record(r, service.OperationSum, doc, sum.Hash, start); err != nil {
+		res.Error = err.Error()
+	} else {
+		res.Result = sum.Hash
+		return res
+	}
+	oh.refund(r, rsv)
+	return res
+}
+
+// readLine - reads up to and including the next new line, io.EOF is returned along with the last line.
+func readLine(r *bufio.Reader, max int) ([]byte, error) {
+	return readRecord(r, '\n', max)
+}
+
+// readSeqRecord - reads up to the next record separator, the separator is not part of the record.
+func readSeqRecord(r *bufio.Reader, max int) ([]byte, error) {
+	for {
+		record, err := readRecord(r, recordSeparator, max)
+		if err == nil {
+			record = record[:len(record)-1]
+			// note the leading separator of the first record produces an empty one
+			if len(record) == 0 {
+				continue
+			}
+		}
+		return record, err
+	}
+}
+
+// readRecord - reads up to and including delim. A record longer than max, when positive, is skipped up to delim
+// without holding it in memory and errDocumentTooLarge returned, so the records after it can still be read.
+func readRecord(r *bufio.Reader, delim byte, max int) ([]byte, error) {
+	var record []byte
+	for {
+		chunk, err := r.ReadSlice(delim)
+		size := len(record) + len(chunk)
+		if err == nil {
+			size--
+		}
+		if max > 0 && size > max {
+			for err == bufio.ErrBufferFull {
+				_, err = r.ReadSlice(delim)
+			}
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: over %d bytes", errDocumentTooLarge, max)
+		}
+		record = append(record, chunk...)
+		if err != bufio.ErrBufferFull {
+			return record, err
+		}
+	}
+}
+
+func writeBatchResult(w io.Writer, respType string, res response.BatchResult) error {
+	body, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	if respType == jsonSeqType {
+		body = append([]byte{recordSeparator}, body...)
+	}
+	_, err = w.Write(append(body, '\n'))
+	return err
+}
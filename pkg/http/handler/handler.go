@@ -1,23 +1,62 @@
 package handler
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
+	"github.com/gorilla/mux"
+
+	"github.com/qredo-external/go-rnov/pkg/auth"
+	"github.com/qredo-external/go-rnov/pkg/http/decoder"
 	response "github.com/qredo-external/go-rnov/pkg/http/json"
+	"github.com/qredo-external/go-rnov/pkg/http/problem"
+	"github.com/qredo-external/go-rnov/pkg/logger"
 	"github.com/qredo-external/go-rnov/pkg/service"
+	"github.com/qredo-external/go-rnov/pkg/storage"
 	"github.com/qredo-external/go-rnov/pkg/user"
 )
 
+const (
+	// receiptParam - query parameter that asks for a signed receipt along with the operation result.
+	receiptParam = "receipt"
+	// explainParam - query parameter that asks for the detail of every value found along with the sum result.
+	explainParam = "explain"
+	// operationVar - route variable holding the aggregation name.
+	operationVar = "operation"
+	// cacheHeader - tells whether the result was served from the result cache.
+	cacheHeader = "X-Cache"
+)
+
+var (
+	errInvalidRequest       = service.NewError(service.KindInvalid, "invalid-request", "invalid request")
+	errInvalidDocument      = service.NewError(service.KindInvalid, "invalid-document", "invalid document")
+	errUnsupportedMediaType = service.NewError(service.KindUnsupported, "unsupported-media-type", "unsupported media type")
+	errReceiptsNotOffered   = service.NewError(service.KindNotImplemented, "receipts-not-offered", "signed receipts are not offered")
+	errReceiptMismatch      = service.NewError(service.KindUnprocessable, "receipt-mismatch", "receipt does not cover the document")
+	errDocumentTooLarge     = service.NewError(service.KindTooLarge, "document-too-large", "document too large")
+	// note the token endpoint answers a proof without the nonce with 400 (RFC 9449 section 8), protected routes with 401
+	errDPoPNonceRequired = service.NewError(service.KindInvalid, service.ErrDPoPNonce.Code, service.ErrDPoPNonce.Message)
+)
+
 // AuthHandler - holds the service that manages auth operation
 type AuthHandler struct {
 	Auth service.Authorizer
+	DPoP auth.ProofVerifier
 }
 
-// NewAuthHandler - auth handler constructor
-func NewAuthHandler(auth service.Authorizer) *AuthHandler {
+// NewAuthHandler - auth handler constructor, dpop nil issues bearer tokens to the clients sending a DPoP proof.
+func NewAuthHandler(auth service.Authorizer, dpop auth.ProofVerifier) *AuthHandler {
 	return &AuthHandler{
 		Auth: auth,
+		DPoP: dpop,
 	}
 }
 
@@ -27,24 +66,29 @@ func (a *AuthHandler) CreateAuthHandler(w http.ResponseWriter, r *http.Request)
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields()
 	if err := dec.Decode(usr); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		problem.Write(w, r, fmt.Errorf("%w: %s", errInvalidRequest, err.Error()))
+		return
+	}
+	cnf, err := a.confirmation(w, r)
+	if err != nil {
+		problem.Write(w, r, err)
 		return
 	}
-	JWTRes, err := a.Auth.CreateAuth(*usr)
+	JWTRes, err := a.Auth.CreateAuth(r.Context(), *usr, cnf)
 	if err != nil {
-		// note this could be either a Status Bad Request or a InternalError, for
-		// simplicity i've left out the custom errors from the design please refer to readme.
-		w.WriteHeader(http.StatusBadRequest)
+		problem.Write(w, r, err)
 		return
 	}
 
 	rBody := &response.JWT{
 		JWT: JWTRes,
 	}
+	if cnf.JKT != "" {
+		rBody.TokenType = dpopTokenType
+	}
 	body, jsonErr := json.Marshal(rBody)
 	if jsonErr != nil {
-		// note should log error
-		w.WriteHeader(http.StatusInternalServerError)
+		problem.Write(w, r, jsonErr)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -52,42 +96,360 @@ func (a *AuthHandler) CreateAuthHandler(w http.ResponseWriter, r *http.Request)
 	_, _ = w.Write(body)
 }
 
-// OperationHandler - holds the service that manage operations (sum)
+// dpopTokenType - `token_type` of the tokens bound to a DPoP key, sent with the DPoP authorization scheme.
+const dpopTokenType = "DPoP"
+
+// confirmation - binds the token to the client certificate of the connection when there is one (RFC 8705), so it is
+// only usable over a connection authenticated by the same certificate, and to the key of the DPoP proof of the
+// request when there is one (RFC 9449), so it is only usable along with proofs signed by the same key.
+func (a *AuthHandler) confirmation(w http.ResponseWriter, r *http.Request) (auth.Confirmation, error) {
+	cnf := auth.Confirmation{}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		cnf.X5tS256 = auth.CertThumbprint(r.TLS.PeerCertificates[0])
+	}
+	proofs := r.Header.Values(auth.DPoPHeader)
+	if a.DPoP == nil || len(proofs) == 0 {
+		return cnf, nil
+	}
+	if nonce := a.DPoP.Nonce(); nonce != "" {
+		w.Header().Set(auth.DPoPNonceHeader, nonce)
+	}
+	if len(proofs) > 1 {
+		return cnf, fmt.Errorf("%w: more than one %s header", auth.ErrDPoPProof, auth.DPoPHeader)
+	}
+	proof, err := a.DPoP.VerifyProof(r.Context(), proofs[0], auth.NewProofRequest(r, ""))
+	if errors.Is(err, auth.ErrDPoPNonce) {
+		return cnf, fmt.Errorf("%w: %s", errDPoPNonceRequired, err.Error())
+	}
+	if err != nil {
+		return cnf, err
+	}
+	cnf.JKT = proof.JKT
+	return cnf, nil
+}
+
+// OperationHandler - holds the service that manage operations (sum) and the decoders of the accepted formats
 type OperationHandler struct {
 	operations service.Operations
+	receipts   service.Receipter
+	history    service.Historian
+	quotas     service.Quotas
+	metrics    service.Metrics
+	decoders   *decoder.Registry
+	maxRecord  int
 }
 
-// NewOperationHandler - operation handler constructor
-func NewOperationHandler(op service.Operations) *OperationHandler {
+// NewOperationHandler - operation handler constructor, receipts may be nil when signed receipts are not offered,
+// history nil when computations are not recorded, quotas nil when usage is not limited, metrics nil when sums are
+// not measured and decoders nil to accept the default formats. maxRecord bounds the size of each document of a batch,
+// 0 does not.
+func NewOperationHandler(op service.Operations, rc service.Receipter, hs service.Historian, qt service.Quotas, mt service.Metrics, dec *decoder.Registry, maxRecord int) *OperationHandler {
+	if mt == nil {
+		mt = service.NopMetrics()
+	}
+	if dec == nil {
+		dec = decoder.Default()
+	}
 	return &OperationHandler{
 		operations: op,
+		receipts:   rc,
+		history:    hs,
+		quotas:     qt,
+		metrics:    mt,
+		decoders:   dec,
+		maxRecord:  maxRecord,
 	}
 }
 
 // SumHandler - handler for Sum operation
 func (oh *OperationHandler) SumHandler(w http.ResponseWriter, r *http.Request) {
-	var jsonMap interface{}
-	dec := json.NewDecoder(r.Body)
-	// note due the nature of jsonMap (interface{}) if we send a broken json structure it will not be detected by decode
-	// it's out of scope and has no trivial solution.
-	if err := dec.Decode(&jsonMap); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	raw, jsonMap, err := oh.decodeDocument(r)
+	if err != nil {
+		problem.Write(w, r, err)
 		return
 	}
-
-	sumRes, err := oh.operations.Sum(jsonMap)
+	rsv, err := oh.reserve(r, len(raw))
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		problem.Write(w, r, err)
 		return
 	}
+	// note the usage is given back unless the result is served, so neither failures nor a 304 are counted
+	served := false
+	defer func() {
+		if !served {
+			oh.refund(r, rsv)
+		}
+	}()
 
-	rBody := &response.Operation{
-		Result: sumRes,
+	opts := optionsFromQuery(r.URL.Query())
+	withReceipt := r.URL.Query().Get(receiptParam) == "true"
+	rBody := &response.Operation{}
+	start := time.Now()
+	if r.URL.Query().Get(explainParam) == "true" {
+		exp, err := oh.operations.Explain(r.Context(), jsonMap, opts)
+		if err != nil {
+			problem.Write(w, r, err)
+			return
+		}
+		rBody.Result, rBody.Explanation = exp.Hash, toExplanation(exp)
+		oh.metrics.SumComputed(len(raw), time.Since(start), false)
+		if rBody.ID, err = oh.record(r, service.OperationSum, raw, exp.Hash, start); err != nil {
+			problem.Write(w, r, err)
+			return
+		}
+	} else {
+		res, err := oh.operations.Sum(r.Context(), jsonMap, opts)
+		if err != nil {
+			problem.Write(w, r, err)
+			return
+		}
+		rBody.Result, rBody.Digest, rBody.Cached = res.Hash, res.Digest, res.Cached
+		oh.metrics.SumComputed(len(raw), time.Since(start), res.Cached)
+		if res.Cached {
+			w.Header().Set(cacheHeader, "HIT")
+		} else if res.Digest != "" {
+			w.Header().Set(cacheHeader, "MISS")
+		}
+		// note receipts carry their issue time, so responses with them are never the same representation.
+		if res.Digest != "" && !withReceipt {
+			etag := entityTag(res.Digest, opts)
+			w.Header().Set("ETag", etag)
+			if matchesETag(r.Header.Get("If-None-Match"), etag) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		if rBody.ID, err = oh.record(r, service.OperationSum, raw, res.Hash, start); err != nil {
+			problem.Write(w, r, err)
+			return
+		}
+	}
+	sumRes := rBody.Result
+	if withReceipt {
+		if oh.receipts == nil {
+			problem.Write(w, r, errReceiptsNotOffered)
+			return
+		}
+		rc := auth.Receipt{
+			Result:  sumRes,
+			Digest:  auth.DocumentDigest(raw),
+			Options: opts.String(),
+			Subject: subject(r),
+		}
+		if rBody.Receipt, err = oh.receipts.IssueReceipt(rc); err != nil {
+			problem.Write(w, r, err)
+			return
+		}
 	}
 	body, jsonErr := json.Marshal(rBody)
 	if jsonErr != nil {
-		// note should log error
-		w.WriteHeader(http.StatusInternalServerError)
+		problem.Write(w, r, jsonErr)
+		return
+	}
+	served = true
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(body)
+}
+
+// record - records the computation of op in the history of the subject, if there is one, returning its id.
+func (oh *OperationHandler) record(r *http.Request, op string, raw []byte, result string, start time.Time) (string, error) {
+	if oh.history == nil {
+		return "", nil
+	}
+	c, err := oh.history.RecordComputation(storage.Computation{
+		Subject:   subject(r),
+		Operation: op,
+		CreatedAt: start.UTC(),
+		Digest:    auth.DocumentDigest(raw),
+		Size:      len(raw),
+		Result:    result,
+		Duration:  time.Since(start),
+	})
+	return c.ID, err
+}
+
+// reserve - counts an operation over size bytes against the quota of the subject before it is made, if usage is
+// limited.
+func (oh *OperationHandler) reserve(r *http.Request, size int) (service.Reservation, error) {
+	if oh.quotas == nil {
+		return service.Reservation{}, nil
+	}
+	return oh.quotas.ReserveUsage(r.Context(), subject(r), size)
+}
+
+// refund - gives back the usage reserved for an operation whose result was not served. The request context may be
+// done already, past its deadline for instance, so the refund does not depend on it.
+func (oh *OperationHandler) refund(r *http.Request, rsv service.Reservation) {
+	if oh.quotas == nil {
+		return
+	}
+	if err := oh.quotas.RefundUsage(context.Background(), rsv); err != nil {
+		logger.FromContext(r.Context(), logger.Nop()).Error("unable to refund usage", logger.F("subject", rsv.Subject),
+			logger.F("error", err))
+	}
+}
+
+// AggregateHandler - handler for the aggregation operations (count, min, max, mean, product, histogram)
+func (oh *OperationHandler) AggregateHandler(w http.ResponseWriter, r *http.Request) {
+	raw, jsonMap, err := oh.decodeDocument(r)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	rsv, err := oh.reserve(r, len(raw))
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	start := time.Now()
+	agg, err := oh.operations.Aggregate(r.Context(), mux.Vars(r)[operationVar], jsonMap, optionsFromQuery(r.URL.Query()))
+	if err != nil {
+		oh.refund(r, rsv)
+		problem.Write(w, r, err)
+		return
+	}
+	id, err := oh.record(r, agg.Operation, raw, agg.Hash, start)
+	if err != nil {
+		oh.refund(r, rsv)
+		problem.Write(w, r, err)
+		return
+	}
+
+	body, jsonErr := json.Marshal(&response.Aggregation{
+		ID:        id,
+		Operation: agg.Operation,
+		Value:     agg.Value,
+		Result:    agg.Hash,
+	})
+	if jsonErr != nil {
+		problem.Write(w, r, jsonErr)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(body)
+}
+
+// entityTag - weak ETag of a sum response, derived from the canonical digest of the document and the options. It is
+// weak since the representation tells whether the result was cached, so it is only semantically the same.
+func entityTag(digest string, opts service.Options) string {
+	return fmt.Sprintf(`W/"%x"`, sha256.Sum256([]byte(digest+"?"+opts.String())))
+}
+
+// matchesETag - checks an If-None-Match header value against the entity tag, weak comparison as per RFC 7232.
+func matchesETag(ifNoneMatch, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// toExplanation - response representation of a sum explanation.
+func toExplanation(exp *service.Explanation) *response.Explanation {
+	res := &response.Explanation{
+		Numbers: make([]response.Contribution, 0, len(exp.Numbers)),
+		Ignored: make([]response.Ignored, 0, len(exp.Ignored)),
+		Sum:     exp.Sum,
+	}
+	for _, c := range exp.Numbers {
+		res.Numbers = append(res.Numbers, response.Contribution(c))
+	}
+	for _, ig := range exp.Ignored {
+		res.Ignored = append(res.Ignored, response.Ignored(ig))
+	}
+	return res
+}
+
+// optionsFromQuery - builds the operation options from the query, `pointer`, `include` and `exclude` can be repeated.
+func optionsFromQuery(q url.Values) service.Options {
+	return service.Options{
+		Path:           q.Get("path"),
+		Pointers:       q["pointer"],
+		Include:        q["include"],
+		Exclude:        q["exclude"],
+		NumericStrings: q.Get("numeric_strings"),
+	}
+}
+
+// decodeDocument - reads the whole body and decodes it with the decoder of its content type (json by default), the
+// raw bytes are returned as well since digests are computed over them.
+func (oh *OperationHandler) decodeDocument(r *http.Request) ([]byte, interface{}, error) {
+	return decodeDocument(oh.decoders, r)
+}
+
+func decodeDocument(decoders *decoder.Registry, r *http.Request) ([]byte, interface{}, error) {
+	dec, err := decoders.Lookup(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", errUnsupportedMediaType, err.Error())
+	}
+	raw, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, nil, readError(err)
+	}
+	doc, err := dec.Decode(raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", errInvalidDocument, err.Error())
+	}
+	return raw, doc, nil
+}
+
+// readError - error reading a body, errDocumentTooLarge when it is over the limit set by middleware.MaxBytes.
+func readError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return fmt.Errorf("%w: over %d bytes", errDocumentTooLarge, tooLarge.Limit)
+	}
+	return fmt.Errorf("%w: %s", errInvalidDocument, err.Error())
+}
+
+// ReceiptHandler - holds the service that verifies signed receipts
+type ReceiptHandler struct {
+	receipts service.Receipter
+}
+
+// NewReceiptHandler - receipt handler constructor
+func NewReceiptHandler(rc service.Receipter) *ReceiptHandler {
+	return &ReceiptHandler{
+		receipts: rc,
+	}
+}
+
+// VerifyReceiptHandler - handler that checks a receipt and optionally that it covers the given document
+func (rh *ReceiptHandler) VerifyReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	req := &response.VerifyReceipt{}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(req); err != nil {
+		problem.Write(w, r, fmt.Errorf("%w: %s", errInvalidRequest, err.Error()))
+		return
+	}
+	if req.Receipt == "" {
+		problem.Write(w, r, fmt.Errorf("%w: missing receipt", errInvalidRequest))
+		return
+	}
+	rc, err := rh.receipts.VerifyReceipt(req.Receipt)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	if req.Document != nil && !rc.Covers(req.Document) {
+		problem.Write(w, r, errReceiptMismatch)
+		return
+	}
+
+	body, jsonErr := json.Marshal(&response.Receipt{
+		Result:   rc.Result,
+		Digest:   rc.Digest,
+		Options:  rc.Options,
+		Subject:  rc.Subject,
+		IssuedAt: rc.IssuedAt,
+	})
+	if jsonErr != nil {
+		problem.Write(w, r, jsonErr)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
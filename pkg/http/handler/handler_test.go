@@ -2,126 +2,1125 @@ package handler
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 
+	"github.com/qredo-external/go-rnov/pkg/auth"
+	"github.com/qredo-external/go-rnov/pkg/health"
 	response "github.com/qredo-external/go-rnov/pkg/http/json"
+	"github.com/qredo-external/go-rnov/pkg/service"
+	"github.com/qredo-external/go-rnov/pkg/storage"
 	"github.com/qredo-external/go-rnov/pkg/user"
 )
 
 type OperationServiceMock struct {
-	sum func(data interface{}) (string, error)
+	sum       func(ctx context.Context, data interface{}, opts service.Options) (*service.SumResult, error)
+	aggregate func(ctx context.Context, op string, data interface{}, opts service.Options) (*service.Aggregation, error)
+	explain   func(ctx context.Context, data interface{}, opts service.Options) (*service.Explanation, error)
 }
 
-func (osm OperationServiceMock) Sum(data interface{}) (string, error) {
+func (osm OperationServiceMock) Explain(ctx context.Context, data interface{}, opts service.Options) (*service.Explanation, error) {
+	if osm.explain != nil {
+		return osm.explain(ctx, data, opts)
+	}
+	panic("Not implemented")
+}
+
+func (osm OperationServiceMock) Sum(ctx context.Context, data interface{}, opts service.Options) (*service.SumResult, error) {
 	if osm.sum != nil {
-		return osm.sum(data)
+		return osm.sum(ctx, data, opts)
+	}
+	panic("Not implemented")
+}
+
+func (osm OperationServiceMock) Aggregate(ctx context.Context, op string, data interface{}, opts service.Options) (*service.Aggregation, error) {
+	if osm.aggregate != nil {
+		return osm.aggregate(ctx, op, data, opts)
+	}
+	panic("Not implemented")
+}
+
+// checkProblem - checks the response is an application/problem+json body matching its status.
+func checkProblem(t *testing.T, rr *httptest.ResponseRecorder) response.Problem {
+	t.Helper()
+	p := response.Problem{}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("error expectedRes problem content type got %s", ct)
+	}
+	dec := json.NewDecoder(rr.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		t.Error("unable to decode problem response")
+	}
+	if p.Status != rr.Code || p.Type == "" || p.Title == "" {
+		t.Errorf("error expectedRes problem with status %d got %+v", rr.Code, p)
+	}
+	return p
+}
+
+func TestNewOperationHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		url            string
+		requestPayload []byte
+		service        OperationServiceMock
+		status         int
+		expectedRes    response.Operation
+	}{
+		{
+			name:           "Successful request",
+			url:            "/sum",
+			requestPayload: json.RawMessage(`[1,2,3,4]`),
+			service: OperationServiceMock{
+				sum: func(ctx context.Context, data interface{}, opts service.Options) (*service.SumResult, error) {
+					return &service.SumResult{Hash: "qwertyHasBeenHashed"}, nil
+				},
+			},
+			status: 200,
+			expectedRes: response.Operation{
+				Result: "qwertyHasBeenHashed",
+			},
+		},
+		{
+			name:           "Successful request",
+			url:            "/sum",
+			requestPayload: json.RawMessage(`[1,2,3,4]`),
+			service: OperationServiceMock{
+				sum: func(ctx context.Context, data interface{}, opts service.Options) (*service.SumResult, error) {
+					return nil, errors.New("error in sum")
+				},
+			},
+			status: 500,
+		},
+		{
+			name:           "Successful request with selection",
+			url:            "/sum?path=$.a&exclude=b&exclude=c",
+			requestPayload: json.RawMessage(`{"a":[1,2,3,4]}`),
+			service: OperationServiceMock{
+				sum: func(ctx context.Context, data interface{}, opts service.Options) (*service.SumResult, error) {
+					if opts.Path != "$.a" || len(opts.Exclude) != 2 {
+						return nil, errors.New("unexpected options")
+					}
+					return &service.SumResult{Hash: "qwertyHasBeenHashed"}, nil
+				},
+			},
+			status: 200,
+			expectedRes: response.Operation{
+				Result: "qwertyHasBeenHashed",
+			},
+		},
+		{
+			name:           "Successful request with explanation",
+			url:            "/sum?explain=true",
+			requestPayload: json.RawMessage(`[4,"dark"]`),
+			service: OperationServiceMock{
+				explain: func(ctx context.Context, data interface{}, opts service.Options) (*service.Explanation, error) {
+					return &service.Explanation{
+						Numbers: []service.Contribution{{Pointer: "/0", Value: 4, Added: 4, Total: 4}},
+						Ignored: []service.Ignored{{Pointer: "/1", Value: "dark", Reason: "string"}},
+						Sum:     4,
+						Hash:    "fourHasBeenHashed",
+					}, nil
+				},
+			},
+			status: 200,
+			expectedRes: response.Operation{
+				Result: "fourHasBeenHashed",
+				Explanation: &response.Explanation{
+					Numbers: []response.Contribution{{Pointer: "/0", Value: 4, Added: 4, Total: 4}},
+					Ignored: []response.Ignored{{Pointer: "/1", Value: "dark", Reason: "string"}},
+					Sum:     4,
+				},
+			},
+		},
+		{
+			name:           "error - invalid selection",
+			url:            "/sum?path=a",
+			requestPayload: json.RawMessage(`[1,2,3,4]`),
+			service: OperationServiceMock{
+				sum: func(ctx context.Context, data interface{}, opts service.Options) (*service.SumResult, error) {
+					return nil, service.ErrInvalidSelection
+				},
+			},
+			status: 400,
+		},
+		{
+			name:           "error - deadline exceeded",
+			url:            "/sum",
+			requestPayload: json.RawMessage(`[1,2,3,4]`),
+			service: OperationServiceMock{
+				sum: func(ctx context.Context, data interface{}, opts service.Options) (*service.SumResult, error) {
+					return nil, context.DeadlineExceeded
+				},
+			},
+			status: 504,
+		},
+		{
+			name:           "error - canceled",
+			url:            "/sum",
+			requestPayload: json.RawMessage(`[1,2,3,4]`),
+			service: OperationServiceMock{
+				sum: func(ctx context.Context, data interface{}, opts service.Options) (*service.SumResult, error) {
+					return nil, context.Canceled
+				},
+			},
+			status: 503,
+		},
+		//{
+		//	name:           "error special case incoming body is not a json - error unmarshal",
+		//	requestPayload: json.RawMessage(`[1,2,3,4`),
+		//	url:            "/sum",
+		//	status:         400,
+		//},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var jsonBody []byte
+			jsonBody, _ = json.Marshal(&test.requestPayload)
+			req, err := http.NewRequest("POST", test.url, bytes.NewBuffer(jsonBody))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rh := NewOperationHandler(&test.service, nil, nil, nil, nil, nil, 0)
+
+			// We sum a ResponseRecorder (which satisfies http.ResponseWriter) to record the response.
+			rr := httptest.NewRecorder()
+			servicesRouter := mux.NewRouter()
+			servicesRouter.HandleFunc("/sum", rh.SumHandler).Methods("POST")
+
+			// Our handlers satisfy http.Handler, so we can call their ServeHTTP method
+			// directly and pass in our Request and ResponseRecorder.
+			servicesRouter.ServeHTTP(rr, req)
+
+			// Check the status code is what we expect.
+			if rr.Code != test.status {
+				t.Errorf("handler returned wrong status code: expectedRes %v got %v", test.status, rr.Code)
+			}
+			if rr.Code >= 400 {
+				checkProblem(t, rr)
+				return
+			}
+			if rr.Body.Len() > 0 {
+				res := response.Operation{}
+				dec := json.NewDecoder(rr.Body)
+				dec.DisallowUnknownFields()
+				if err := dec.Decode(&res); err != nil {
+					t.Error("unable to decode body response")
+				}
+				if res.Result != test.expectedRes.Result {
+					t.Errorf("error expectedRes response %s got %s", test.expectedRes.Result, res.Result)
+				}
+				if !reflect.DeepEqual(res.Explanation, test.expectedRes.Explanation) {
+					t.Errorf("error expectedRes explanation %+v got %+v", test.expectedRes.Explanation, res.Explanation)
+				}
+			}
+		})
+	}
+}
+
+func TestOperationHandler_AggregateHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		url            string
+		requestPayload string
+		service        OperationServiceMock
+		status         int
+		expectedRes    string
+	}{
+		{
+			name:           "Successful request",
+			url:            "/aggregate/max",
+			requestPayload: `[1,2,3,4]`,
+			service: OperationServiceMock{
+				aggregate: func(ctx context.Context, op string, data interface{}, opts service.Options) (*service.Aggregation, error) {
+					return &service.Aggregation{Operation: op, Value: float64(4), Hash: "fourHasBeenHashed"}, nil
+				},
+			},
+			status:      200,
+			expectedRes: "fourHasBeenHashed",
+		},
+		{
+			name:           "error - unknown operation",
+			url:            "/aggregate/median",
+			requestPayload: `[1,2,3,4]`,
+			service: OperationServiceMock{
+				aggregate: func(ctx context.Context, op string, data interface{}, opts service.Options) (*service.Aggregation, error) {
+					return nil, service.ErrUnknownOperation
+				},
+			},
+			status: 404,
+		},
+		{
+			name:           "error - no numbers",
+			url:            "/aggregate/min",
+			requestPayload: `["dark"]`,
+			service: OperationServiceMock{
+				aggregate: func(ctx context.Context, op string, data interface{}, opts service.Options) (*service.Aggregation, error) {
+					return nil, service.ErrNoNumbers
+				},
+			},
+			status: 422,
+		},
+		{
+			name:           "error - not a json document",
+			url:            "/aggregate/min",
+			requestPayload: `[1,2`,
+			status:         400,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", test.url, bytes.NewBufferString(test.requestPayload))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rh := NewOperationHandler(&test.service, nil, nil, nil, nil, nil, 0)
+
+			rr := httptest.NewRecorder()
+			servicesRouter := mux.NewRouter()
+			servicesRouter.HandleFunc("/aggregate/{operation}", rh.AggregateHandler).Methods("POST")
+			servicesRouter.ServeHTTP(rr, req)
+
+			if rr.Code != test.status {
+				t.Errorf("handler returned wrong status code: expectedRes %v got %v", test.status, rr.Code)
+			}
+			if rr.Body.Len() > 0 {
+				res := response.Aggregation{}
+				if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+					t.Error("unable to decode body response")
+				}
+				if res.Result != test.expectedRes {
+					t.Errorf("error expectedRes response %s got %s", test.expectedRes, res.Result)
+				}
+			}
+		})
+	}
+}
+
+func TestOperationHandler_SumHandlerFormats(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		maxBody     int64
+		status      int
+	}{
+		{name: "json without content type", body: `{"a":[1,2]}`, status: 200},
+		{name: "yaml", contentType: "application/yaml", body: "a:\n  - 1\n  - 2\n", status: 200},
+		{name: "toml", contentType: "application/toml", body: "a = [1, 2]\n", status: 200},
+		{name: "error - unsupported media type", contentType: "text/plain", body: `{"a":[1,2]}`, status: 415},
+		{name: "error - broken yaml", contentType: "application/yaml", body: "a: [1, 2", status: 400},
+		{name: "error - document too large", body: `{"a":[1,2]}`, maxBody: 5, status: 413},
+	}
+
+	expected := map[string]interface{}{"a": []interface{}{float64(1), float64(2)}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/sum", bytes.NewBufferString(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.contentType != "" {
+				req.Header.Set("Content-Type", test.contentType)
+			}
+
+			rh := NewOperationHandler(OperationServiceMock{
+				sum: func(ctx context.Context, data interface{}, opts service.Options) (*service.SumResult, error) {
+					if !reflect.DeepEqual(data, expected) {
+						return nil, errors.New("unexpected document")
+					}
+					return &service.SumResult{Hash: "threeHasBeenHashed"}, nil
+				},
+			}, nil, nil, nil, nil, nil, 0)
+
+			rr := httptest.NewRecorder()
+			if test.maxBody > 0 {
+				req.Body = http.MaxBytesReader(rr, req.Body, test.maxBody)
+			}
+			servicesRouter := mux.NewRouter()
+			servicesRouter.HandleFunc("/sum", rh.SumHandler).Methods("POST")
+			servicesRouter.ServeHTTP(rr, req)
+
+			if rr.Code != test.status {
+				t.Errorf("handler returned wrong status code: expectedRes %v got %v", test.status, rr.Code)
+			}
+		})
+	}
+}
+
+func TestOperationHandler_BatchSumHandler(t *testing.T) {
+	tests := []struct {
+		name         string
+		contentType  string
+		body         string
+		maxRecord    int
+		maxBody      int64
+		deadline     bool
+		status       int
+		expectedBody string
+	}{
+		{
+			name:         "ndjson with blank and broken lines",
+			contentType:  "application/x-ndjson",
+			body:         "[1,2]\n\n{\"a\":\n[\"fail\"]\n",
+			status:       200,
+			expectedBody: "{\"line\":1,\"result\":\"[1,2]\"}\n{\"line\":3,\"error\":\"invalid document: unexpected EOF\"}\n{\"line\":4,\"error\":\"sum failed\"}\n",
+		},
+		{
+			name:         "ndjson last line without new line",
+			contentType:  "application/x-ndjson",
+			body:         "[1]\n[2]",
+			status:       200,
+			expectedBody: "{\"line\":1,\"result\":\"[1]\"}\n{\"line\":2,\"result\":\"[2]\"}\n",
+		},
+		{
+			name:         "json-seq",
+			contentType:  "application/json-seq",
+			body:         "\x1e[1]\n\x1e{\"a\":\n2}\n",
+			status:       200,
+			expectedBody: "\x1e{\"line\":1,\"result\":\"[1]\"}\n\x1e{\"line\":2,\"result\":\"{\\\"a\\\":2}\"}\n",
+		},
+		{
+			name:         "document too large",
+			contentType:  "application/x-ndjson",
+			body:         "[1,2]\n[1,2,3,4,5,6]\n[3]\n",
+			maxRecord:    5,
+			status:       200,
+			expectedBody: "{\"line\":1,\"result\":\"[1,2]\"}\n{\"line\":2,\"error\":\"document too large: over 5 bytes\"}\n{\"line\":3,\"result\":\"[3]\"}\n",
+		},
+		{
+			name:         "json-seq document too large",
+			contentType:  "application/json-seq",
+			body:         "\x1e[1,2,3,4,5,6]\n\x1e[3]\n",
+			maxRecord:    5,
+			status:       200,
+			expectedBody: "\x1e{\"line\":1,\"error\":\"document too large: over 5 bytes\"}\n\x1e{\"line\":2,\"result\":\"[3]\"}\n",
+		},
+		{
+			name:         "batch too large",
+			contentType:  "application/x-ndjson",
+			body:         "[1]\n[2]\n[3]\n",
+			maxBody:      6,
+			status:       200,
+			expectedBody: "{\"line\":1,\"result\":\"[1]\"}\n{\"line\":2,\"error\":\"batch too large: over 6 bytes\"}\n",
+		},
+		{
+			name:         "deadline reached",
+			contentType:  "application/x-ndjson",
+			body:         "[1]\n[2]\n",
+			deadline:     true,
+			status:       200,
+			expectedBody: "{\"line\":1,\"error\":\"context deadline exceeded\"}\n{\"line\":2,\"error\":\"operation deadline exceeded: the documents from this line on were not computed\"}\n",
+		},
+		{
+			name:        "error - unsupported media type",
+			contentType: "application/json",
+			body:        "[1]",
+			status:      415,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/sum/batch", bytes.NewBufferString(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", test.contentType)
+			if test.deadline {
+				ctx, cancel := context.WithTimeout(req.Context(), 0)
+				defer cancel()
+				req = req.WithContext(ctx)
+			}
+
+			rh := NewOperationHandler(OperationServiceMock{
+				sum: func(ctx context.Context, data interface{}, opts service.Options) (*service.SumResult, error) {
+					if err := ctx.Err(); err != nil {
+						return nil, err
+					}
+					if reflect.DeepEqual(data, []interface{}{"fail"}) {
+						return nil, errors.New("sum failed")
+					}
+					doc, _ := json.Marshal(data)
+					return &service.SumResult{Hash: string(doc)}, nil
+				},
+			}, nil, nil, nil, nil, nil, test.maxRecord)
+
+			rr := httptest.NewRecorder()
+			if test.maxBody > 0 {
+				req.Body = http.MaxBytesReader(rr, req.Body, test.maxBody)
+			}
+			servicesRouter := mux.NewRouter()
+			servicesRouter.HandleFunc("/sum/batch", rh.BatchSumHandler).Methods("POST")
+			servicesRouter.ServeHTTP(rr, req)
+
+			if rr.Code != test.status {
+				t.Errorf("handler returned wrong status code: expectedRes %v got %v", test.status, rr.Code)
+			}
+			if rr.Code >= 400 {
+				checkProblem(t, rr)
+				return
+			}
+			if rr.Body.String() != test.expectedBody {
+				t.Errorf("error expectedRes body %q got %q", test.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestOperationHandler_SumHandlerConditional(t *testing.T) {
+	rh := NewOperationHandler(OperationServiceMock{
+		sum: func(ctx context.Context, data interface{}, opts service.Options) (*service.SumResult, error) {
+			return &service.SumResult{Hash: "qwertyHasBeenHashed", Digest: "aDigest", Cached: true}, nil
+		},
+	}, nil, nil, nil, nil, nil, 0)
+	servicesRouter := mux.NewRouter()
+	servicesRouter.HandleFunc("/sum", rh.SumHandler).Methods("POST")
+
+	req, _ := http.NewRequest("POST", "/sum", bytes.NewBufferString(`[1,2,3,4]`))
+	rr := httptest.NewRecorder()
+	servicesRouter.ServeHTTP(rr, req)
+	etag := rr.Header().Get("ETag")
+	if rr.Code != 200 || !strings.HasPrefix(etag, `W/"`) || rr.Header().Get(cacheHeader) != "HIT" {
+		t.Fatalf("expected 200 with weak ETag and cache hit got %d %q %q", rr.Code, etag, rr.Header().Get(cacheHeader))
+	}
+
+	tests := []struct {
+		name        string
+		url         string
+		ifNoneMatch string
+		status      int
+	}{
+		{name: "matching ETag", url: "/sum", ifNoneMatch: `"other", ` + etag, status: 304},
+		{name: "strong form of the ETag", url: "/sum", ifNoneMatch: strings.TrimPrefix(etag, "W/"), status: 304},
+		{name: "other ETag", url: "/sum", ifNoneMatch: `"other"`, status: 200},
+		{name: "other options", url: "/sum?exclude=a", ifNoneMatch: etag, status: 200},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", test.url, bytes.NewBufferString(`[1,2,3,4]`))
+			req.Header.Set("If-None-Match", test.ifNoneMatch)
+			rr := httptest.NewRecorder()
+			servicesRouter.ServeHTTP(rr, req)
+			if rr.Code != test.status {
+				t.Errorf("handler returned wrong status code: expectedRes %v got %v", test.status, rr.Code)
+			}
+		})
+	}
+}
+
+type JobsServiceMock struct {
+	submitSum func(ctx context.Context, owner string, raw []byte, data interface{}, opts service.Options) (storage.Job, error)
+	getJob    func(owner, id string) (storage.Job, error)
+	cancelJob func(owner, id string) (storage.Job, error)
+}
+
+func (jsm JobsServiceMock) SubmitSum(ctx context.Context, owner string, raw []byte, data interface{}, opts service.Options) (storage.Job, error) {
+	if jsm.submitSum != nil {
+		return jsm.submitSum(ctx, owner, raw, data, opts)
+	}
+	panic("Not implemented")
+}
+
+func (jsm JobsServiceMock) GetJob(owner, id string) (storage.Job, error) {
+	if jsm.getJob != nil {
+		return jsm.getJob(owner, id)
+	}
+	panic("Not implemented")
+}
+
+func (jsm JobsServiceMock) CancelJob(owner, id string) (storage.Job, error) {
+	if jsm.cancelJob != nil {
+		return jsm.cancelJob(owner, id)
+	}
+	panic("Not implemented")
+}
+
+func TestJobHandler(t *testing.T) {
+	owned := func(owner, id string) (storage.Job, error) {
+		if owner != "qwerty" || id != "aJob" {
+			return storage.Job{}, service.ErrJobNotFound
+		}
+		return storage.Job{ID: id, Owner: owner, Status: storage.JobSucceeded, Result: "aHash"}, nil
+	}
+	tests := []struct {
+		name        string
+		method      string
+		url         string
+		service     JobsServiceMock
+		status      int
+		expectedRes string
+	}{
+		{
+			name:   "Successful submit",
+			method: "POST",
+			url:    "/sum?async=true",
+			service: JobsServiceMock{
+				submitSum: func(ctx context.Context, owner string, raw []byte, data interface{}, opts service.Options) (storage.Job, error) {
+					return storage.Job{ID: "aJob", Owner: owner, Status: storage.JobQueued}, nil
+				},
+			},
+			status:      202,
+			expectedRes: storage.JobQueued,
+		},
+		{
+			name:   "error - queue full",
+			method: "POST",
+			url:    "/sum?async=true",
+			service: JobsServiceMock{
+				submitSum: func(ctx context.Context, owner string, raw []byte, data interface{}, opts service.Options) (storage.Job, error) {
+					return storage.Job{}, service.ErrQueueFull
+				},
+			},
+			status: 503,
+		},
+		{
+			name:   "error - quota exceeded",
+			method: "POST",
+			url:    "/sum?async=true",
+			service: JobsServiceMock{
+				submitSum: func(ctx context.Context, owner string, raw []byte, data interface{}, opts service.Options) (storage.Job, error) {
+					return storage.Job{}, &service.QuotaError{Resource: service.QuotaOperations, Limit: 1, Used: 1, Period: service.QuotaDaily}
+				},
+			},
+			status: 429,
+		},
+		{
+			name:        "Successful get",
+			method:      "GET",
+			url:         "/jobs/aJob",
+			service:     JobsServiceMock{getJob: owned},
+			status:      200,
+			expectedRes: storage.JobSucceeded,
+		},
+		{
+			name:    "error - job of another subject",
+			method:  "GET",
+			url:     "/jobs/anotherJob",
+			service: JobsServiceMock{getJob: owned},
+			status:  404,
+		},
+		{
+			name:   "error - cancel finished job",
+			method: "DELETE",
+			url:    "/jobs/aJob",
+			service: JobsServiceMock{
+				cancelJob: func(owner, id string) (storage.Job, error) {
+					return storage.Job{}, service.ErrJobFinished
+				},
+			},
+			status: 409,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(test.method, test.url, bytes.NewBufferString(`[1,2,3,4]`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req = req.WithContext(auth.NewContext(req.Context(), &auth.Claims{Subject: "qwerty"}))
+
+			jh := NewJobHandler(&test.service, nil)
+
+			rr := httptest.NewRecorder()
+			servicesRouter := mux.NewRouter()
+			servicesRouter.HandleFunc("/sum", jh.SubmitSumHandler).Methods("POST").Queries("async", "true")
+			servicesRouter.HandleFunc("/jobs/{id}", jh.GetJobHandler).Methods("GET")
+			servicesRouter.HandleFunc("/jobs/{id}", jh.CancelJobHandler).Methods("DELETE")
+			servicesRouter.ServeHTTP(rr, req)
+
+			if rr.Code != test.status {
+				t.Errorf("handler returned wrong status code: expectedRes %v got %v", test.status, rr.Code)
+			}
+			if rr.Code == 202 && rr.Header().Get("Location") != "/jobs/aJob" {
+				t.Errorf("error expectedRes location /jobs/aJob got %s", rr.Header().Get("Location"))
+			}
+			if rr.Code >= 400 {
+				checkProblem(t, rr)
+				return
+			}
+			if rr.Body.Len() > 0 {
+				res := response.Job{}
+				if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+					t.Error("unable to decode body response")
+				}
+				if res.Status != test.expectedRes {
+					t.Errorf("error expectedRes status %s got %s", test.expectedRes, res.Status)
+				}
+			}
+		})
+	}
+}
+
+type HistorianServiceMock struct {
+	recordComputation func(c storage.Computation) (storage.Computation, error)
+	listComputations  func(owner string, offset, limit int) (*service.HistoryPage, error)
+	getComputation    func(owner, id string) (storage.Computation, error)
+}
+
+func (hsm HistorianServiceMock) RecordComputation(c storage.Computation) (storage.Computation, error) {
+	if hsm.recordComputation != nil {
+		return hsm.recordComputation(c)
+	}
+	panic("Not implemented")
+}
+
+func (hsm HistorianServiceMock) ListComputations(owner string, offset, limit int) (*service.HistoryPage, error) {
+	if hsm.listComputations != nil {
+		return hsm.listComputations(owner, offset, limit)
+	}
+	panic("Not implemented")
+}
+
+func (hsm HistorianServiceMock) GetComputation(owner, id string) (storage.Computation, error) {
+	if hsm.getComputation != nil {
+		return hsm.getComputation(owner, id)
+	}
+	panic("Not implemented")
+}
+
+type MetricsServiceMock struct {
+	sumComputed func(size int, d time.Duration, cached bool)
+}
+
+func (msm MetricsServiceMock) TokenIssued() {
+	panic("Not implemented")
+}
+
+func (msm MetricsServiceMock) TokenValidated() {
+	panic("Not implemented")
+}
+
+func (msm MetricsServiceMock) TokenRejected(reason string) {
+	panic("Not implemented")
+}
+
+func (msm MetricsServiceMock) SumComputed(size int, d time.Duration, cached bool) {
+	if msm.sumComputed != nil {
+		msm.sumComputed(size, d, cached)
+		return
+	}
+	panic("Not implemented")
+}
+
+func TestOperationHandler_SumHandlerHistory(t *testing.T) {
+	var recorded storage.Computation
+	measured := -1
+	rh := NewOperationHandler(OperationServiceMock{
+		sum: func(ctx context.Context, data interface{}, opts service.Options) (*service.SumResult, error) {
+			return &service.SumResult{Hash: "qwertyHasBeenHashed"}, nil
+		},
+	}, nil, HistorianServiceMock{
+		recordComputation: func(c storage.Computation) (storage.Computation, error) {
+			recorded, c.ID = c, "aComputation"
+			return c, nil
+		},
+	}, nil, MetricsServiceMock{
+		sumComputed: func(size int, d time.Duration, cached bool) {
+			measured = size
+		},
+	}, nil, 0)
+	servicesRouter := mux.NewRouter()
+	servicesRouter.HandleFunc("/sum", rh.SumHandler).Methods("POST")
+
+	req, _ := http.NewRequest("POST", "/sum", bytes.NewBufferString(`[1,2,3,4]`))
+	req = req.WithContext(auth.NewContext(req.Context(), &auth.Claims{Subject: "qwerty"}))
+	rr := httptest.NewRecorder()
+	servicesRouter.ServeHTTP(rr, req)
+
+	res := response.Operation{}
+	if err := json.NewDecoder(rr.Body).Decode(&res); err != nil || rr.Code != 200 {
+		t.Fatalf("expected 200 with a body got %d %v", rr.Code, err)
+	}
+	if res.ID != "aComputation" {
+		t.Errorf("error expectedRes id aComputation got %s", res.ID)
+	}
+	if recorded.Subject != "qwerty" || recorded.Size != 9 || recorded.Result != "qwertyHasBeenHashed" ||
+		recorded.Digest != auth.DocumentDigest([]byte(`[1,2,3,4]`)) || recorded.CreatedAt.IsZero() {
+		t.Errorf("unexpected recorded computation %+v", recorded)
+	}
+	if measured != 9 {
+		t.Errorf("error expectedRes measured size 9 got %d", measured)
+	}
+}
+
+func TestOperationHandler_AggregateAndBatchHistory(t *testing.T) {
+	var recorded []storage.Computation
+	rh := NewOperationHandler(OperationServiceMock{
+		sum: func(ctx context.Context, data interface{}, opts service.Options) (*service.SumResult, error) {
+			return &service.SumResult{Hash: "qwertyHasBeenHashed"}, nil
+		},
+		aggregate: func(ctx context.Context, op string, data interface{}, opts service.Options) (*service.Aggregation, error) {
+			return &service.Aggregation{Operation: op, Value: 3, Hash: "aHash"}, nil
+		},
+	}, nil, HistorianServiceMock{
+		recordComputation: func(c storage.Computation) (storage.Computation, error) {
+			c.ID = fmt.Sprintf("computation%d", len(recorded))
+			recorded = append(recorded, c)
+			return c, nil
+		},
+	}, nil, nil, nil, 0)
+	servicesRouter := mux.NewRouter()
+	servicesRouter.HandleFunc("/sum/batch", rh.BatchSumHandler).Methods("POST")
+	servicesRouter.HandleFunc("/aggregate/{operation}", rh.AggregateHandler).Methods("POST")
+	do := func(url, contentType, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", contentType)
+		req = req.WithContext(auth.NewContext(req.Context(), &auth.Claims{Subject: "qwerty"}))
+		rr := httptest.NewRecorder()
+		servicesRouter.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do("/aggregate/count", "application/json", `[1,2,3]`)
+	if expected := `{"id":"computation0","operation":"count","value":3,"result":"aHash"}`; rr.Body.String() != expected {
+		t.Errorf("error expectedRes body %s got %s", expected, rr.Body.String())
+	}
+	rr = do("/sum/batch", "application/x-ndjson", "[1,2]\n{\n")
+	expected := `{"line":1,"id":"computation1","result":"qwertyHasBeenHashed"}` + "\n" +
+		`{"line":2,"error":"invalid document: unexpected EOF"}` + "\n"
+	if rr.Body.String() != expected {
+		t.Errorf("error expectedRes body %q got %q", expected, rr.Body.String())
+	}
+	if len(recorded) != 2 || recorded[0].Operation != "count" || recorded[0].Size != 7 ||
+		recorded[1].Operation != service.OperationSum || recorded[1].Size != 5 || recorded[1].Subject != "qwerty" ||
+		recorded[1].Digest != auth.DocumentDigest([]byte(`[1,2]`)) {
+		t.Errorf("unexpected recorded computations %+v", recorded)
+	}
+}
+
+type QuotasServiceMock struct {
+	reserveUsage func(ctx context.Context, subject string, size int) (service.Reservation, error)
+	refundUsage  func(ctx context.Context, r service.Reservation) error
+	getUsage     func(ctx context.Context, subject string) (*service.Usage, error)
+}
+
+func (qsm QuotasServiceMock) ReserveUsage(ctx context.Context, subject string, size int) (service.Reservation, error) {
+	if qsm.reserveUsage != nil {
+		return qsm.reserveUsage(ctx, subject, size)
 	}
 	panic("Not implemented")
 }
 
-func TestNewOperationHandler(t *testing.T) {
+func (qsm QuotasServiceMock) RefundUsage(ctx context.Context, r service.Reservation) error {
+	if qsm.refundUsage != nil {
+		return qsm.refundUsage(ctx, r)
+	}
+	panic("Not implemented")
+}
+
+func (qsm QuotasServiceMock) GetUsage(ctx context.Context, subject string) (*service.Usage, error) {
+	if qsm.getUsage != nil {
+		return qsm.getUsage(ctx, subject)
+	}
+	panic("Not implemented")
+}
+
+func TestOperationHandler_Quota(t *testing.T) {
+	reset := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+	used := map[string]int{}
+	quotas := QuotasServiceMock{
+		reserveUsage: func(ctx context.Context, subject string, size int) (service.Reservation, error) {
+			if size > 9 {
+				return service.Reservation{}, &service.QuotaError{Resource: service.QuotaBytes, Limit: 20, Used: 11, Period: service.QuotaMonthly, Reset: reset}
+			}
+			used[subject] += size
+			return service.Reservation{Subject: subject, Bytes: size}, nil
+		},
+		refundUsage: func(ctx context.Context, r service.Reservation) error {
+			used[r.Subject] -= r.Bytes
+			return nil
+		},
+	}
+	rh := NewOperationHandler(OperationServiceMock{
+		sum: func(ctx context.Context, data interface{}, opts service.Options) (*service.SumResult, error) {
+			if data == nil {
+				return nil, service.ErrInvalidSelection
+			}
+			return &service.SumResult{Hash: "qwertyHasBeenHashed", Digest: "aDigest"}, nil
+		},
+		aggregate: func(ctx context.Context, op string, data interface{}, opts service.Options) (*service.Aggregation, error) {
+			return &service.Aggregation{Operation: op, Value: 4, Hash: "qwertyHasBeenHashed"}, nil
+		},
+	}, nil, nil, quotas, nil, nil, 0)
+	servicesRouter := mux.NewRouter()
+	servicesRouter.HandleFunc("/sum", rh.SumHandler).Methods("POST")
+	servicesRouter.HandleFunc("/sum/batch", rh.BatchSumHandler).Methods("POST")
+	servicesRouter.HandleFunc("/aggregate/{operation}", rh.AggregateHandler).Methods("POST")
+	do := func(url, contentType, body string, header ...string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", contentType)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		req = req.WithContext(auth.NewContext(req.Context(), &auth.Claims{Subject: "qwerty"}))
+		rr := httptest.NewRecorder()
+		servicesRouter.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do("/sum", "application/json", `[1,2,3,4]`)
+	if rr.Code != 200 || used["qwerty"] != 9 {
+		t.Errorf("expected 200 with 9 bytes used got %d %d", rr.Code, used["qwerty"])
+	}
+	if rr := do("/sum", "application/json", `[1,2,3,4]`, "If-None-Match", rr.Header().Get("ETag")); rr.Code != http.StatusNotModified || used["qwerty"] != 9 {
+		t.Errorf("expected 304 not to be counted got %d with %d bytes used", rr.Code, used["qwerty"])
+	}
+	if rr := do("/sum", "application/json", `null`); rr.Code != 400 || used["qwerty"] != 9 {
+		t.Errorf("expected failed sum to be refunded got %d with %d bytes used", rr.Code, used["qwerty"])
+	}
+	if rr := do("/aggregate/count", "application/json", `[1,2,3]`); rr.Code != 200 || used["qwerty"] != 16 {
+		t.Errorf("expected 200 with 16 bytes used got %d %d", rr.Code, used["qwerty"])
+	}
+	rr = do("/sum", "application/json", `[1,2,3,4,5]`)
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 429 with Retry-After got %d %v", rr.Code, rr.Header())
+	}
+	p := checkProblem(t, rr)
+	if p.Type != "/problems/quota-exceeded" || p.Quota == nil || p.Quota.Resource != "bytes" || p.Quota.Limit != 20 ||
+		p.Quota.Used != 11 || p.Quota.Period != "month" || !p.Quota.Reset.Equal(reset) {
+		t.Errorf("unexpected quota problem %+v %+v", p, p.Quota)
+	}
+	if used["qwerty"] != 16 {
+		t.Errorf("expected refused sums not to be counted got %d bytes used", used["qwerty"])
+	}
+
+	rr = do("/sum/batch", "application/x-ndjson", "[1,2]\n[1,2,3,4,5]\nnull\n")
+	expected := `{"line":1,"result":"qwertyHasBeenHashed"}` + "\n" +
+		`{"line":2,"error":"quota exceeded: 11 of 20 bytes per month used"}` + "\n" +
+		`{"line":3,"error":"invalid selection"}` + "\n"
+	if rr.Body.String() != expected || used["qwerty"] != 21 {
+		t.Errorf("expected the documents over quota or failed not to be counted got %q with %d bytes used", rr.Body.String(), used["qwerty"])
+	}
+}
+
+func TestUsageHandler(t *testing.T) {
+	start := time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)
 	tests := []struct {
-		name           string
-		url            string
-		requestPayload []byte
-		service        OperationServiceMock
-		status         int
-		expectedRes    response.Operation
+		name        string
+		service     QuotasServiceMock
+		status      int
+		expectedRes string
 	}{
 		{
-			name:           "Successful request",
-			url:            "/sum",
-			requestPayload: json.RawMessage(`[1,2,3,4]`),
-			service: OperationServiceMock{
-				sum: func(data interface{}) (string, error) {
-					return "qwertyHasBeenHashed", nil
+			name: "Successful usage",
+			service: QuotasServiceMock{
+				getUsage: func(ctx context.Context, subject string) (*service.Usage, error) {
+					if subject != "qwerty" {
+						return nil, errors.New("unexpected subject")
+					}
+					return &service.Usage{
+						Quota:      service.Quota{Operations: 100, Period: service.QuotaMonthly},
+						Start:      start,
+						Reset:      start.AddDate(0, 1, 0),
+						Operations: 100,
+						Bytes:      2048,
+					}, nil
 				},
 			},
 			status: 200,
-			expectedRes: response.Operation{
-				Result: "qwertyHasBeenHashed",
+			expectedRes: `{"period":"month","start":"2023-02-01T00:00:00Z","reset":"2023-03-01T00:00:00Z",` +
+				`"operations":{"used":100,"limit":100,"remaining":0},"bytes":{"used":2048}}`,
+		},
+		{
+			name: "error - storage unavailable",
+			service: QuotasServiceMock{
+				getUsage: func(ctx context.Context, subject string) (*service.Usage, error) {
+					return nil, fmt.Errorf("error reading usage: %w", storage.ErrUnavailable)
+				},
+			},
+			status:      503,
+			expectedRes: "/problems/storage-unavailable",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			uh := NewUsageHandler(test.service)
+			req, _ := http.NewRequest("GET", "/usage", nil)
+			req = req.WithContext(auth.NewContext(req.Context(), &auth.Claims{Subject: "qwerty"}))
+			rr := httptest.NewRecorder()
+			uh.GetUsageHandler(rr, req)
+
+			if rr.Code != test.status {
+				t.Errorf("error expectedRes status %d got %d", test.status, rr.Code)
+			}
+			if test.status != 200 {
+				if p := checkProblem(t, rr); p.Type != test.expectedRes {
+					t.Errorf("error expectedRes problem %s got %s", test.expectedRes, p.Type)
+				}
+				return
+			}
+			if rr.Body.String() != test.expectedRes {
+				t.Errorf("error expectedRes %s got %s", test.expectedRes, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestHistoryHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		service     HistorianServiceMock
+		status      int
+		expectedRes string
+	}{
+		{
+			name: "Successful list",
+			url:  "/sum?offset=1&limit=2",
+			service: HistorianServiceMock{
+				listComputations: func(owner string, offset, limit int) (*service.HistoryPage, error) {
+					if owner != "qwerty" || offset != 1 || limit != 2 {
+						return nil, errors.New("unexpected page")
+					}
+					return &service.HistoryPage{
+						Computations: []storage.Computation{{ID: "aComputation", Operation: "count"}},
+						Total:        2,
+						Offset:       offset,
+						Limit:        limit,
+					}, nil
+				},
 			},
+			status:      200,
+			expectedRes: `{"computations":[{"id":"aComputation","operation":"count","created_at":"0001-01-01T00:00:00Z","digest":"","size":0,"result":"","duration_ms":0}],"total":2,"offset":1,"limit":2}`,
 		},
 		{
-			name:           "Successful request",
-			url:            "/sum",
-			requestPayload: json.RawMessage(`[1,2,3,4]`),
-			service: OperationServiceMock{
-				sum: func(data interface{}) (string, error) {
-					return "", errors.New("error in sum")
+			name:        "error - invalid offset",
+			url:         "/sum?offset=first",
+			status:      400,
+			expectedRes: "/problems/invalid-request",
+		},
+		{
+			name: "error - invalid page",
+			url:  "/sum?limit=1000",
+			service: HistorianServiceMock{
+				listComputations: func(owner string, offset, limit int) (*service.HistoryPage, error) {
+					return nil, service.ErrInvalidPage
 				},
 			},
-			status: 500,
+			status:      400,
+			expectedRes: "/problems/invalid-page",
+		},
+		{
+			name: "Successful get",
+			url:  "/sum/aComputation",
+			service: HistorianServiceMock{
+				getComputation: func(owner, id string) (storage.Computation, error) {
+					return storage.Computation{ID: id, Subject: owner, Operation: service.OperationSum, Result: "aHash", Duration: time.Millisecond * 3}, nil
+				},
+			},
+			status:      200,
+			expectedRes: `{"id":"aComputation","operation":"sum","created_at":"0001-01-01T00:00:00Z","digest":"","size":0,"result":"aHash","duration_ms":3}`,
+		},
+		{
+			name: "error - computation of another subject",
+			url:  "/sum/anotherComputation",
+			service: HistorianServiceMock{
+				getComputation: func(owner, id string) (storage.Computation, error) {
+					return storage.Computation{}, service.ErrComputationNotFound
+				},
+			},
+			status:      404,
+			expectedRes: "/problems/computation-not-found",
 		},
-		//{
-		//	name:           "error special case incoming body is not a json - error unmarshal",
-		//	requestPayload: json.RawMessage(`[1,2,3,4`),
-		//	url:            "/sum",
-		//	status:         400,
-		//},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			var jsonBody []byte
-			jsonBody, _ = json.Marshal(&test.requestPayload)
-			req, err := http.NewRequest("POST", test.url, bytes.NewBuffer(jsonBody))
+			req, err := http.NewRequest("GET", test.url, nil)
 			if err != nil {
 				t.Fatal(err)
 			}
+			req = req.WithContext(auth.NewContext(req.Context(), &auth.Claims{Subject: "qwerty"}))
 
-			rh := NewOperationHandler(&test.service)
+			hh := NewHistoryHandler(&test.service)
 
-			// We sum a ResponseRecorder (which satisfies http.ResponseWriter) to record the response.
 			rr := httptest.NewRecorder()
 			servicesRouter := mux.NewRouter()
-			servicesRouter.HandleFunc("/sum", rh.SumHandler).Methods("POST")
-
-			// Our handlers satisfy http.Handler, so we can call their ServeHTTP method
-			// directly and pass in our Request and ResponseRecorder.
+			servicesRouter.HandleFunc("/sum", hh.ListHistoryHandler).Methods("GET")
+			servicesRouter.HandleFunc("/sum/{id}", hh.GetComputationHandler).Methods("GET")
 			servicesRouter.ServeHTTP(rr, req)
 
-			// Check the status code is what we expect.
 			if rr.Code != test.status {
 				t.Errorf("handler returned wrong status code: expectedRes %v got %v", test.status, rr.Code)
 			}
-			if rr.Body.Len() > 0 {
-				res := response.Operation{}
-				dec := json.NewDecoder(rr.Body)
-				dec.DisallowUnknownFields()
-				if err := dec.Decode(&res); err != nil {
-					t.Error("unable to decode body response")
-				}
-				if res.Result != test.expectedRes.Result {
-					t.Errorf("error expectedRes response %s got %s", test.expectedRes.Result, res.Result)
+			if rr.Code >= 400 {
+				if p := checkProblem(t, rr); p.Type != test.expectedRes {
+					t.Errorf("error expectedRes problem type %s got %s", test.expectedRes, p.Type)
 				}
+				return
+			}
+			if rr.Body.String() != test.expectedRes {
+				t.Errorf("error expectedRes body %s got %s", test.expectedRes, rr.Body.String())
 			}
 		})
 	}
 }
 
 type AuthorizerServiceMock struct {
-	createAuth func(usr user.User) (string, error)
+	createAuth func(usr user.User, cnf auth.Confirmation) (string, error)
 }
 
-func (asm AuthorizerServiceMock) CreateAuth(usr user.User) (string, error) {
+func (asm AuthorizerServiceMock) CreateAuth(ctx context.Context, usr user.User, cnf auth.Confirmation) (string, error) {
 	if asm.createAuth != nil {
-		return asm.createAuth(usr)
+		return asm.createAuth(usr, cnf)
+	}
+	panic("Not implemented")
+}
+
+type ProofVerifierMock struct {
+	verifyProof func(proof string, req auth.ProofRequest) (*auth.Proof, error)
+	nonce       string
+}
+
+func (pvm ProofVerifierMock) VerifyProof(ctx context.Context, proof string, req auth.ProofRequest) (*auth.Proof, error) {
+	if pvm.verifyProof != nil {
+		return pvm.verifyProof(proof, req)
 	}
 	panic("Not implemented")
 }
 
+func (pvm ProofVerifierMock) Nonce() string {
+	return pvm.nonce
+}
+
 func TestNewAuthHandler(t *testing.T) {
+	clientCert := &x509.Certificate{Raw: []byte("client")}
+	dpop := ProofVerifierMock{
+		verifyProof: func(proof string, req auth.ProofRequest) (*auth.Proof, error) {
+			if proof != "aProof" || req.Method != "POST" || req.URL != "http://localhost/auth" || req.AccessToken != "" {
+				return nil, auth.ErrDPoPNonce
+			}
+			return &auth.Proof{JKT: "clientKey"}, nil
+		},
+		nonce: "aNonce",
+	}
 	tests := []struct {
 		name           string
 		url            string
 		requestPayload user.User
+		cert           *x509.Certificate
+		proof          string
+		dpop           auth.ProofVerifier
+		expectedType   string
 		service        AuthorizerServiceMock
 		status         int
 		expectedRes    string
@@ -134,13 +1133,65 @@ func TestNewAuthHandler(t *testing.T) {
 				Password: "z1x2c3",
 			},
 			service: AuthorizerServiceMock{
-				createAuth: func(usr user.User) (string, error) {
+				createAuth: func(usr user.User, cnf auth.Confirmation) (string, error) {
 					return "validAuthToken", nil
 				},
 			},
 			status:      201,
 			expectedRes: "validAuthToken",
 		},
+		{
+			name: "Successful request bound to the client certificate",
+			url:  "/auth",
+			requestPayload: user.User{
+				UserName: "qwerty",
+				Password: "z1x2c3",
+			},
+			cert: clientCert,
+			service: AuthorizerServiceMock{
+				createAuth: func(usr user.User, cnf auth.Confirmation) (string, error) {
+					if cnf.X5tS256 != auth.CertThumbprint(clientCert) {
+						return "", errors.New("token not bound")
+					}
+					return "boundAuthToken", nil
+				},
+			},
+			status:      201,
+			expectedRes: "boundAuthToken",
+		},
+		{
+			name: "Successful request bound to the dpop key",
+			url:  "http://localhost/auth",
+			requestPayload: user.User{
+				UserName: "qwerty",
+				Password: "z1x2c3",
+			},
+			proof: "aProof",
+			dpop:  dpop,
+			service: AuthorizerServiceMock{
+				createAuth: func(usr user.User, cnf auth.Confirmation) (string, error) {
+					if cnf.JKT != "clientKey" {
+						return "", errors.New("token not bound")
+					}
+					return "dpopAuthToken", nil
+				},
+			},
+			status:       201,
+			expectedRes:  "dpopAuthToken",
+			expectedType: "DPoP",
+		},
+		{
+			name: "error - dpop proof without the current nonce",
+			url:  "http://localhost/auth",
+			requestPayload: user.User{
+				UserName: "qwerty",
+				Password: "z1x2c3",
+			},
+			proof:       "staleProof",
+			dpop:        dpop,
+			status:      400,
+			expectedRes: "use_dpop_nonce",
+		},
 		{
 			name: "error - empty password",
 			url:  "/auth",
@@ -149,12 +1200,40 @@ func TestNewAuthHandler(t *testing.T) {
 				Password: "",
 			},
 			service: AuthorizerServiceMock{
-				createAuth: func(usr user.User) (string, error) {
-					return "", errors.New("error: invalid user")
+				createAuth: func(usr user.User, cnf auth.Confirmation) (string, error) {
+					return "", fmt.Errorf("%w: empty fields", service.ErrInvalidUser)
 				},
 			},
 			status: 400,
 		},
+		{
+			name: "error - internal",
+			url:  "/auth",
+			requestPayload: user.User{
+				UserName: "qwerty",
+				Password: "mnbvc",
+			},
+			service: AuthorizerServiceMock{
+				createAuth: func(usr user.User, cnf auth.Confirmation) (string, error) {
+					return "", errors.New("signing failed")
+				},
+			},
+			status: 500,
+		},
+		{
+			name: "error - storage unavailable",
+			url:  "/auth",
+			requestPayload: user.User{
+				UserName: "qwerty",
+				Password: "mnbvc",
+			},
+			service: AuthorizerServiceMock{
+				createAuth: func(usr user.User, cnf auth.Confirmation) (string, error) {
+					return "", fmt.Errorf("error storing JWT: %w", storage.ErrUnavailable)
+				},
+			},
+			status: 503,
+		},
 		//{
 		//	name:   "error special case incoming body is not a user - error unmarshal",
 		//	url:    "/auth",
@@ -171,7 +1250,14 @@ func TestNewAuthHandler(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			rh := NewAuthHandler(&test.service)
+			if test.cert != nil {
+				req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{test.cert}}
+			}
+			if test.proof != "" {
+				req.Header.Set(auth.DPoPHeader, test.proof)
+			}
+
+			rh := NewAuthHandler(&test.service, test.dpop)
 
 			// We sum a ResponseRecorder (which satisfies http.ResponseWriter) to record the response.
 			rr := httptest.NewRecorder()
@@ -186,6 +1272,15 @@ func TestNewAuthHandler(t *testing.T) {
 			if rr.Code != test.status {
 				t.Errorf("handler returned wrong status code: expectedRes %v got %v", test.status, rr.Code)
 			}
+			if test.dpop != nil && rr.Header().Get(auth.DPoPNonceHeader) != "aNonce" {
+				t.Errorf("expected nonce for the next proof got %s", rr.Header().Get(auth.DPoPNonceHeader))
+			}
+			if rr.Code >= 400 {
+				if p := checkProblem(t, rr); p.Error != test.expectedRes {
+					t.Errorf("expected error %q got %q", test.expectedRes, p.Error)
+				}
+				return
+			}
 			if rr.Body.Len() > 0 {
 				res := &response.JWT{}
 				dec := json.NewDecoder(rr.Body)
@@ -193,10 +1288,166 @@ func TestNewAuthHandler(t *testing.T) {
 				if err := dec.Decode(&res); err != nil {
 					t.Error("unable to decode body response")
 				}
-				if res.JWT != test.expectedRes {
-					t.Errorf("error expectedRes response %s got %s", test.expectedRes, res)
+				if res.JWT != test.expectedRes || res.TokenType != test.expectedType {
+					t.Errorf("error expectedRes response %s %s got %+v", test.expectedRes, test.expectedType, res)
+				}
+			}
+		})
+	}
+}
+
+type ReceipterServiceMock struct {
+	issueReceipt  func(rc auth.Receipt) (string, error)
+	verifyReceipt func(receipt string) (*auth.Receipt, error)
+}
+
+func (rsm ReceipterServiceMock) IssueReceipt(rc auth.Receipt) (string, error) {
+	if rsm.issueReceipt != nil {
+		return rsm.issueReceipt(rc)
+	}
+	panic("Not implemented")
+}
+
+func (rsm ReceipterServiceMock) VerifyReceipt(receipt string) (*auth.Receipt, error) {
+	if rsm.verifyReceipt != nil {
+		return rsm.verifyReceipt(receipt)
+	}
+	panic("Not implemented")
+}
+
+func TestReceiptHandler_VerifyReceiptHandler(t *testing.T) {
+	doc := []byte(`[1,2,3,4]`)
+	valid := func(receipt string) (*auth.Receipt, error) {
+		return &auth.Receipt{Result: "aHash", Digest: auth.DocumentDigest(doc), Subject: "qwerty", IssuedAt: 1}, nil
+	}
+	tests := []struct {
+		name           string
+		requestPayload response.VerifyReceipt
+		service        ReceipterServiceMock
+		status         int
+	}{
+		{
+			name:           "Successful request",
+			requestPayload: response.VerifyReceipt{Receipt: "aReceipt"},
+			service:        ReceipterServiceMock{verifyReceipt: valid},
+			status:         200,
+		},
+		{
+			name:           "Successful request with document",
+			requestPayload: response.VerifyReceipt{Receipt: "aReceipt", Document: doc},
+			service:        ReceipterServiceMock{verifyReceipt: valid},
+			status:         200,
+		},
+		{
+			name:           "error - document not covered by the receipt",
+			requestPayload: response.VerifyReceipt{Receipt: "aReceipt", Document: []byte(`[1,2,3]`)},
+			service:        ReceipterServiceMock{verifyReceipt: valid},
+			status:         422,
+		},
+		{
+			name:           "error - invalid receipt",
+			requestPayload: response.VerifyReceipt{Receipt: "aReceipt"},
+			service: ReceipterServiceMock{
+				verifyReceipt: func(receipt string) (*auth.Receipt, error) {
+					return nil, service.ErrInvalidReceipt
+				},
+			},
+			status: 422,
+		},
+		{
+			name:   "error - missing receipt",
+			status: 400,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jsonBody, _ := json.Marshal(&test.requestPayload)
+			req, err := http.NewRequest("POST", "/receipts/verify", bytes.NewBuffer(jsonBody))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rh := NewReceiptHandler(&test.service)
+
+			rr := httptest.NewRecorder()
+			servicesRouter := mux.NewRouter()
+			servicesRouter.HandleFunc("/receipts/verify", rh.VerifyReceiptHandler).Methods("POST")
+			servicesRouter.ServeHTTP(rr, req)
+
+			if rr.Code != test.status {
+				t.Errorf("handler returned wrong status code: expectedRes %v got %v", test.status, rr.Code)
+			}
+			if rr.Code >= 400 {
+				checkProblem(t, rr)
+			}
+			if rr.Code == 200 {
+				res := response.Receipt{}
+				if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+					t.Error("unable to decode body response")
+				}
+				if res.Subject != "qwerty" {
+					t.Errorf("error expectedRes subject qwerty got %s", res.Subject)
 				}
 			}
 		})
 	}
 }
+
+func TestHealthHandler(t *testing.T) {
+	shutdown := &health.Shutdown{}
+	checks := health.NewRegistry(0)
+	checks.Register("token-store", health.CheckerFunc(func(ctx context.Context) error { return nil }))
+	checks.Register("shutdown", shutdown)
+	hh := NewHealthHandler(checks)
+	servicesRouter := mux.NewRouter()
+	servicesRouter.HandleFunc("/healthz", hh.LivenessHandler).Methods("GET")
+	servicesRouter.HandleFunc("/readyz", hh.ReadinessHandler).Methods("GET")
+
+	tests := []struct {
+		name     string
+		url      string
+		shutdown bool
+		status   int
+		expected response.Health
+	}{
+		{name: "alive", url: "/healthz", status: 200, expected: response.Health{Status: "ok"}},
+		{
+			name: "ready", url: "/readyz", status: 200,
+			expected: response.Health{Status: "ok", Checks: map[string]response.HealthCheck{
+				"shutdown": {Status: "ok"}, "token-store": {Status: "ok"},
+			}},
+		},
+		{
+			name: "shutting down", url: "/readyz", shutdown: true, status: 503,
+			expected: response.Health{Status: "unavailable", Checks: map[string]response.HealthCheck{
+				"shutdown": {Status: "unavailable", Error: "shutting down"}, "token-store": {Status: "ok"},
+			}},
+		},
+		{name: "alive while shutting down", url: "/healthz", status: 200, expected: response.Health{Status: "ok"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.shutdown {
+				shutdown.Start()
+			}
+			req, _ := http.NewRequest("GET", test.url, nil)
+			rr := httptest.NewRecorder()
+			servicesRouter.ServeHTTP(rr, req)
+			if rr.Code != test.status || rr.Header().Get("Content-Type") != "application/json" {
+				t.Errorf("handler returned wrong status code: expectedRes %v got %v %s", test.status, rr.Code, rr.Header().Get("Content-Type"))
+			}
+			res := response.Health{}
+			if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+				t.Fatalf("error decoding body: %s", err.Error())
+			}
+			for name, c := range res.Checks {
+				c.DurationMS = 0
+				res.Checks[name] = c
+			}
+			if !reflect.DeepEqual(res, test.expected) {
+				t.Errorf("error expectedRes body %+v got %+v", test.expected, res)
+			}
+		})
+	}
+}
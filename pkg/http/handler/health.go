@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/qredo-external/go-rnov/pkg/health"
+	response "github.com/qredo-external/go-rnov/pkg/http/json"
+	"github.com/qredo-external/go-rnov/pkg/logger"
+)
+
+const (
+	statusOK          = "ok"
+	statusUnavailable = "unavailable"
+)
+
+// HealthHandler - holds the checkers deciding whether the service is ready for traffic
+type HealthHandler struct {
+	checks *health.Registry
+}
+
+// NewHealthHandler - health handler constructor
+func NewHealthHandler(checks *health.Registry) *HealthHandler {
+	return &HealthHandler{
+		checks: checks,
+	}
+}
+
+// LivenessHandler - the process is up and serving, dependencies are not checked so a failing one does not get the
+// service restarted.
+func (hh *HealthHandler) LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, r, &response.Health{Status: statusOK})
+}
+
+// ReadinessHandler - runs every check, 200 when all pass and 503 otherwise, with the outcome of each one.
+func (hh *HealthHandler) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	rep := hh.checks.Check(r.Context())
+	res := &response.Health{Status: statusOK, Checks: make(map[string]response.HealthCheck, len(rep.Results))}
+	for _, c := range rep.Results {
+		hc := response.HealthCheck{Status: statusOK, DurationMS: float64(c.Duration) / float64(time.Millisecond)}
+		if c.Err != nil {
+			hc.Status, hc.Error = statusUnavailable, c.Err.Error()
+			logger.FromContext(r.Context(), logger.Nop()).Warn("readiness check failed",
+				logger.F("check", c.Name), logger.F("error", c.Err))
+		}
+		res.Checks[c.Name] = hc
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if !rep.Ready {
+		res.Status = statusUnavailable
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	writeJSON(w, r, res)
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	response "github.com/qredo-external/go-rnov/pkg/http/json"
+	"github.com/qredo-external/go-rnov/pkg/http/problem"
+	"github.com/qredo-external/go-rnov/pkg/service"
+	"github.com/qredo-external/go-rnov/pkg/storage"
+)
+
+// computationVar - route variable holding the computation id.
+const computationVar = "id"
+
+// HistoryHandler - holds the service that keeps the history of computations
+type HistoryHandler struct {
+	history service.Historian
+}
+
+// NewHistoryHandler - history handler constructor
+func NewHistoryHandler(hs service.Historian) *HistoryHandler {
+	return &HistoryHandler{
+		history: hs,
+	}
+}
+
+// ListHistoryHandler - handler that lists the computations of the subject, newest first, paginated with the `offset`
+// and `limit` query parameters
+func (hh *HistoryHandler) ListHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	offset, err := queryInt(r, "offset")
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	limit, err := queryInt(r, "limit")
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	page, err := hh.history.ListComputations(subject(r), offset, limit)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	rBody := &response.History{
+		Computations: make([]response.Computation, 0, len(page.Computations)),
+		Total:        page.Total,
+		Offset:       page.Offset,
+		Limit:        page.Limit,
+	}
+	for _, c := range page.Computations {
+		rBody.Computations = append(rBody.Computations, toComputation(c))
+	}
+	writeJSON(w, r, rBody)
+}
+
+// GetComputationHandler - handler that returns one computation of the subject
+func (hh *HistoryHandler) GetComputationHandler(w http.ResponseWriter, r *http.Request) {
+	c, err := hh.history.GetComputation(subject(r), mux.Vars(r)[computationVar])
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	rBody := toComputation(c)
+	writeJSON(w, r, &rBody)
+}
+
+// queryInt - integer value of the query parameter, 0 when missing.
+func queryInt(r *http.Request, name string) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s is not an integer", errInvalidRequest, name)
+	}
+	return n, nil
+}
+
+func toComputation(c storage.Computation) response.Computation {
+	return response.Computation{
+		ID:         c.ID,
+		Operation:  c.Operation,
+		CreatedAt:  c.CreatedAt,
+		Digest:     c.Digest,
+		Size:       c.Size,
+		Result:     c.Result,
+		DurationMS: float64(c.Duration) / float64(time.Millisecond),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	body, jsonErr := json.Marshal(v)
+	if jsonErr != nil {
+		problem.Write(w, r, jsonErr)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/qredo-external/go-rnov/pkg/auth"
+	"github.com/qredo-external/go-rnov/pkg/http/decoder"
+	response "github.com/qredo-external/go-rnov/pkg/http/json"
+	"github.com/qredo-external/go-rnov/pkg/http/problem"
+	"github.com/qredo-external/go-rnov/pkg/service"
+	"github.com/qredo-external/go-rnov/pkg/storage"
+)
+
+// jobVar - route variable holding the job id.
+const jobVar = "id"
+
+// JobHandler - holds the service that runs operations asynchronously
+type JobHandler struct {
+	jobs     service.Jobs
+	decoders *decoder.Registry
+}
+
+// NewJobHandler - job handler constructor, decoders nil accepts the default formats.
+func NewJobHandler(jobs service.Jobs, dec *decoder.Registry) *JobHandler {
+	if dec == nil {
+		dec = decoder.Default()
+	}
+	return &JobHandler{
+		jobs:     jobs,
+		decoders: dec,
+	}
+}
+
+// SubmitSumHandler - handler that queues a Sum and answers 202 with the job and its location
+func (jh *JobHandler) SubmitSumHandler(w http.ResponseWriter, r *http.Request) {
+	raw, jsonMap, err := decodeDocument(jh.decoders, r)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	job, err := jh.jobs.SubmitSum(r.Context(), subject(r), raw, jsonMap, optionsFromQuery(r.URL.Query()))
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeJob(w, r, http.StatusAccepted, job)
+}
+
+// GetJobHandler - handler that reports the status and result of a job
+func (jh *JobHandler) GetJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, err := jh.jobs.GetJob(subject(r), mux.Vars(r)[jobVar])
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	writeJob(w, r, http.StatusOK, job)
+}
+
+// CancelJobHandler - handler that cancels a job that did not finish yet
+func (jh *JobHandler) CancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, err := jh.jobs.CancelJob(subject(r), mux.Vars(r)[jobVar])
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	writeJob(w, r, http.StatusOK, job)
+}
+
+// subject - subject of the token that authenticated the request, empty if none.
+func subject(r *http.Request) string {
+	if c, ok := auth.FromContext(r.Context()); ok {
+		return c.Subject
+	}
+	return ""
+}
+
+func writeJob(w http.ResponseWriter, r *http.Request, status int, job storage.Job) {
+	body, jsonErr := json.Marshal(&response.Job{
+		ID:        job.ID,
+		Status:    job.Status,
+		Result:    job.Result,
+		Error:     job.Error,
+		CreatedAt: job.CreatedAt,
+		UpdatedAt: job.UpdatedAt,
+	})
+	if jsonErr != nil {
+		problem.Write(w, r, jsonErr)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"net/http"
+
+	response "github.com/qredo-external/go-rnov/pkg/http/json"
+	"github.com/qredo-external/go-rnov/pkg/http/problem"
+	"github.com/qredo-external/go-rnov/pkg/service"
+)
+
+// UsageHandler - holds the service that keeps the usage of the subjects against their quota
+type UsageHandler struct {
+	quotas service.Quotas
+}
+
+// NewUsageHandler - usage handler constructor
+func NewUsageHandler(qt service.Quotas) *UsageHandler {
+	return &UsageHandler{
+		quotas: qt,
+	}
+}
+
+// GetUsageHandler - handler that returns the usage of the subject over the current quota period and what remains of
+// its quota
+func (uh *UsageHandler) GetUsageHandler(w http.ResponseWriter, r *http.Request) {
+	u, err := uh.quotas.GetUsage(r.Context(), subject(r))
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	writeJSON(w, r, &response.Usage{
+		Period:     u.Quota.Period,
+		Start:      u.Start,
+		Reset:      u.Reset,
+		Operations: toAllowance(u.Operations, u.Quota.Operations, u.RemainingOperations()),
+		Bytes:      toAllowance(u.Bytes, u.Quota.Bytes, u.RemainingBytes()),
+	})
+}
+
+// toAllowance - response representation of the usage of a resource, remaining is negative when it is not limited.
+func toAllowance(used, limit, remaining int) response.Allowance {
+	a := response.Allowance{Used: used}
+	if remaining >= 0 {
+		a.Limit, a.Remaining = limit, &remaining
+	}
+	return a
+}
@@ -1,9 +1,155 @@
 package json
 
+import "time"
+
+// Operation - ID identifies the computation in the history of the subject when recorded, Digest is the RFC 8785
+// digest of the document and Cached whether the result came from the cache, both only set when the service caches
+// results.
 type Operation struct {
-	Result string `json:"result"`
+	ID          string       `json:"id,omitempty"`
+	Result      string       `json:"result"`
+	Digest      string       `json:"digest,omitempty"`
+	Cached      bool         `json:"cached,omitempty"`
+	Receipt     string       `json:"receipt,omitempty"`
+	Explanation *Explanation `json:"explanation,omitempty"`
+}
+
+// Explanation - every number that contributed to a sum with its JSON Pointer and every value ignored.
+type Explanation struct {
+	Numbers []Contribution `json:"numbers"`
+	Ignored []Ignored      `json:"ignored"`
+	Sum     int            `json:"sum"`
+}
+
+type Contribution struct {
+	Pointer string  `json:"pointer"`
+	Value   float64 `json:"value"`
+	Added   int     `json:"added"`
+	Total   int     `json:"total"`
+}
+
+type Ignored struct {
+	Pointer string      `json:"pointer"`
+	Value   interface{} `json:"value"`
+	Reason  string      `json:"reason"`
+}
+
+// Aggregation - Value is a number except for histograms where it is an object of bucket to count, ID identifies the
+// computation in the history of the subject when recorded.
+type Aggregation struct {
+	ID        string      `json:"id,omitempty"`
+	Operation string      `json:"operation"`
+	Value     interface{} `json:"value"`
+	Result    string      `json:"result"`
+}
+
+// BatchResult - outcome of one document of a batch, Line is its 1-based line (ndjson) or record (json-seq) number and
+// either Result or Error is set. ID identifies the computation in the history of the subject when recorded.
+type BatchResult struct {
+	Line   int    `json:"line"`
+	ID     string `json:"id,omitempty"`
+	Result string `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Job - state of an asynchronous operation, Result is set once it succeeded and Error once it failed.
+type Job struct {
+	ID        string    `json:"id"`
+	Status    string    `json:"status"`
+	Result    string    `json:"result,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Computation - a computation recorded in the history, Digest is the SHA256 of the raw document and Size its length.
+type Computation struct {
+	ID         string    `json:"id"`
+	Operation  string    `json:"operation"`
+	CreatedAt  time.Time `json:"created_at"`
+	Digest     string    `json:"digest"`
+	Size       int       `json:"size"`
+	Result     string    `json:"result"`
+	DurationMS float64   `json:"duration_ms"`
+}
+
+// History - a page of computations, newest first, Total counts all the computations of the subject.
+type History struct {
+	Computations []Computation `json:"computations"`
+	Total        int           `json:"total"`
+	Offset       int           `json:"offset"`
+	Limit        int           `json:"limit"`
 }
 
 type JWT struct {
 	JWT string `json:"jwt"`
+	// TokenType - `DPoP` when the token is bound to the key of the DPoP proof, empty for bearer tokens.
+	TokenType string `json:"token_type,omitempty"`
+}
+
+// VerifyReceipt - request body to verify a receipt, Document is optional and holds the base64 of the raw document
+// originally sent, so it is compared byte by byte.
+type VerifyReceipt struct {
+	Receipt  string `json:"receipt"`
+	Document []byte `json:"document,omitempty"`
+}
+
+type Receipt struct {
+	Result   string `json:"result"`
+	Digest   string `json:"digest"`
+	Options  string `json:"opts,omitempty"`
+	Subject  string `json:"sub"`
+	IssuedAt int64  `json:"iat"`
+}
+
+// Problem - RFC 7807 problem details, Type identifies the error so clients can branch on it. RequestID is an
+// extension member to correlate the response with the request and Quota one detailing the quota that was exceeded.
+type Problem struct {
+	Type      string         `json:"type"`
+	Title     string         `json:"title"`
+	Status    int            `json:"status"`
+	Detail    string         `json:"detail,omitempty"`
+	Instance  string         `json:"instance,omitempty"`
+	RequestID string         `json:"request_id,omitempty"`
+	Quota     *QuotaExceeded `json:"quota,omitempty"`
+	// Error - OAuth error code (RFC 6749 section 5.2) of the problems clients of the token endpoint act on.
+	Error string `json:"error,omitempty"`
+}
+
+// QuotaExceeded - the quota of Resource, `operations` or `bytes`, a request was refused by and when it resets.
+type QuotaExceeded struct {
+	Resource string    `json:"resource"`
+	Limit    int       `json:"limit"`
+	Used     int       `json:"used"`
+	Period   string    `json:"period"`
+	Reset    time.Time `json:"reset"`
+}
+
+// Usage - operations and bytes processed by the subject over the current quota period, from Start to Reset.
+type Usage struct {
+	Period     string    `json:"period"`
+	Start      time.Time `json:"start"`
+	Reset      time.Time `json:"reset"`
+	Operations Allowance `json:"operations"`
+	Bytes      Allowance `json:"bytes"`
+}
+
+// Allowance - usage of a resource, Limit and Remaining are omitted when it is not limited.
+type Allowance struct {
+	Used      int  `json:"used"`
+	Limit     int  `json:"limit,omitempty"`
+	Remaining *int `json:"remaining,omitempty"`
+}
+
+// Health - readiness report, Status is `ok` or `unavailable` and Checks holds the outcome of each check by name.
+type Health struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+// HealthCheck - outcome of one readiness check, Error is only set when it failed.
+type HealthCheck struct {
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
 }
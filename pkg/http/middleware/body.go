@@ -0,0 +1,14 @@
+package middleware
+
+import "net/http"
+
+// MaxBytes - custom HTTP middleware that bounds the body next can read to n bytes, reading past them fails with an
+// *http.MaxBytesError and closes the connection once the response is written. A non positive n leaves it unbounded.
+func MaxBytes(n int64, next func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if n > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/qredo-external/go-rnov/pkg/http/requestid"
+	"github.com/qredo-external/go-rnov/pkg/logger"
+)
+
+// requestLog - fields of the access log entry known only to inner middleware, e.g. the subject once authenticated.
+type requestLog struct {
+	subject string
+}
+
+type requestLogKey struct{}
+
+// Logging - HTTP middleware that logs every request once served with its request id, route, subject, status and
+// latency. The request context carries a logger with the request id and route so handlers and services log with
+// them, it must run after RequestID.
+func Logging(lg logger.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rl := lg.With(
+				logger.F("request_id", requestid.FromContext(r.Context())),
+				logger.F("method", r.Method),
+				logger.F("route", route(r)),
+			)
+			info := &requestLog{}
+			ctx := logger.NewContext(context.WithValue(r.Context(), requestLogKey{}, info), rl)
+			sw := &statusWriter{ResponseWriter: w}
+			next.ServeHTTP(sw, r.WithContext(ctx))
+
+			fields := []logger.Field{
+				logger.F("status", sw.code()),
+				logger.F("latency_ms", float64(time.Since(start))/float64(time.Millisecond)),
+				logger.F("bytes", sw.written),
+			}
+			if info.subject != "" {
+				fields = append(fields, logger.F("subject", info.subject))
+			}
+			if sw.code() >= http.StatusInternalServerError {
+				rl.Error("request served", fields...)
+				return
+			}
+			rl.Info("request served", fields...)
+		})
+	}
+}
+
+// route - path template of the matched route, so entries of the same route share it, or the path if none matched.
+func route(r *http.Request) string {
+	if cr := mux.CurrentRoute(r); cr != nil {
+		if tpl, err := cr.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return r.URL.Path
+}
+
+// withSubject - attaches the subject to the access log entry and to the request logger.
+func withSubject(ctx context.Context, subject string) context.Context {
+	if info, ok := ctx.Value(requestLogKey{}).(*requestLog); ok {
+		info.subject = subject
+	}
+	return logger.NewContext(ctx, logger.FromContext(ctx, logger.Nop()).With(logger.F("subject", subject)))
+}
+
+// statusWriter - records the status and size of the response, streaming handlers can still flush it.
+type statusWriter struct {
+	http.ResponseWriter
+	status  int
+	written int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(b)
+	sw.written += n
+	return n, err
+}
+
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (sw *statusWriter) code() int {
+	if sw.status == 0 {
+		return http.StatusOK
+	}
+	return sw.status
+}
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+)
+
+// RequestObserver - port through which served requests are measured, implemented by a metrics adapter.
+type RequestObserver interface {
+	RequestServed(method, route string, status int, d time.Duration)
+}
+
+// Instrument - HTTP middleware that reports every served request with its route template, status and latency to
+// the observer.
+func Instrument(obs RequestObserver) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w}
+			next.ServeHTTP(sw, r)
+			obs.RequestServed(r.Method, route(r), sw.code(), time.Since(start))
+		})
+	}
+}
@@ -1,61 +1,182 @@
 package middleware
 
 import (
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/qredo-external/go-rnov/pkg/auth"
+	"github.com/qredo-external/go-rnov/pkg/http/problem"
+	"github.com/qredo-external/go-rnov/pkg/logger"
+	"github.com/qredo-external/go-rnov/pkg/service"
 	"github.com/qredo-external/go-rnov/pkg/storage"
 )
 
 const (
 	authHeader = "Authorization"
 	basic      = "Basic"
+	// dpopScheme - authorization scheme of the tokens bound to a DPoP key (RFC 9449).
+	dpopScheme = "DPoP"
 )
 
+var errMissingToken = service.NewError(service.KindUnauthorized, "missing-token", "missing or malformed authorization header")
+
 // AuthMiddleware - access to auth storage to check token data.
 type AuthMiddleware struct {
 	auth.Operations
 	storage storage.ManageUsers
+	dpop    auth.ProofVerifier
+	log     logger.Logger
+	metrics service.Metrics
 }
 
-// NewAuthMiddleware - auth middleware constructor.
-func NewAuthMiddleware(auth auth.Operations, storage storage.ManageUsers) *AuthMiddleware {
+// NewAuthMiddleware - auth middleware constructor, dpop nil rejects the tokens bound to a DPoP key, lg nil discards
+// the logs and mt nil the measurements.
+func NewAuthMiddleware(auth auth.Operations, storage storage.ManageUsers, dpop auth.ProofVerifier, lg logger.Logger, mt service.Metrics) *AuthMiddleware {
+	if lg == nil {
+		lg = logger.Nop()
+	}
+	if mt == nil {
+		mt = service.NopMetrics()
+	}
 	return &AuthMiddleware{
 		Operations: auth,
 		storage:    storage,
+		dpop:       dpop,
+		log:        lg,
+		metrics:    mt,
 	}
 }
 
 // Authentication - custom HTTP middleware that validates user's basic auth.
 func Authentication(auth AuthMiddleware, next func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var jwt string
 		ah := r.Header.Get(authHeader)
-		jwt, valid := validateAuthStructure(ah)
+		scheme, jwt, valid := validateAuthStructure(ah)
 		if !valid {
-			w.WriteHeader(http.StatusUnauthorized)
+			auth.reject(errMissingToken)
+			problem.Write(w, r, errMissingToken)
 			return
 		}
 		// note check whether is a valid token - issued by us and still usable -timestamp-
-		if err := auth.ValidateJWT(jwt); err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
+		claims, err := auth.ValidateJWT(r.Context(), jwt)
+		if err != nil {
+			auth.requestLogger(r).Info("token rejected", logger.F("reason", err))
+			auth.reject(err)
+			problem.Write(w, r, err)
+			return
+		}
+		if err := auth.confirm(w, r, scheme, jwt, claims); err != nil {
+			auth.requestLogger(r).Info("token rejected", logger.F("reason", err))
+			auth.reject(err)
+			problem.Write(w, r, err)
 			return
 		}
 		// note check whether despite being a valid token it might been invalidated in our system
-		if !auth.storage.IsActiveToken(jwt) {
-			w.WriteHeader(http.StatusUnauthorized)
+		active, err := auth.storage.IsActiveToken(r.Context(), jwt)
+		if err != nil {
+			problem.Write(w, r, fmt.Errorf("error checking token: %w", err))
 			return
 		}
-		next(w, r)
+		if !active {
+			auth.requestLogger(r).Info("token rejected", logger.F("reason", service.ErrTokenRevoked))
+			auth.reject(service.ErrTokenRevoked)
+			problem.Write(w, r, service.ErrTokenRevoked)
+			return
+		}
+		if auth.metrics != nil {
+			auth.metrics.TokenValidated()
+		}
+		next(w, withClaims(r, claims))
+	}
+}
+
+// validateAuthStructure - validates that the authorization header value provided by the user has a valid structure,
+// returning its scheme and token.
+func validateAuthStructure(ah string) (string, string, bool) {
+	if res := strings.Split(ah, " "); (res[0] == basic || res[0] == dpopScheme) && len(res) == 2 {
+		return res[0], res[1], true
+	}
+
+	return "", "", false
+}
+
+// confirm - checks the request proves possession of the key the token is bound to, if any. A token bound to a
+// certificate is only usable over a connection authenticated by it (RFC 8705), one bound to a DPoP key only with the
+// DPoP scheme and a proof signed by the key (RFC 9449).
+func (am AuthMiddleware) confirm(w http.ResponseWriter, r *http.Request, scheme, token string, c *auth.Claims) error {
+	if err := c.Confirmation.VerifyCertificate(peerCertificate(r)); err != nil {
+		return err
+	}
+	// note a bearer token sent with the DPoP scheme is rejected as well, so clients can not downgrade unnoticed
+	if (c.Confirmation.JKT != "") != (scheme == dpopScheme) {
+		return auth.ErrTokenBinding
+	}
+	if scheme != dpopScheme {
+		return nil
+	}
+	if am.dpop == nil {
+		return fmt.Errorf("%w: dpop not supported", auth.ErrDPoPProof)
+	}
+	if nonce := am.dpop.Nonce(); nonce != "" {
+		w.Header().Set(auth.DPoPNonceHeader, nonce)
+	}
+	proof, err := verifyProof(r, am.dpop, token)
+	if err != nil {
+		if challenge := dpopChallenge(err); challenge != "" {
+			w.Header().Set("WWW-Authenticate", challenge)
+		}
+		return err
 	}
+	return c.Confirmation.VerifyProof(proof)
 }
 
-// validateAuthStructure - validates that the authorization header value provided by the user has a valid structure.
-func validateAuthStructure(ah string) (string, bool) {
-	if res := strings.Split(ah, " "); res[0] == basic && len(res) == 2 {
-		return res[1], true
+// verifyProof - the DPoP proof of the request, which must carry exactly one, for token.
+func verifyProof(r *http.Request, dpop auth.ProofVerifier, token string) (*auth.Proof, error) {
+	if proofs := r.Header.Values(auth.DPoPHeader); len(proofs) > 1 {
+		return nil, fmt.Errorf("%w: more than one %s header", auth.ErrDPoPProof, auth.DPoPHeader)
 	}
+	return dpop.VerifyProof(r.Context(), r.Header.Get(auth.DPoPHeader), auth.NewProofRequest(r, token))
+}
 
-	return "", false
+// dpopChallenge - the WWW-Authenticate challenge telling the client why its proof was refused (RFC 9449 section 7.1).
+func dpopChallenge(err error) string {
+	switch {
+	case errors.Is(err, auth.ErrDPoPNonce):
+		return `DPoP error="use_dpop_nonce", error_description="Resource server requires nonce in DPoP proof"`
+	case errors.Is(err, auth.ErrDPoPProof):
+		return `DPoP error="invalid_dpop_proof"`
+	default:
+		return ""
+	}
+}
+
+// peerCertificate - the certificate the client authenticated the connection with, nil if none.
+func peerCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return r.TLS.PeerCertificates[0]
+}
+
+// withClaims - attaches the claims of the validated token to the request so handlers can identify the subject.
+func withClaims(r *http.Request, c *auth.Claims) *http.Request {
+	return r.WithContext(auth.NewContext(withSubject(r.Context(), c.Subject), c))
+}
+
+// requestLogger - the logger of the request, the one of the middleware if the request has none.
+func (am AuthMiddleware) requestLogger(r *http.Request) logger.Logger {
+	if am.log == nil {
+		am.log = logger.Nop()
+	}
+	return logger.FromContext(r.Context(), am.log)
+}
+
+// reject - counts a rejected token by the code of its error, storage failures are not rejections.
+func (am AuthMiddleware) reject(err error) {
+	if am.metrics != nil {
+		am.metrics.TokenRejected(service.AsError(err).Code)
+	}
 }
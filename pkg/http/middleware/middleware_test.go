@@ -1,44 +1,77 @@
 package middleware
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 
+	"github.com/qredo-external/go-rnov/pkg/auth"
+	response "github.com/qredo-external/go-rnov/pkg/http/json"
+	"github.com/qredo-external/go-rnov/pkg/http/requestid"
+	"github.com/qredo-external/go-rnov/pkg/logger"
+	"github.com/qredo-external/go-rnov/pkg/ratelimit"
+	"github.com/qredo-external/go-rnov/pkg/storage"
+	"github.com/qredo-external/go-rnov/pkg/trace"
 	"github.com/qredo-external/go-rnov/pkg/user"
 )
 
 type authOpMock struct {
-	validateJWT func(ba string) error
+	validateJWT func(ba string) (*auth.Claims, error)
 }
 
-func (am *authOpMock) CreateJWT(usr user.User) (string, error) {
+func (am *authOpMock) CreateJWT(ctx context.Context, usr user.User, cnf auth.Confirmation) (string, error) {
 	panic("Not implemented")
 }
 
-func (am *authOpMock) ValidateJWT(JWT string) error {
+func (am *authOpMock) ValidateJWT(ctx context.Context, JWT string) (*auth.Claims, error) {
 	if am.validateJWT != nil {
 		return am.validateJWT(JWT)
 	}
 	panic("Not implemented")
 }
 
+type proofVerifierMock struct {
+	verifyProof func(proof string, req auth.ProofRequest) (*auth.Proof, error)
+	nonce       string
+}
+
+func (pm proofVerifierMock) VerifyProof(ctx context.Context, proof string, req auth.ProofRequest) (*auth.Proof, error) {
+	if pm.verifyProof != nil {
+		return pm.verifyProof(proof, req)
+	}
+	panic("Not implemented")
+}
+
+func (pm proofVerifierMock) Nonce() string {
+	return pm.nonce
+}
+
 type manageUsersMock struct {
-	addUserToken  func(token string) bool
-	isActiveToken func(token string) bool
+	addUserToken  func(token string) error
+	isActiveToken func(token string) (bool, error)
 }
 
-func (m manageUsersMock) AddUserToken(token string) bool {
+func (m manageUsersMock) AddUserToken(ctx context.Context, token string) error {
 	if m.addUserToken != nil {
 		return m.addUserToken(token)
 	}
 	panic("implement me")
 }
 
-func (m manageUsersMock) IsActiveToken(token string) bool {
+func (m manageUsersMock) IsActiveToken(ctx context.Context, token string) (bool, error) {
 	if m.isActiveToken != nil {
 		return m.isActiveToken(token)
 	}
@@ -46,31 +79,74 @@ func (m manageUsersMock) IsActiveToken(token string) bool {
 }
 
 func TestAuthentication(t *testing.T) {
+	clientCert, otherCert := &x509.Certificate{Raw: []byte("client")}, &x509.Certificate{Raw: []byte("other")}
+	bound := AuthMiddleware{
+		Operations: &authOpMock{
+			validateJWT: func(ba string) (*auth.Claims, error) {
+				return &auth.Claims{Subject: "qwerty", Confirmation: auth.Confirmation{X5tS256: auth.CertThumbprint(clientCert)}}, nil
+			},
+		},
+		storage: manageUsersMock{
+			isActiveToken: func(token string) (bool, error) {
+				return true, nil
+			},
+		},
+	}
+	dpopBound := bound
+	dpopBound.Operations = &authOpMock{
+		validateJWT: func(ba string) (*auth.Claims, error) {
+			return &auth.Claims{Subject: "qwerty", Confirmation: auth.Confirmation{JKT: "clientKey"}}, nil
+		},
+	}
+	dpopBound.dpop = proofVerifierMock{
+		verifyProof: func(proof string, req auth.ProofRequest) (*auth.Proof, error) {
+			if req.Method != "POST" || req.URL != "http://localhost/sum" || req.AccessToken != "dXNlcm5hbWU6cGFzc3dvcmQ=" {
+				return nil, fmt.Errorf("%w: proof of another request %+v", auth.ErrDPoPProof, req)
+			}
+			switch proof {
+			case "clientProof":
+				return &auth.Proof{JKT: "clientKey"}, nil
+			case "otherProof":
+				return &auth.Proof{JKT: "otherKey"}, nil
+			default:
+				return nil, auth.ErrDPoPNonce
+			}
+		},
+		nonce: "aNonce",
+	}
 	tests := []struct {
 		name           string
 		auth           AuthMiddleware
 		AuthHeader     bool
 		Auth           string
+		cert           *x509.Certificate
+		proof          string
+		expectedHeader http.Header
 		next           func(w http.ResponseWriter, r *http.Request)
 		expectedStatus int
+		expectedType   string
 	}{
 		{
 			name: "successful validation",
 			auth: AuthMiddleware{
 				Operations: &authOpMock{
-					validateJWT: func(ba string) error {
-						return nil
+					validateJWT: func(ba string) (*auth.Claims, error) {
+						return &auth.Claims{Subject: "qwerty"}, nil
 					},
 				},
 				storage: manageUsersMock{
-					isActiveToken: func(token string) bool {
-						return true
+					isActiveToken: func(token string) (bool, error) {
+						return true, nil
 					},
 				},
 			},
-			AuthHeader:     true,
-			Auth:           "Basic dXNlcm5hbWU6cGFzc3dvcmQ=",
-			next:           func(w http.ResponseWriter, r *http.Request) {},
+			AuthHeader: true,
+			Auth:       "Basic dXNlcm5hbWU6cGFzc3dvcmQ=",
+			next: func(w http.ResponseWriter, r *http.Request) {
+				if c, ok := auth.FromContext(r.Context()); !ok || c.Subject != "qwerty" {
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+			},
 			expectedStatus: 200,
 		},
 		{
@@ -88,41 +164,150 @@ func TestAuthentication(t *testing.T) {
 			Auth: "basic dXNlcm5hbWU6cGFzc3dvcmQ=",
 			auth: AuthMiddleware{
 				Operations: &authOpMock{
-					validateJWT: func(ba string) error {
-						return errors.New("not valid auth")
+					validateJWT: func(ba string) (*auth.Claims, error) {
+						return nil, errors.New("not valid auth")
+					},
+				},
+			},
+			AuthHeader:     true,
+			expectedStatus: 401,
+		},
+		{
+			name: "error - expired token",
+			Auth: "Basic dXNlcm5hbWU6cGFzc3dvcmQ=",
+			auth: AuthMiddleware{
+				Operations: &authOpMock{
+					validateJWT: func(ba string) (*auth.Claims, error) {
+						return nil, fmt.Errorf("%w: Token is expired", auth.ErrTokenExpired)
 					},
 				},
 			},
 			AuthHeader:     true,
 			expectedStatus: 401,
+			expectedType:   "/problems/token-expired",
+		},
+		{
+			name: "error - storage unavailable",
+			Auth: "Basic dXNlcm5hbWU6cGFzc3dvcmQ=",
+			auth: AuthMiddleware{
+				Operations: &authOpMock{
+					validateJWT: func(ba string) (*auth.Claims, error) {
+						return &auth.Claims{Subject: "qwerty"}, nil
+					},
+				},
+				storage: manageUsersMock{
+					isActiveToken: func(token string) (bool, error) {
+						return false, storage.ErrUnavailable
+					},
+				},
+			},
+			AuthHeader:     true,
+			expectedStatus: 503,
+			expectedType:   "/problems/storage-unavailable",
 		},
 		{
 			name: "error - deactivated token",
-			Auth: "basic dXNlcm5hbWU6cGFzc3dvcmQ=",
+			Auth: "Basic dXNlcm5hbWU6cGFzc3dvcmQ=",
 			auth: AuthMiddleware{
 				Operations: &authOpMock{
-					validateJWT: func(ba string) error {
-						return nil
+					validateJWT: func(ba string) (*auth.Claims, error) {
+						return &auth.Claims{Subject: "qwerty"}, nil
 					},
 				},
 				storage: manageUsersMock{
-					isActiveToken: func(token string) bool {
-						return false
+					isActiveToken: func(token string) (bool, error) {
+						return false, nil
 					},
 				},
 			},
 			AuthHeader:     true,
 			expectedStatus: 401,
+			expectedType:   "/problems/token-revoked",
+		},
+		{
+			name:           "certificate bound token over the same certificate",
+			Auth:           "Basic dXNlcm5hbWU6cGFzc3dvcmQ=",
+			auth:           bound,
+			cert:           clientCert,
+			next:           func(w http.ResponseWriter, r *http.Request) {},
+			expectedStatus: 200,
+		},
+		{
+			name:           "error - certificate bound token over another certificate",
+			Auth:           "Basic dXNlcm5hbWU6cGFzc3dvcmQ=",
+			auth:           bound,
+			cert:           otherCert,
+			expectedStatus: 401,
+			expectedType:   "/problems/token-binding",
+		},
+		{
+			name:           "error - certificate bound token without certificate",
+			Auth:           "Basic dXNlcm5hbWU6cGFzc3dvcmQ=",
+			auth:           bound,
+			expectedStatus: 401,
+			expectedType:   "/problems/token-binding",
+		},
+		{
+			name:           "dpop bound token with a proof of the key",
+			Auth:           "DPoP dXNlcm5hbWU6cGFzc3dvcmQ=",
+			auth:           dpopBound,
+			proof:          "clientProof",
+			next:           func(w http.ResponseWriter, r *http.Request) {},
+			expectedStatus: 200,
+			expectedHeader: http.Header{"Dpop-Nonce": {"aNonce"}},
+		},
+		{
+			name:           "error - dpop bound token with a proof of another key",
+			Auth:           "DPoP dXNlcm5hbWU6cGFzc3dvcmQ=",
+			auth:           dpopBound,
+			proof:          "otherProof",
+			expectedStatus: 401,
+			expectedType:   "/problems/token-binding",
+		},
+		{
+			name:           "error - dpop bound token without the current nonce",
+			Auth:           "DPoP dXNlcm5hbWU6cGFzc3dvcmQ=",
+			auth:           dpopBound,
+			proof:          "staleProof",
+			expectedStatus: 401,
+			expectedType:   "/problems/use-dpop-nonce",
+			expectedHeader: http.Header{
+				"Dpop-Nonce":       {"aNonce"},
+				"Www-Authenticate": {`DPoP error="use_dpop_nonce", error_description="Resource server requires nonce in DPoP proof"`},
+			},
+		},
+		{
+			name:           "error - dpop bound token as a bearer token",
+			Auth:           "Basic dXNlcm5hbWU6cGFzc3dvcmQ=",
+			auth:           dpopBound,
+			proof:          "clientProof",
+			expectedStatus: 401,
+			expectedType:   "/problems/token-binding",
+		},
+		{
+			name:           "error - bearer token with the dpop scheme",
+			Auth:           "DPoP dXNlcm5hbWU6cGFzc3dvcmQ=",
+			auth:           bound,
+			cert:           clientCert,
+			proof:          "clientProof",
+			expectedStatus: 401,
+			expectedType:   "/problems/token-binding",
 		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			req, err := http.NewRequest("POST", "/sum", nil)
+			req, err := http.NewRequest("POST", "http://localhost/sum", nil)
 			if err != nil {
 				t.Fatal(err)
 			}
 			req.Header.Add(authHeader, test.Auth)
+			if test.proof != "" {
+				req.Header.Add(auth.DPoPHeader, test.proof)
+			}
+			if test.cert != nil {
+				req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{test.cert}}
+			}
 
 			// Create a ResponseRecorder (which satisfies http.ResponseWriter) to record the response.
 			rr := httptest.NewRecorder()
@@ -137,6 +322,380 @@ func TestAuthentication(t *testing.T) {
 			if rr.Code != test.expectedStatus {
 				t.Errorf("handler returned wrong status code: expected %v got %v", test.expectedStatus, rr.Code)
 			}
+			if rr.Code >= 400 && rr.Header().Get("Content-Type") != "application/problem+json" {
+				t.Errorf("expected problem response got %s", rr.Header().Get("Content-Type"))
+			}
+			if test.expectedType != "" {
+				p := response.Problem{}
+				if err := json.NewDecoder(rr.Body).Decode(&p); err != nil || p.Type != test.expectedType {
+					t.Errorf("expected problem type %s got %s %v", test.expectedType, p.Type, err)
+				}
+			}
+			for name, values := range test.expectedHeader {
+				if got := rr.Header()[name]; !reflect.DeepEqual(got, values) {
+					t.Errorf("expected header %s %v got %v", name, values, got)
+				}
+			}
+		})
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	tests := []struct {
+		name        string
+		d           time.Duration
+		expectedErr error
+	}{
+		{
+			name:        "deadline reached",
+			d:           time.Millisecond,
+			expectedErr: context.DeadlineExceeded,
+		},
+		{
+			name: "unbounded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/sum", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ctxErr error
+			next := func(w http.ResponseWriter, r *http.Request) {
+				if _, ok := r.Context().Deadline(); ok {
+					<-r.Context().Done()
+				}
+				ctxErr = r.Context().Err()
+			}
+
+			Deadline(test.d, next)(httptest.NewRecorder(), req)
+			if ctxErr != test.expectedErr {
+				t.Errorf("expected context error %v got %v", test.expectedErr, ctxErr)
+			}
+		})
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	tests := []struct {
+		name     string
+		n        int64
+		body     string
+		expected string
+		tooLarge bool
+	}{
+		{name: "within the limit", n: 5, body: "[1,2]", expected: "[1,2]"},
+		{name: "over the limit", n: 4, body: "[1,2]", expected: "[1,2", tooLarge: true},
+		{name: "unbounded", body: "[1,2]", expected: "[1,2]"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/sum", strings.NewReader(test.body))
+			var read []byte
+			var readErr error
+			next := func(w http.ResponseWriter, r *http.Request) {
+				read, readErr = ioutil.ReadAll(r.Body)
+			}
+
+			MaxBytes(test.n, next)(httptest.NewRecorder(), req)
+			var tooLarge *http.MaxBytesError
+			if string(read) != test.expected || errors.As(readErr, &tooLarge) != test.tooLarge {
+				t.Errorf("expected %q read, too large %t, got %q %v", test.expected, test.tooLarge, read, readErr)
+			}
 		})
 	}
 }
+
+type rateStoreMock struct {
+	take func(key string, l ratelimit.Limit) (float64, bool, error)
+}
+
+func (m rateStoreMock) Take(ctx context.Context, key string, l ratelimit.Limit, now time.Time) (float64, bool, error) {
+	if m.take != nil {
+		return m.take(key, l)
+	}
+	panic("Not implemented")
+}
+
+func TestRateLimit(t *testing.T) {
+	limit := ratelimit.Limit{Requests: 2, Period: time.Minute}
+	rl := *NewRateLimiter(ratelimit.NewLimiter(ratelimit.NewMemory()), nil)
+	tests := []struct {
+		name           string
+		rl             RateLimiter
+		subject        string
+		remoteAddr     string
+		limit          ratelimit.Limit
+		expectedStatus int
+		expectedHeader http.Header
+	}{
+		{name: "first request of a subject", rl: rl, subject: "qwerty", limit: limit, expectedStatus: 200,
+			expectedHeader: http.Header{"Ratelimit-Limit": {"2"}, "Ratelimit-Remaining": {"1"}, "Ratelimit-Reset": {"30"}, "Ratelimit-Policy": {"2;w=60"}}},
+		{name: "burst of a subject", rl: rl, subject: "qwerty", limit: limit, expectedStatus: 200,
+			expectedHeader: http.Header{"Ratelimit-Remaining": {"0"}, "Ratelimit-Reset": {"60"}}},
+		{name: "error - subject over its limit", rl: rl, subject: "qwerty", limit: limit, expectedStatus: 429,
+			expectedHeader: http.Header{"Ratelimit-Remaining": {"0"}, "Retry-After": {"30"}}},
+		{name: "another subject", rl: rl, subject: "asdfgh", limit: limit, expectedStatus: 200,
+			expectedHeader: http.Header{"Ratelimit-Remaining": {"1"}}},
+		{name: "unauthenticated client by ip", rl: rl, remoteAddr: "10.0.0.1:1234", limit: limit, expectedStatus: 200,
+			expectedHeader: http.Header{"Ratelimit-Remaining": {"1"}}},
+		{name: "same ip on another port", rl: rl, remoteAddr: "10.0.0.1:5678", limit: limit, expectedStatus: 200,
+			expectedHeader: http.Header{"Ratelimit-Remaining": {"0"}}},
+		{name: "no limit", rl: rl, subject: "qwerty", expectedStatus: 200, expectedHeader: http.Header{"Ratelimit-Limit": nil}},
+		{
+			name: "store unavailable",
+			rl: *NewRateLimiter(ratelimit.NewLimiter(rateStoreMock{
+				take: func(key string, l ratelimit.Limit) (float64, bool, error) {
+					return 0, false, storage.ErrUnavailable
+				},
+			}), nil),
+			subject:        "qwerty",
+			limit:          limit,
+			expectedStatus: 200,
+			expectedHeader: http.Header{"Ratelimit-Limit": nil},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/sum", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.RemoteAddr = test.remoteAddr
+			if test.subject != "" {
+				req = req.WithContext(auth.NewContext(req.Context(), &auth.Claims{Subject: test.subject}))
+			}
+			rr := httptest.NewRecorder()
+			RateLimit(test.rl, "sum", test.limit, func(w http.ResponseWriter, r *http.Request) {})(rr, req)
+
+			if rr.Code != test.expectedStatus {
+				t.Errorf("expected status %d got %d", test.expectedStatus, rr.Code)
+			}
+			if rr.Code == 429 {
+				p := response.Problem{}
+				if err := json.NewDecoder(rr.Body).Decode(&p); err != nil || p.Type != "/problems/rate-limited" {
+					t.Errorf("expected rate limited problem got %+v %v", p, err)
+				}
+			}
+			for name, values := range test.expectedHeader {
+				if got := rr.Header()[name]; !reflect.DeepEqual(got, values) {
+					t.Errorf("expected header %s %v got %v", name, values, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		reused   bool
+	}{
+		{
+			name:     "reuses a valid id",
+			incoming: "aRequest-1",
+			reused:   true,
+		},
+		{
+			name: "creates a missing id",
+		},
+		{
+			name:     "replaces an invalid id",
+			incoming: "a request",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/sum", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set(requestid.Header, test.incoming)
+			var id string
+			rr := httptest.NewRecorder()
+			RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				id = requestid.FromContext(r.Context())
+			})).ServeHTTP(rr, req)
+
+			if id == "" || rr.Header().Get(requestid.Header) != id {
+				t.Errorf("expected response id %s to match context id %s", rr.Header().Get(requestid.Header), id)
+			}
+			if (id == test.incoming) != test.reused {
+				t.Errorf("expected incoming id reused %t got %s", test.reused, id)
+			}
+		})
+	}
+}
+
+func TestLogging(t *testing.T) {
+	buf := &bytes.Buffer{}
+	am := AuthMiddleware{
+		Operations: &authOpMock{
+			validateJWT: func(ba string) (*auth.Claims, error) {
+				return &auth.Claims{Subject: "qwerty"}, nil
+			},
+		},
+		storage: manageUsersMock{
+			isActiveToken: func(token string) (bool, error) {
+				return true, nil
+			},
+		},
+	}
+	servicesRouter := mux.NewRouter()
+	servicesRouter.Use(RequestID, Logging(logger.NewJSON(buf, logger.LevelInfo)))
+	servicesRouter.HandleFunc("/jobs/{id}", Authentication(am, func(w http.ResponseWriter, r *http.Request) {
+		logger.FromContext(r.Context(), logger.Nop()).Info("handled")
+		w.WriteHeader(http.StatusTeapot)
+	})).Methods("GET")
+
+	req, err := http.NewRequest("GET", "/jobs/aJob", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(authHeader, "Basic aSecretToken")
+	req.Header.Set(requestid.Header, "aRequest")
+	servicesRouter.ServeHTTP(httptest.NewRecorder(), req)
+
+	if strings.Contains(buf.String(), "aSecretToken") {
+		t.Errorf("expected token not to be logged got %s", buf.String())
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 entries got %s", buf.String())
+	}
+	for _, line := range lines {
+		entry := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("invalid entry %s", line)
+		}
+		if entry["request_id"] != "aRequest" || entry["route"] != "/jobs/{id}" || entry["subject"] != "qwerty" {
+			t.Errorf("expected request fields in %s", line)
+		}
+	}
+	if !strings.Contains(lines[1], `"status":418`) || !strings.Contains(lines[1], `"latency_ms"`) {
+		t.Errorf("expected status and latency in %s", lines[1])
+	}
+}
+
+type observerMock struct {
+	requestServed func(method, route string, status int, d time.Duration)
+	tokenRejected func(reason string)
+}
+
+func (o observerMock) RequestServed(method, route string, status int, d time.Duration) {
+	if o.requestServed != nil {
+		o.requestServed(method, route, status, d)
+		return
+	}
+	panic("Not implemented")
+}
+
+func (o observerMock) TokenIssued() {
+	panic("Not implemented")
+}
+
+func (o observerMock) TokenValidated() {
+	panic("Not implemented")
+}
+
+func (o observerMock) TokenRejected(reason string) {
+	if o.tokenRejected != nil {
+		o.tokenRejected(reason)
+		return
+	}
+	panic("Not implemented")
+}
+
+func (o observerMock) SumComputed(size int, d time.Duration, cached bool) {
+	panic("Not implemented")
+}
+
+func TestInstrument(t *testing.T) {
+	var served, rejected []string
+	obs := observerMock{
+		requestServed: func(method, route string, status int, d time.Duration) {
+			served = append(served, fmt.Sprintf("%s %s %d", method, route, status))
+		},
+		tokenRejected: func(reason string) {
+			rejected = append(rejected, reason)
+		},
+	}
+	am := NewAuthMiddleware(&authOpMock{
+		validateJWT: func(ba string) (*auth.Claims, error) {
+			return nil, fmt.Errorf("%w: token is expired", auth.ErrTokenExpired)
+		},
+	}, nil, nil, nil, obs)
+	servicesRouter := mux.NewRouter()
+	servicesRouter.Use(Instrument(obs))
+	servicesRouter.HandleFunc("/jobs/{id}", Authentication(*am, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("expected the request to be rejected")
+	})).Methods("GET")
+
+	for _, header := range []string{"", "Basic anExpiredToken"} {
+		req, err := http.NewRequest("GET", "/jobs/aJob", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(authHeader, header)
+		servicesRouter.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	expectedServed := []string{"GET /jobs/{id} 401", "GET /jobs/{id} 401"}
+	if fmt.Sprint(served) != fmt.Sprint(expectedServed) {
+		t.Errorf("error in requests served: expected %v got %v", expectedServed, served)
+	}
+	expectedRejected := []string{"missing-token", "token-expired"}
+	if fmt.Sprint(rejected) != fmt.Sprint(expectedRejected) {
+		t.Errorf("error in tokens rejected: expected %v got %v", expectedRejected, rejected)
+	}
+}
+
+type spanRecorder struct {
+	spans []trace.SpanData
+}
+
+func (sr *spanRecorder) Export(s trace.SpanData) error {
+	sr.spans = append(sr.spans, s)
+	return nil
+}
+
+func TestTracing(t *testing.T) {
+	rec := &spanRecorder{}
+	buf := &bytes.Buffer{}
+	servicesRouter := mux.NewRouter()
+	servicesRouter.Use(Logging(logger.NewJSON(buf, logger.LevelInfo)), Tracing(trace.NewTracer(rec)))
+	servicesRouter.HandleFunc("/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := trace.Start(r.Context(), "handler", trace.KindInternal)
+		span.End()
+		logger.FromContext(r.Context(), logger.Nop()).Info("handled")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}).Methods("GET")
+
+	req, err := http.NewRequest("GET", "/jobs/aJob", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(trace.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	servicesRouter.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(rec.spans) != 2 {
+		t.Fatalf("expected 2 spans got %+v", rec.spans)
+	}
+	handlerSpan, server := rec.spans[0], rec.spans[1]
+	if server.Name != "GET /jobs/{id}" || server.Kind != trace.KindServer || server.StatusCode != trace.StatusError ||
+		server.SpanContext.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || server.Parent.String() != "00f067aa0ba902b7" {
+		t.Errorf("unexpected server span %+v", server)
+	}
+	if handlerSpan.Parent != server.SpanContext.SpanID {
+		t.Errorf("expected handler span under the server span got %+v", handlerSpan)
+	}
+	if !strings.Contains(buf.String(), `"trace_id":"4bf92f3577b34da6a3ce929d0e0e4736"`) {
+		t.Errorf("expected trace id in the request logger got %s", buf.String())
+	}
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/qredo-external/go-rnov/pkg/auth"
+	"github.com/qredo-external/go-rnov/pkg/http/problem"
+	"github.com/qredo-external/go-rnov/pkg/logger"
+	"github.com/qredo-external/go-rnov/pkg/ratelimit"
+	"github.com/qredo-external/go-rnov/pkg/service"
+)
+
+// RateLimiter - limits the requests of each client, told apart by the subject of its token or, when the request is
+// not authenticated, by its IP.
+type RateLimiter struct {
+	limiter *ratelimit.Limiter
+	log     logger.Logger
+}
+
+// NewRateLimiter - rate limiter constructor, lg nil discards the logs.
+func NewRateLimiter(lm *ratelimit.Limiter, lg logger.Logger) *RateLimiter {
+	if lg == nil {
+		lg = logger.Nop()
+	}
+	return &RateLimiter{
+		limiter: lm,
+		log:     lg,
+	}
+}
+
+// RateLimit - custom HTTP middleware that lets the requests of each client within l through to next, the others get a
+// 429 telling when to retry. name keeps the budgets of the routes apart. Responses carry the RateLimit headers of
+// draft-ietf-httpapi-ratelimit-headers. It goes inside Authentication so requests are limited by subject.
+func RateLimit(rl RateLimiter, name string, l ratelimit.Limit, next func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if l.Unlimited() {
+			next(w, r)
+			return
+		}
+		d, err := rl.limiter.Allow(r.Context(), name+":"+client(r), l)
+		if err != nil {
+			// note an unreachable store lets the requests through, the limits protect the service but must not take it down
+			logger.FromContext(r.Context(), rl.log).Warn("rate limit not applied", logger.F("error", err))
+			next(w, r)
+			return
+		}
+		h := w.Header()
+		h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%.0f", l.Requests, math.Ceil(l.Period.Seconds())))
+		h.Set("RateLimit-Limit", strconv.Itoa(l.Requests))
+		h.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+		h.Set("RateLimit-Reset", fmt.Sprintf("%.0f", d.Reset.Seconds()))
+		if !d.Allowed {
+			h.Set("Retry-After", fmt.Sprintf("%.0f", d.RetryAfter.Seconds()))
+			logger.FromContext(r.Context(), rl.log).Info("rate limited", logger.F("route", name), logger.F("limit", l.String()))
+			problem.Write(w, r, fmt.Errorf("%w: %s allowed, retry in %s", service.ErrRateLimited, l, d.RetryAfter))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// client - key of the client of the request, the subject of its token or its IP.
+func client(r *http.Request) string {
+	if c, ok := auth.FromContext(r.Context()); ok && c.Subject != "" {
+		return "sub:" + c.Subject
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
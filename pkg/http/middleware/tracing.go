@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/qredo-external/go-rnov/pkg/logger"
+	"github.com/qredo-external/go-rnov/pkg/trace"
+)
+
+// Tracing - HTTP middleware that starts a server span per request, continuing the trace of a valid W3C traceparent
+// header, so handlers, services, auth and storage spans nest under it. The request logger gets the trace id, so it
+// must run after Logging.
+func Tracing(tr *trace.Tracer) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := trace.NewContext(r.Context(), tr)
+			if sc, ok := trace.Extract(r.Header); ok {
+				ctx = trace.WithRemoteParent(ctx, sc)
+			}
+			tpl := route(r)
+			ctx, span := trace.Start(ctx, r.Method+" "+tpl, trace.KindServer,
+				trace.Attr("http.method", r.Method),
+				trace.Attr("http.route", tpl),
+			)
+			defer span.End()
+			ctx = logger.NewContext(ctx, logger.FromContext(ctx, logger.Nop()).With(
+				logger.F("trace_id", span.SpanContext().TraceID.String()),
+			))
+
+			sw := &statusWriter{ResponseWriter: w}
+			next.ServeHTTP(sw, r.WithContext(ctx))
+			span.SetAttributes(trace.Attr("http.status_code", sw.code()))
+			// note as per the OpenTelemetry conventions only server errors mark server spans as failed.
+			if sw.code() >= http.StatusInternalServerError {
+				span.RecordError(errorStatus(sw.code()))
+			}
+		})
+	}
+}
+
+// errorStatus - an HTTP status as an error.
+type errorStatus int
+
+func (e errorStatus) Error() string {
+	return http.StatusText(int(e))
+}
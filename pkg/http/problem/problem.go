@@ -0,0 +1,102 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	response "github.com/qredo-external/go-rnov/pkg/http/json"
+	"github.com/qredo-external/go-rnov/pkg/http/requestid"
+	"github.com/qredo-external/go-rnov/pkg/logger"
+	"github.com/qredo-external/go-rnov/pkg/service"
+)
+
+const (
+	// ContentType - media type of RFC 7807 problem details.
+	ContentType = "application/problem+json"
+	// typePrefix - problem types are relative URIs ending in the code of the error.
+	typePrefix = "/problems/"
+)
+
+// statuses - response status of each kind of error.
+var statuses = map[service.Kind]int{
+	service.KindInternal:       http.StatusInternalServerError,
+	service.KindInvalid:        http.StatusBadRequest,
+	service.KindUnauthorized:   http.StatusUnauthorized,
+	service.KindNotFound:       http.StatusNotFound,
+	service.KindConflict:       http.StatusConflict,
+	service.KindUnsupported:    http.StatusUnsupportedMediaType,
+	service.KindUnprocessable:  http.StatusUnprocessableEntity,
+	service.KindNotImplemented: http.StatusNotImplemented,
+	service.KindUnavailable:    http.StatusServiceUnavailable,
+	service.KindTimeout:        http.StatusGatewayTimeout,
+	service.KindExhausted:      http.StatusTooManyRequests,
+	service.KindTooLarge:       http.StatusRequestEntityTooLarge,
+}
+
+// oauthErrors - OAuth error code of the problem types that have one.
+var oauthErrors = map[string]string{
+	service.ErrDPoPNonce.Code: "use_dpop_nonce",
+}
+
+// Status - response status of err as classified by the service taxonomy.
+func Status(err error) int {
+	if status, ok := statuses[service.AsError(err).Kind]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// New - problem details of err, detail is the message of err unless it is internal, in which case it is not exposed.
+// Quota errors detail the quota exceeded.
+func New(r *http.Request, err error) *response.Problem {
+	e := service.AsError(err)
+	p := &response.Problem{
+		Type:      typePrefix + e.Code,
+		Title:     e.Message,
+		Status:    Status(err),
+		Instance:  r.URL.Path,
+		RequestID: requestid.FromContext(r.Context()),
+		Error:     oauthErrors[e.Code],
+	}
+	if e.Kind != service.KindInternal && err.Error() != e.Message {
+		p.Detail = err.Error()
+	}
+	var qe *service.QuotaError
+	if errors.As(err, &qe) {
+		p.Quota = &response.QuotaExceeded{
+			Resource: qe.Resource,
+			Limit:    qe.Limit,
+			Used:     qe.Used,
+			Period:   qe.Period,
+			Reset:    qe.Reset,
+		}
+	}
+	return p
+}
+
+// Write - writes err as an application/problem+json response. Server errors are logged with the whole error, since
+// their detail is not exposed, the rest at debug level. Quota errors tell when to retry with Retry-After.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	p := New(r, err)
+	lg := logger.FromContext(r.Context(), logger.Nop())
+	if p.Status >= http.StatusInternalServerError {
+		lg.Error("request failed", logger.F("type", p.Type), logger.F("error", err))
+	} else {
+		lg.Debug("request rejected", logger.F("type", p.Type), logger.F("error", err))
+	}
+	body, jsonErr := json.Marshal(p)
+	if jsonErr != nil {
+		w.WriteHeader(p.Status)
+		return
+	}
+	if p.Quota != nil {
+		w.Header().Set("Retry-After", fmt.Sprintf("%.0f", math.Max(0, math.Ceil(time.Until(p.Quota.Reset).Seconds()))))
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(p.Status)
+	_, _ = w.Write(body)
+}
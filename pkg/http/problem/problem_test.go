@@ -0,0 +1,126 @@
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	response "github.com/qredo-external/go-rnov/pkg/http/json"
+	"github.com/qredo-external/go-rnov/pkg/http/requestid"
+	"github.com/qredo-external/go-rnov/pkg/service"
+)
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected response.Problem
+	}{
+		{
+			name: "service error with detail",
+			err:  fmt.Errorf("%w: unexpected token at 1", service.ErrInvalidSelection),
+			expected: response.Problem{
+				Type:   "/problems/invalid-selection",
+				Title:  "invalid selection",
+				Status: 400,
+				Detail: "invalid selection: unexpected token at 1",
+			},
+		},
+		{
+			name: "service error",
+			err:  service.ErrJobNotFound,
+			expected: response.Problem{
+				Type:   "/problems/job-not-found",
+				Title:  "job not found",
+				Status: 404,
+			},
+		},
+		{
+			name: "oauth error",
+			err:  service.ErrDPoPNonce,
+			expected: response.Problem{
+				Type:   "/problems/use-dpop-nonce",
+				Title:  "dpop nonce required",
+				Status: 401,
+				Error:  "use_dpop_nonce",
+			},
+		},
+		{
+			name: "deadline",
+			err:  context.DeadlineExceeded,
+			expected: response.Problem{
+				Type:   "/problems/deadline-exceeded",
+				Title:  "operation deadline exceeded",
+				Status: 504,
+				Detail: "context deadline exceeded",
+			},
+		},
+		{
+			name: "internal error does not expose its detail",
+			err:  errors.New("connection refused"),
+			expected: response.Problem{
+				Type:   "/problems/internal",
+				Title:  "internal error",
+				Status: 500,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/sum", nil)
+			req = req.WithContext(requestid.NewContext(req.Context(), "aRequest"))
+			rr := httptest.NewRecorder()
+			Write(rr, req, test.err)
+
+			if rr.Code != test.expected.Status {
+				t.Errorf("wrong status code: expected %v got %v", test.expected.Status, rr.Code)
+			}
+			if rr.Header().Get("Content-Type") != ContentType {
+				t.Errorf("wrong content type: expected %s got %s", ContentType, rr.Header().Get("Content-Type"))
+			}
+			p := response.Problem{}
+			if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+				t.Fatal("unable to decode problem")
+			}
+			test.expected.Instance, test.expected.RequestID = "/sum", "aRequest"
+			if p != test.expected {
+				t.Errorf("expected problem %+v got %+v", test.expected, p)
+			}
+		})
+	}
+}
+
+func TestWrite_Quota(t *testing.T) {
+	reset := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	err := &service.QuotaError{Resource: service.QuotaOperations, Limit: 10, Used: 10, Period: service.QuotaDaily, Reset: reset}
+	rr := httptest.NewRecorder()
+	Write(rr, httptest.NewRequest("POST", "/sum", nil), err)
+
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("wrong status code: expected 429 got %v", rr.Code)
+	}
+	if retry := rr.Header().Get("Retry-After"); retry != "3600" {
+		t.Errorf("expected retry after the reset got %q", retry)
+	}
+	p := response.Problem{}
+	if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+		t.Fatal("unable to decode problem")
+	}
+	expected := response.QuotaExceeded{Resource: "operations", Limit: 10, Used: 10, Period: "day", Reset: reset}
+	if p.Type != "/problems/quota-exceeded" || p.Detail != "quota exceeded: 10 of 10 operations per day used" ||
+		p.Quota == nil || *p.Quota != expected {
+		t.Errorf("expected quota details %+v got %+v %+v", expected, p, p.Quota)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	if status := Status(service.NewError(service.KindUnsupported, "aCode", "a message")); status != http.StatusUnsupportedMediaType {
+		t.Errorf("expected status 415 got %d", status)
+	}
+}
@@ -0,0 +1,49 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Header - request and response header carrying the request id.
+const Header = "X-Request-ID"
+
+// maxLen - longest request id accepted from clients.
+const maxLen = 128
+
+type idKey struct{}
+
+// NewContext - returns a copy of ctx carrying the request id.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, idKey{}, id)
+}
+
+// FromContext - returns the request id stored in ctx by NewContext, empty if none.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(idKey{}).(string)
+	return id
+}
+
+// New - random 128 bit hex request id.
+func New() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// note crypto/rand does not fail on supported platforms, an empty id only loses correlation
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// Valid - whether a request id sent by a client can be reused: not empty, at most 128 printable ascii characters.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
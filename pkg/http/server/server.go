@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/qredo-external/go-rnov/pkg/logger"
+)
+
+// Config - timeouts of the HTTP server and of its graceful shutdown.
+type Config struct {
+	Addr string
+	// ReadHeaderTimeout - time to read the request headers, bounds slowloris like clients.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout - time from the start of the request to the end of its body, it must exceed the deadline of the
+	// slowest route reading a streamed body or its uploads are cut off.
+	ReadTimeout time.Duration
+	// WriteTimeout - time from the end of the request headers to the end of the response, it must exceed the
+	// deadline of the slowest route or its responses are cut off.
+	WriteTimeout time.Duration
+	// IdleTimeout - time a keep-alive connection waits for the next request.
+	IdleTimeout time.Duration
+	// DrainDelay - time readiness reports the service unavailable before it stops accepting connections, so load
+	// balancers stop routing to it first.
+	DrainDelay time.Duration
+	// ShutdownTimeout - time given to in-flight requests to finish, past it their connections are closed.
+	ShutdownTimeout time.Duration
+	// TLS - serves HTTPS with it when set, it must provide the certificate (e.g. tlsconfig.Reloader).
+	TLS *tls.Config
+}
+
+// Server - HTTP server that drains gracefully.
+type Server struct {
+	cfg      Config
+	srv      *http.Server
+	log      logger.Logger
+	draining func()
+}
+
+// New - server constructor, draining is called once shutdown starts (e.g. to flip readiness) and may be nil, lg nil
+// discards the logs.
+func New(cfg Config, h http.Handler, lg logger.Logger, draining func()) *Server {
+	if lg == nil {
+		lg = logger.Nop()
+	}
+	if draining == nil {
+		draining = func() {}
+	}
+	return &Server{
+		cfg: cfg,
+		srv: &http.Server{
+			Addr:              cfg.Addr,
+			Handler:           h,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			ReadTimeout:       cfg.ReadTimeout,
+			WriteTimeout:      cfg.WriteTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+			TLSConfig:         cfg.TLS,
+		},
+		log:      lg,
+		draining: draining,
+	}
+}
+
+// Run - listens on the configured address and serves until ctx is done, see Serve.
+func (s *Server) Run(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, ln)
+}
+
+// Serve - serves ln until ctx is done and then shuts down: draining is called, the server keeps serving for
+// DrainDelay, stops accepting connections and waits up to ShutdownTimeout for in-flight requests before closing the
+// remaining connections. It returns nil after a graceful shutdown.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	served := make(chan error, 1)
+	secure := s.srv.TLSConfig != nil
+	go func() {
+		if secure {
+			served <- s.srv.ServeTLS(ln, "", "")
+			return
+		}
+		served <- s.srv.Serve(ln)
+	}()
+	s.log.Info("server started", logger.F("addr", ln.Addr().String()), logger.F("tls", secure))
+
+	select {
+	case err := <-served:
+		// note the server failed on its own, nothing to drain
+		return err
+	case <-ctx.Done():
+	}
+
+	s.log.Info("server draining", logger.F("delay", s.cfg.DrainDelay))
+	s.draining()
+	time.Sleep(s.cfg.DrainDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+	err := s.srv.Shutdown(shutdownCtx)
+	if err != nil {
+		s.log.Warn("in-flight requests cut off", logger.F("error", err))
+		_ = s.srv.Close()
+	}
+	if serveErr := <-served; !errors.Is(serveErr, http.ErrServerClosed) {
+		return serveErr
+	}
+	s.log.Info("server stopped")
+	return err
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qredo-external/go-rnov/pkg/config"
+)
+
+// defaultConfig - the server settings main starts with by default.
+func defaultConfig() Config {
+	d := config.Default().Server
+	return Config{
+		Addr:              d.Addr,
+		ReadHeaderTimeout: d.ReadHeaderTimeout,
+		ReadTimeout:       d.ReadTimeout,
+		WriteTimeout:      d.WriteTimeout,
+		IdleTimeout:       d.IdleTimeout,
+		DrainDelay:        d.DrainDelay,
+		ShutdownTimeout:   d.ShutdownTimeout,
+	}
+}
+
+func TestServer_Serve(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		_, _ = w.Write([]byte("done"))
+	})
+	var draining int32
+	cfg := defaultConfig()
+	cfg.DrainDelay, cfg.ShutdownTimeout = time.Millisecond*10, time.Second*5
+	srv := New(cfg, h, nil, func() { atomic.StoreInt32(&draining, 1) })
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(ctx, ln)
+	}()
+
+	body := make(chan string, 1)
+	go func() {
+		res, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		b, _ := ioutil.ReadAll(res.Body)
+		body <- string(b)
+	}()
+	<-started
+	cancel()
+	for atomic.LoadInt32(&draining) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	if b := <-body; b != "done" {
+		t.Errorf("expected in-flight request to complete got %s", b)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("expected graceful shutdown got %v", err)
+	}
+	if _, err := http.Get("http://" + ln.Addr().String()); err == nil {
+		t.Errorf("expected no new connections after shutdown")
+	}
+}
+
+func TestServer_ShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	})
+	cfg := defaultConfig()
+	cfg.DrainDelay, cfg.ShutdownTimeout = 0, time.Millisecond*10
+	srv := New(cfg, h, nil, nil)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(ctx, ln)
+	}()
+	go func() {
+		_, _ = http.Get("http://" + ln.Addr().String())
+	}()
+	<-started
+	cancel()
+	if err := <-served; err != context.DeadlineExceeded {
+		t.Errorf("expected in-flight request cut off got %v", err)
+	}
+}
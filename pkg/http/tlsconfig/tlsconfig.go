@@ -0,0 +1,206 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qredo-external/go-rnov/pkg/logger"
+)
+
+var (
+	// ErrInvalidOptions - the TLS options can not be used.
+	ErrInvalidOptions = errors.New("invalid tls options")
+
+	versions = map[string]uint16{"1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13}
+
+	clientAuths = map[string]tls.ClientAuthType{
+		"none":            tls.NoClientCert,
+		"request":         tls.RequestClientCert,
+		"verify_if_given": tls.VerifyClientCertIfGiven,
+		"require":         tls.RequireAndVerifyClientCert,
+	}
+)
+
+// Options - files and policy of the TLS server.
+type Options struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile - PEM bundle client certificates are verified against.
+	ClientCAFile string
+	ClientAuth   tls.ClientAuthType
+	MinVersion   uint16
+	// CipherSuites - suites of TLS 1.2 connections, Go defaults when empty. TLS 1.3 suites are not configurable.
+	CipherSuites []uint16
+}
+
+// ParseVersion - TLS version of `1.2` or `1.3`, older versions are not supported.
+func ParseVersion(v string) (uint16, error) {
+	if version, ok := versions[v]; ok {
+		return version, nil
+	}
+	return 0, fmt.Errorf("%w: unsupported version %q, use 1.2 or 1.3", ErrInvalidOptions, v)
+}
+
+// ParseClientAuth - client certificate policy of `none`, `request`, `verify_if_given` or `require`.
+func ParseClientAuth(v string) (tls.ClientAuthType, error) {
+	if ca, ok := clientAuths[v]; ok {
+		return ca, nil
+	}
+	return 0, fmt.Errorf("%w: unknown client auth %q", ErrInvalidOptions, v)
+}
+
+// ParseCipherSuites - ids of the comma separated suite names (e.g. TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256), only
+// suites without known security issues are accepted.
+func ParseCipherSuites(names string) ([]uint16, error) {
+	if strings.TrimSpace(names) == "" {
+		return nil, nil
+	}
+	byName := make(map[string]uint16)
+	for _, cs := range tls.CipherSuites() {
+		byName[cs.Name] = cs.ID
+	}
+	var ids []uint16
+	for _, name := range strings.Split(names, ",") {
+		id, ok := byName[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown or insecure cipher suite %q", ErrInvalidOptions, name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Reloader - serves the certificate and client CAs last loaded from the files, so they can be replaced without a
+// restart. Connections already established keep the certificate they were made with.
+type Reloader struct {
+	opts Options
+	log  logger.Logger
+
+	mu      sync.RWMutex
+	current *tls.Config
+	modTime time.Time
+}
+
+// NewReloader - loads the files once, they must be valid, lg nil discards the logs.
+func NewReloader(opts Options, lg logger.Logger) (*Reloader, error) {
+	if lg == nil {
+		lg = logger.Nop()
+	}
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, fmt.Errorf("%w: certificate and key files are required", ErrInvalidOptions)
+	}
+	if opts.ClientAuth >= tls.VerifyClientCertIfGiven && opts.ClientCAFile == "" {
+		return nil, fmt.Errorf("%w: verifying client certificates requires a client CA file", ErrInvalidOptions)
+	}
+	r := &Reloader{opts: opts, log: lg}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig - configuration of the server, each handshake uses the files last loaded.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: r.opts.MinVersion,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &r.config().Certificates[0], nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.config(), nil
+		},
+	}
+}
+
+func (r *Reloader) config() *tls.Config {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current
+}
+
+// Reload - loads the files again, the previous certificate and CAs are kept if they are not valid.
+func (r *Reloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.opts.CertFile, r.opts.KeyFile)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidOptions, err.Error())
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   r.opts.MinVersion,
+		CipherSuites: r.opts.CipherSuites,
+		ClientAuth:   r.opts.ClientAuth,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if r.opts.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(r.opts.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidOptions, err.Error())
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%w: no certificate in %s", ErrInvalidOptions, r.opts.ClientCAFile)
+		}
+	}
+	modTime := r.lastModified()
+
+	r.mu.Lock()
+	r.current, r.modTime = cfg, modTime
+	r.mu.Unlock()
+	if cert.Leaf == nil {
+		cert.Leaf, _ = x509.ParseCertificate(cert.Certificate[0])
+	}
+	if cert.Leaf != nil {
+		r.log.Info("tls certificate loaded", logger.F("subject", cert.Leaf.Subject.String()),
+			logger.F("not_after", cert.Leaf.NotAfter.UTC().Format(time.RFC3339)))
+	}
+	return nil
+}
+
+// Watch - reloads the files whenever one of them changes, checking every interval until ctx is done. A failed reload
+// is logged and retried at the next change.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		r.mu.RLock()
+		changed := !r.lastModified().Equal(r.modTime)
+		r.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := r.Reload(); err != nil {
+			r.log.Error("tls reload failed, keeping the previous certificate", logger.F("error", err))
+			// note the change is acknowledged so a broken file is not reloaded on every tick
+			r.mu.Lock()
+			r.modTime = r.lastModified()
+			r.mu.Unlock()
+		}
+	}
+}
+
+// lastModified - latest modification time of the files, symlinks are followed so replaced mounted secrets count.
+func (r *Reloader) lastModified() time.Time {
+	var latest time.Time
+	for _, name := range []string{r.opts.CertFile, r.opts.KeyFile, r.opts.ClientCAFile} {
+		if name == "" {
+			continue
+		}
+		if fi, err := os.Stat(name); err == nil && fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest
+}
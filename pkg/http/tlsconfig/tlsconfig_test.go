@@ -0,0 +1,275 @@
+package tlsconfig
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type issuer struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newIssuer(t *testing.T) *issuer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &issuer{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue - PEM certificate and key of cn, usable by a server for localhost or by a client.
+func (i *issuer) issue(t *testing.T, cn string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, i.cert, &key.PublicKey, i.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, name string, content []byte) {
+	if err := ioutil.WriteFile(name, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serve - HTTPS server of the reloader until the test ends.
+func serve(t *testing.T, r *Reloader) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	go func() {
+		_ = srv.Serve(tls.NewListener(ln, r.TLSConfig()))
+	}()
+	t.Cleanup(func() { _ = srv.Close() })
+	return ln.Addr().String()
+}
+
+// request - sends a request over conn, the handshake happens then if it has not yet.
+func request(conn *tls.Conn) error {
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")); err != nil {
+		return err
+	}
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+// dial - common name of the certificate served at addr.
+func dial(t *testing.T, addr string, cfg *tls.Config) (*tls.Conn, string) {
+	conn, err := tls.Dial("tcp", addr, cfg)
+	if err != nil {
+		t.Fatalf("non-nil error : %s", err.Error())
+	}
+	if err := request(conn); err != nil {
+		t.Fatalf("non-nil error : %s", err.Error())
+	}
+	return conn, conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+func TestReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := newIssuer(t)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	cert, key := ca.issue(t, "first", x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, cert)
+	writeFile(t, keyFile, key)
+
+	r, err := NewReloader(Options{CertFile: certFile, KeyFile: keyFile, MinVersion: tls.VersionTLS12}, nil)
+	if err != nil {
+		t.Fatalf("non-nil error : %s", err.Error())
+	}
+	addr := serve(t, r)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+
+	conn, cn := dial(t, addr, client)
+	defer conn.Close()
+	if cn != "first" {
+		t.Fatalf("expected first certificate got %s", cn)
+	}
+
+	cert, key = ca.issue(t, "second", x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, cert)
+	writeFile(t, keyFile, key)
+	if err := r.Reload(); err != nil {
+		t.Fatalf("non-nil error : %s", err.Error())
+	}
+	if c, cn := dial(t, addr, client); cn != "second" {
+		t.Errorf("expected reloaded certificate got %s", cn)
+	} else {
+		c.Close()
+	}
+	if err := request(conn); err != nil {
+		t.Errorf("expected established connection to be kept got %v", err)
+	}
+
+	writeFile(t, keyFile, []byte("broken"))
+	if err := r.Reload(); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("expected invalid options got %v", err)
+	}
+	if c, cn := dial(t, addr, client); cn != "second" {
+		t.Errorf("expected previous certificate to be kept got %s", cn)
+	} else {
+		c.Close()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, time.Millisecond*10)
+	cert, key = ca.issue(t, "third", x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, cert)
+	writeFile(t, keyFile, key)
+	// note the files are dated in the future so the change is seen whatever the resolution of the file system
+	later := time.Now().Add(time.Minute)
+	_ = os.Chtimes(certFile, later, later)
+	for deadline := time.Now().Add(time.Second * 5); time.Now().Before(deadline); time.Sleep(time.Millisecond * 10) {
+		c, cn := dial(t, addr, client)
+		c.Close()
+		if cn == "third" {
+			return
+		}
+	}
+	t.Errorf("expected changed files to be reloaded")
+}
+
+func TestReloader_ClientAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := newIssuer(t)
+	certFile, keyFile, caFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem")
+	cert, key := ca.issue(t, "server", x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, cert)
+	writeFile(t, keyFile, key)
+	writeFile(t, caFile, ca.pem)
+
+	opts := Options{CertFile: certFile, KeyFile: keyFile, ClientAuth: tls.RequireAndVerifyClientCert}
+	if _, err := NewReloader(opts, nil); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("expected client CA to be required got %v", err)
+	}
+	opts.ClientCAFile = caFile
+	r, err := NewReloader(opts, nil)
+	if err != nil {
+		t.Fatalf("non-nil error : %s", err.Error())
+	}
+	addr := serve(t, r)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	clientCert, clientKey := ca.issue(t, "client", x509.ExtKeyUsageClientAuth)
+	pair, err := tls.X509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := newIssuer(t)
+	otherCert, otherKey := other.issue(t, "intruder", x509.ExtKeyUsageClientAuth)
+	otherPair, err := tls.X509KeyPair(otherCert, otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		certs  []tls.Certificate
+		accept bool
+	}{
+		{name: "no client certificate"},
+		{name: "unknown issuer", certs: []tls.Certificate{otherPair}},
+		{name: "trusted client certificate", certs: []tls.Certificate{pair}, accept: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: roots, ServerName: "localhost", Certificates: test.certs})
+			if err == nil {
+				err = request(conn)
+				conn.Close()
+			}
+			if (err == nil) != test.accept {
+				t.Errorf("expected accepted %t got %v", test.accept, err)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	if v, err := ParseVersion("1.3"); err != nil || v != tls.VersionTLS13 {
+		t.Errorf("expected TLS 1.3 got %x %v", v, err)
+	}
+	if _, err := ParseVersion("1.0"); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("expected invalid options got %v", err)
+	}
+	if ca, err := ParseClientAuth("verify_if_given"); err != nil || ca != tls.VerifyClientCertIfGiven {
+		t.Errorf("expected verify if given got %v %v", ca, err)
+	}
+	if _, err := ParseClientAuth("always"); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("expected invalid options got %v", err)
+	}
+	ids, err := ParseCipherSuites("TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256")
+	if err != nil || len(ids) != 2 || ids[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("expected two suites got %v %v", ids, err)
+	}
+	if _, err := ParseCipherSuites("TLS_RSA_WITH_RC4_128_SHA"); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("expected insecure suite to be refused got %v", err)
+	}
+}
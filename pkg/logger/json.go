@@ -0,0 +1,86 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// JSON - logger writing every entry as a JSON object in its own line, with time, level, msg and then the fields in
+// the order they were added. Values of sensitive fields are redacted.
+type JSON struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	level  Level
+	fields []Field
+	now    func() time.Time
+}
+
+// NewJSON - JSON logger constructor, entries below level are discarded.
+func NewJSON(w io.Writer, level Level) *JSON {
+	return &JSON{
+		mu:    new(sync.Mutex),
+		w:     w,
+		level: level,
+		now:   time.Now,
+	}
+}
+
+func (l *JSON) Debug(msg string, fields ...Field) { l.log(LevelDebug, msg, fields) }
+func (l *JSON) Info(msg string, fields ...Field)  { l.log(LevelInfo, msg, fields) }
+func (l *JSON) Warn(msg string, fields ...Field)  { l.log(LevelWarn, msg, fields) }
+func (l *JSON) Error(msg string, fields ...Field) { l.log(LevelError, msg, fields) }
+
+// With - the returned logger shares the writer and level.
+func (l *JSON) With(fields ...Field) Logger {
+	child := *l
+	child.fields = append(append(make([]Field, 0, len(l.fields)+len(fields)), l.fields...), fields...)
+	return &child
+}
+
+func (l *JSON) log(level Level, msg string, fields []Field) {
+	if level < l.level {
+		return
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	writeField(buf, "time", l.now().UTC().Format(time.RFC3339Nano))
+	buf.WriteByte(',')
+	writeField(buf, "level", level.String())
+	buf.WriteByte(',')
+	writeField(buf, "msg", msg)
+	for _, fs := range [][]Field{l.fields, fields} {
+		for _, f := range fs {
+			buf.WriteByte(',')
+			writeField(buf, f.Key, Redact(f.Key, f.Value))
+		}
+	}
+	buf.WriteString("}\n")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// note there is nowhere else to report a failure to write a log entry
+	_, _ = l.w.Write(buf.Bytes())
+}
+
+// writeField - writes "key":value, errors are written as their message, durations as strings and values that can
+// not be encoded as json as their fmt representation.
+func writeField(buf *bytes.Buffer, key string, value interface{}) {
+	k, _ := json.Marshal(key)
+	buf.Write(k)
+	buf.WriteByte(':')
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case time.Duration:
+		value = v.String()
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buf.Write(b)
+}
@@ -0,0 +1,107 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Level - severity of a log entry, entries below the level of a logger are discarded.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// ParseLevel - level by its name, case insensitive.
+func ParseLevel(name string) (Level, error) {
+	for l, n := range levelNames {
+		if strings.EqualFold(n, name) {
+			return l, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", name)
+}
+
+// Field - key value pair attached to a log entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F - shorthand to build a Field.
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Logger - structured logger injected in the services and adapters.
+type Logger interface {
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	Warn(msg string, fields ...Field)
+	Error(msg string, fields ...Field)
+	// With - logger adding fields to every entry.
+	With(fields ...Field) Logger
+}
+
+// Redacted - replaces the value of sensitive fields.
+const Redacted = "[REDACTED]"
+
+// sensitive - fields whose key contains any of these are never written as they are.
+var sensitive = []string{"password", "token", "jwt", "secret", "authorization", "receipt", "dpop", "cookie"}
+
+// Redact - value to log for the field key, Redacted if the key names a secret.
+func Redact(key string, value interface{}) interface{} {
+	k := strings.ToLower(key)
+	for _, s := range sensitive {
+		if strings.Contains(k, s) {
+			return Redacted
+		}
+	}
+	return value
+}
+
+type nop struct{}
+
+// Nop - logger discarding every entry.
+func Nop() Logger {
+	return nop{}
+}
+
+func (nop) Debug(string, ...Field) {}
+func (nop) Info(string, ...Field)  {}
+func (nop) Warn(string, ...Field)  {}
+func (nop) Error(string, ...Field) {}
+func (n nop) With(...Field) Logger { return n }
+
+type loggerKey struct{}
+
+// NewContext - returns a copy of ctx carrying the logger, usually one with the fields of a request.
+func NewContext(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext - the logger stored in ctx by NewContext, fallback if none.
+func FromContext(ctx context.Context, fallback Logger) Logger {
+	if l, ok := ctx.Value(loggerKey{}).(Logger); ok && l != nil {
+		return l
+	}
+	return fallback
+}
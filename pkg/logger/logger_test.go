@@ -0,0 +1,44 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewJSON(buf, LevelInfo)
+	l.now = func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	l.Debug("discarded")
+	l.With(F("request_id", "aRequest")).Info("request served",
+		F("status", 200), F("jwt", "aToken"), F("Password", "aPassword"), F("error", errors.New("boom")), F("latency", time.Second))
+	l.Error("failed")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 entries got %d: %s", len(lines), buf.String())
+	}
+	expected := `{"time":"2020-01-02T03:04:05Z","level":"info","msg":"request served","request_id":"aRequest","status":200,` +
+		`"jwt":"[REDACTED]","Password":"[REDACTED]","error":"boom","latency":"1s"}`
+	if lines[0] != expected {
+		t.Errorf("expected entry %s got %s", expected, lines[0])
+	}
+	entry := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(lines[1]), &entry); err != nil || entry["level"] != "error" {
+		t.Errorf("expected error entry got %s %v", lines[1], err)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if l, err := ParseLevel("WARN"); err != nil || l != LevelWarn {
+		t.Errorf("expected warn got %s %v", l, err)
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("expected unknown level error")
+	}
+}
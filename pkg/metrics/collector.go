@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+)
+
+// SizeBuckets - upper bounds, in bytes, of the document size histogram.
+var SizeBuckets = []float64{64, 256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304}
+
+// Collector - adapter measuring the service: it implements service.Metrics and middleware.RequestObserver without
+// those packages depending on this one.
+type Collector struct {
+	requests    *CounterVec
+	latency     *HistogramVec
+	issued      *CounterVec
+	validated   *CounterVec
+	rejected    *CounterVec
+	sumSize     *HistogramVec
+	sumDuration *HistogramVec
+}
+
+// NewCollector - registers the metrics of the service in reg.
+func NewCollector(reg *Registry) *Collector {
+	return &Collector{
+		requests: reg.Counter("http_requests_total",
+			"HTTP requests served by route and status.", "method", "route", "status"),
+		latency: reg.Histogram("http_request_duration_seconds",
+			"Latency of the HTTP requests by route and status.", DefaultBuckets, "method", "route", "status"),
+		issued: reg.Counter("auth_tokens_issued_total",
+			"Tokens handed out by /auth."),
+		validated: reg.Counter("auth_tokens_validated_total",
+			"Tokens accepted to access a protected route."),
+		rejected: reg.Counter("auth_tokens_rejected_total",
+			"Tokens refused by reason.", "reason"),
+		sumSize: reg.Histogram("sum_document_size_bytes",
+			"Size of the documents summed.", SizeBuckets),
+		sumDuration: reg.Histogram("sum_duration_seconds",
+			"Time taken to sum a document, by whether it came from the result cache.", DefaultBuckets, "cached"),
+	}
+}
+
+// RequestServed - counts the request and observes its latency.
+func (c *Collector) RequestServed(method, route string, status int, d time.Duration) {
+	code := strconv.Itoa(status)
+	c.requests.Inc(method, route, code)
+	c.latency.Observe(d.Seconds(), method, route, code)
+}
+
+// TokenIssued - counts a token handed out.
+func (c *Collector) TokenIssued() {
+	c.issued.Inc()
+}
+
+// TokenValidated - counts a token accepted.
+func (c *Collector) TokenValidated() {
+	c.validated.Inc()
+}
+
+// TokenRejected - counts a token refused for reason.
+func (c *Collector) TokenRejected(reason string) {
+	c.rejected.Inc(reason)
+}
+
+// SumComputed - observes the size of the document and the time taken to sum it.
+func (c *Collector) SumComputed(size int, d time.Duration, cached bool) {
+	c.sumSize.Observe(float64(size))
+	c.sumDuration.Observe(d.Seconds(), strconv.FormatBool(cached))
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.Counter("requests_total", "Requests served.", "route", "status")
+	h := reg.Histogram("latency_seconds", "Latency.", []float64{.1, 1}, "route")
+	reg.GaugeFunc("tokens", "Tokens\nstored.", func() float64 { return 3 })
+
+	c.Inc("/sum", "200")
+	c.Add(2, "/sum", "200")
+	c.Inc(`/a"b\`, "500")
+	h.Observe(.05, "/sum")
+	h.Observe(.5, "/sum")
+	h.Observe(5, "/sum")
+
+	expected := `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{route="/a\"b\\",status="500"} 1
+requests_total{route="/sum",status="200"} 3
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/sum",le="0.1"} 1
+latency_seconds_bucket{route="/sum",le="1"} 2
+latency_seconds_bucket{route="/sum",le="+Inf"} 3
+latency_seconds_sum{route="/sum"} 5.55
+latency_seconds_count{route="/sum"} 3
+# HELP tokens Tokens\nstored.
+# TYPE tokens gauge
+tokens 3
+`
+	buf := &bytes.Buffer{}
+	if err := reg.Write(buf); err != nil {
+		t.Fatalf("non-nil error : %s", err.Error())
+	}
+	if buf.String() != expected {
+		t.Errorf("error in exposition: expected\n%s\ngot\n%s", expected, buf.String())
+	}
+}
+
+func TestCollector(t *testing.T) {
+	reg := NewRegistry()
+	c := NewCollector(reg)
+	c.RequestServed("POST", "/sum", 401, time.Millisecond)
+	c.TokenIssued()
+	c.TokenValidated()
+	c.TokenRejected("token-expired")
+	c.SumComputed(100, time.Millisecond, true)
+
+	rr := httptest.NewRecorder()
+	reg.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rr.Header().Get("Content-Type") != ContentType {
+		t.Errorf("error in content type: expected %s got %s", ContentType, rr.Header().Get("Content-Type"))
+	}
+	for _, line := range []string{
+		`http_requests_total{method="POST",route="/sum",status="401"} 1`,
+		`http_request_duration_seconds_count{method="POST",route="/sum",status="401"} 1`,
+		`auth_tokens_issued_total 1`,
+		`auth_tokens_validated_total 1`,
+		`auth_tokens_rejected_total{reason="token-expired"} 1`,
+		`sum_document_size_bytes_bucket{le="256"} 1`,
+		`sum_duration_seconds_count{cached="true"} 1`,
+	} {
+		if !bytes.Contains(rr.Body.Bytes(), []byte(line+"\n")) {
+			t.Errorf("expected %q in\n%s", line, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidLimit - the limit is not of the form `<requests>/<period>`.
+var ErrInvalidLimit = errors.New("invalid rate limit")
+
+// Limit - a token bucket of Requests tokens refilled over Period, so a client can burst Requests requests and then
+// make them at Requests per Period. The zero Limit does not limit.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// ParseLimit - a limit of the form `<requests>/<period>`, e.g. `60/1m` or `10/s`, `off` or empty for no limit.
+func ParseLimit(v string) (Limit, error) {
+	if v == "" || v == "off" {
+		return Limit{}, nil
+	}
+	i := strings.Index(v, "/")
+	if i < 0 {
+		return Limit{}, fmt.Errorf("%w: %q is not <requests>/<period>", ErrInvalidLimit, v)
+	}
+	requests, err := strconv.Atoi(v[:i])
+	if err != nil || requests <= 0 {
+		return Limit{}, fmt.Errorf("%w: %q requests must be positive", ErrInvalidLimit, v)
+	}
+	period := v[i+1:]
+	// note a bare unit, as in 10/s, stands for one of it
+	if period != "" && (period[0] < '0' || period[0] > '9') {
+		period = "1" + period
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("%w: %q period must be a positive duration", ErrInvalidLimit, v)
+	}
+	return Limit{Requests: requests, Period: d}, nil
+}
+
+func (l Limit) String() string {
+	if l.Unlimited() {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", l.Requests, l.Period)
+}
+
+// Unlimited - whether the limit lets every request through.
+func (l Limit) Unlimited() bool {
+	return l.Requests <= 0 || l.Period <= 0
+}
+
+// rate - tokens added per second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// Store - keeps the token buckets. Take must be atomic per key, so replicas sharing a store share the limits.
+type Store interface {
+	// Take - takes a token from the bucket of key, created full, refilled as of now. It returns the tokens left and
+	// whether there was one to take.
+	Take(ctx context.Context, key string, l Limit, now time.Time) (float64, bool, error)
+}
+
+// Decision - whether a request is allowed along with the state of its bucket. Reset is the time until the bucket is
+// full again and RetryAfter, when not allowed, until it has a token.
+type Decision struct {
+	Allowed    bool
+	Limit      Limit
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// Limiter - decides which requests are allowed by the token buckets of store.
+type Limiter struct {
+	store Store
+	now   func() time.Time
+}
+
+// NewLimiter - limiter constructor.
+func NewLimiter(store Store) *Limiter {
+	return &Limiter{
+		store: store,
+		now:   time.Now,
+	}
+}
+
+// Allow - takes a token from the bucket of key for l, a request is always allowed by an unlimited l.
+func (lm *Limiter) Allow(ctx context.Context, key string, l Limit) (Decision, error) {
+	if l.Unlimited() {
+		return Decision{Allowed: true, Limit: l}, nil
+	}
+	tokens, allowed, err := lm.store.Take(ctx, key, l, lm.now())
+	if err != nil {
+		return Decision{}, err
+	}
+	d := Decision{
+		Allowed:   allowed,
+		Limit:     l,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     seconds((float64(l.Requests) - tokens) / l.rate()),
+	}
+	if !allowed {
+		d.RetryAfter = seconds((1 - tokens) / l.rate())
+	}
+	return d, nil
+}
+
+// seconds - s rounded up to the second, as clients are told in whole seconds.
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s)) * time.Second
+}
+
+// Memory - is a virtual memory store, buckets that refilled are pruned as new ones are taken from.
+type Memory struct {
+	*sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   Limit
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		Mutex:   new(sync.Mutex),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Take - takes a token from the bucket of key, created full, refilled as of now. It returns the tokens left and
+// whether there was one to take.
+func (m *Memory) Take(ctx context.Context, key string, l Limit, now time.Time) (float64, bool, error) {
+	m.Lock()
+	defer m.Unlock()
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.Requests), updated: now}
+		m.buckets[key] = b
+	}
+	b.limit = l
+	b.refill(now)
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	// note pruning walks every bucket, once a second bounds its cost whatever the rate of requests
+	if now.Sub(m.lastPrune) >= time.Second {
+		for k, b := range m.buckets {
+			if b.refill(now); b.tokens >= float64(b.limit.Requests) {
+				delete(m.buckets, k)
+			}
+		}
+		m.lastPrune = now
+	}
+	return b.tokens, allowed, nil
+}
+
+// Len - number of buckets, full ones included until they are pruned.
+func (m *Memory) Len() int {
+	m.Lock()
+	defer m.Unlock()
+	return len(m.buckets)
+}
+
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Requests), b.tokens+elapsed.Seconds()*b.limit.rate())
+		b.updated = now
+	}
+}
@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected Limit
+		invalid  bool
+	}{
+		{value: "60/1m", expected: Limit{Requests: 60, Period: time.Minute}},
+		{value: "10/s", expected: Limit{Requests: 10, Period: time.Second}},
+		{value: "5/1m0s", expected: Limit{Requests: 5, Period: time.Minute}},
+		{value: "off"},
+		{value: ""},
+		{value: "60", invalid: true},
+		{value: "0/1m", invalid: true},
+		{value: "ten/1m", invalid: true},
+		{value: "10/soon", invalid: true},
+		{value: "10/-1m", invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			l, err := ParseLimit(test.value)
+			if test.invalid != errors.Is(err, ErrInvalidLimit) {
+				t.Fatalf("expected invalid %t got %v", test.invalid, err)
+			}
+			if l != test.expected {
+				t.Errorf("expected %v got %v", test.expected, l)
+			}
+			if parsed, _ := ParseLimit(l.String()); parsed != l {
+				t.Errorf("expected %s to parse back got %v", l, parsed)
+			}
+		})
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	store := NewMemory()
+	lm := NewLimiter(store)
+	lm.now = func() time.Time { return now }
+	l := Limit{Requests: 2, Period: time.Minute}
+	ctx := context.Background()
+
+	expected := []Decision{
+		{Allowed: true, Limit: l, Remaining: 1, Reset: time.Second * 30},
+		{Allowed: true, Limit: l, Remaining: 0, Reset: time.Minute},
+		{Allowed: false, Limit: l, Remaining: 0, Reset: time.Minute, RetryAfter: time.Second * 30},
+	}
+	for i, e := range expected {
+		if d, err := lm.Allow(ctx, "a", l); err != nil || d != e {
+			t.Errorf("request %d expected %+v got %+v %v", i+1, e, d, err)
+		}
+	}
+	if d, _ := lm.Allow(ctx, "b", l); !d.Allowed {
+		t.Error("expected another key to have its own bucket")
+	}
+
+	now = now.Add(time.Second * 30)
+	if d, _ := lm.Allow(ctx, "a", l); !d.Allowed || d.Remaining != 0 {
+		t.Errorf("expected a token refilled after 30s got %+v", d)
+	}
+	now = now.Add(time.Minute * 5)
+	if d, _ := lm.Allow(ctx, "a", l); !d.Allowed || d.Remaining != 1 {
+		t.Errorf("expected the bucket to refill up to the limit got %+v", d)
+	}
+	if store.Len() != 1 {
+		t.Errorf("expected refilled buckets to be pruned, got %d buckets", store.Len())
+	}
+	if d, err := lm.Allow(ctx, "a", Limit{}); err != nil || !d.Allowed {
+		t.Errorf("expected no limit to allow got %+v %v", d, err)
+	}
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+var (
+	// ErrUnknownOperation - the requested aggregation is not supported.
+	ErrUnknownOperation = NewError(KindNotFound, "unknown-operation", "unknown operation")
+	// ErrNoNumbers - the aggregation is undefined for a document without numbers (min, max, mean).
+	ErrNoNumbers = NewError(KindUnprocessable, "no-numbers", "document contains no numbers")
+	// ErrOutOfRange - the aggregation result can not be represented as a finite float64.
+	ErrOutOfRange = NewError(KindUnprocessable, "out-of-range", "result out of range")
+)
+
+// Aggregation - result of an aggregation over the numbers of a document, Value is a float64 except for
+// histograms where it is a map of bucket to count.
+type Aggregation struct {
+	Operation string
+	Value     interface{}
+	Hash      string
+}
+
+// aggregator - accumulates numbers found by walk and produces the aggregation value.
+type aggregator interface {
+	add(n float64)
+	result() (interface{}, error)
+}
+
+// aggregators - supported aggregations by name.
+var aggregators = map[string]func() aggregator{
+	"count":     func() aggregator { return &count{} },
+	"min":       func() aggregator { return &extreme{less: func(a, b float64) bool { return a < b }} },
+	"max":       func() aggregator { return &extreme{less: func(a, b float64) bool { return a > b }} },
+	"mean":      func() aggregator { return &mean{} },
+	"product":   func() aggregator { return &product{value: 1} },
+	"histogram": func() aggregator { return &histogram{buckets: make(map[string]int)} },
+}
+
+// Aggregate - applies the named operation to every number selected throughout a valid (json) document and hashes
+// the value.
+func (om OperationManager) Aggregate(ctx context.Context, op string, data interface{}, opts Options) (*Aggregation, error) {
+	newAgg, ok := aggregators[op]
+	if !ok {
+		return nil, ErrUnknownOperation
+	}
+	agg := newAgg()
+	if err := walkSelection(ctx, data, opts, agg.add); err != nil {
+		return nil, err
+	}
+	value, err := agg.result()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := hashValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return &Aggregation{
+		Operation: op,
+		Value:     value,
+		Hash:      hash,
+	}, nil
+}
+
+// hashValue - SHA256 of the textual representation of the value, numbers use the shortest decimal form so
+// integers hash exactly like Sum results, histograms hash their json encoding (sorted keys).
+func hashValue(value interface{}) (string, error) {
+	var raw []byte
+	switch v := value.(type) {
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return "", ErrOutOfRange
+		}
+		raw = []byte(strconv.FormatFloat(v, 'f', -1, 64))
+	default:
+		var err error
+		if raw, err = json.Marshal(v); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(raw)), nil
+}
+
+type count struct {
+	n int
+}
+
+func (c *count) add(float64) { c.n++ }
+
+func (c *count) result() (interface{}, error) { return float64(c.n), nil }
+
+// extreme - min or max depending on less.
+type extreme struct {
+	less  func(a, b float64) bool
+	value float64
+	seen  bool
+}
+
+func (e *extreme) add(n float64) {
+	if !e.seen || e.less(n, e.value) {
+		e.value, e.seen = n, true
+	}
+}
+
+func (e *extreme) result() (interface{}, error) {
+	if !e.seen {
+		return nil, ErrNoNumbers
+	}
+	return e.value, nil
+}
+
+type mean struct {
+	sum float64
+	n   int
+}
+
+func (m *mean) add(n float64) {
+	m.sum += n
+	m.n++
+}
+
+func (m *mean) result() (interface{}, error) {
+	if m.n == 0 {
+		return nil, ErrNoNumbers
+	}
+	return m.sum / float64(m.n), nil
+}
+
+type product struct {
+	value float64
+}
+
+func (p *product) add(n float64) { p.value *= n }
+
+func (p *product) result() (interface{}, error) {
+	if math.IsInf(p.value, 0) || math.IsNaN(p.value) {
+		return nil, ErrOutOfRange
+	}
+	return p.value, nil
+}
+
+// histogram - counts numbers per unit wide bucket, keyed by the floor of the number.
+type histogram struct {
+	buckets map[string]int
+}
+
+func (h *histogram) add(n float64) {
+	h.buckets[strconv.FormatFloat(math.Floor(n), 'f', -1, 64)]++
+}
+
+func (h *histogram) result() (interface{}, error) { return h.buckets, nil }
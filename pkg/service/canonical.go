@@ -0,0 +1,143 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"unicode/utf16"
+)
+
+// canonicalDigest - hex encoded SHA256 of the RFC 8785 (JCS) canonical form of the document, so documents that only
+// differ in formatting, member order or number notation share the digest. It stops once ctx is done.
+func canonicalDigest(ctx context.Context, data interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := canonicalize(ctx, &buf, data); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(buf.Bytes())), nil
+}
+
+// canonicalize - writes the RFC 8785 serialization of a value of the encoding/json value model, it stops with the
+// context error once ctx is done.
+func canonicalize(ctx context.Context, buf *bytes.Buffer, data interface{}) error {
+	c := &canonicalizer{ctx: ctx, buf: buf}
+	return c.write(data)
+}
+
+// canonicalizer - writes canonical forms to buf checking ctx every checkEvery values.
+type canonicalizer struct {
+	ctx   context.Context
+	buf   *bytes.Buffer
+	steps int
+}
+
+func (c *canonicalizer) write(data interface{}) error {
+	if c.steps++; c.steps%checkEvery == 0 {
+		if err := c.ctx.Err(); err != nil {
+			return err
+		}
+	}
+	buf := c.buf
+	switch v := data.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		if v {
+			buf.WriteString("true")
+		} else {
+			buf.WriteString("false")
+		}
+	case float64:
+		// note encoding/json formats float64 as ECMAScript Number.prototype.toString does, as required by JCS, and
+		// fails for non finite numbers which JCS does not allow either.
+		if v == 0 {
+			// note -0 is serialized as 0
+			v = 0
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+	case string:
+		canonicalString(buf, v)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := c.write(e); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		// note members are sorted by the UTF-16 code units of their keys, not by bytes.
+		sort.Slice(keys, func(i, j int) bool {
+			return lessUTF16(keys[i], keys[j])
+		})
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			canonicalString(buf, k)
+			buf.WriteByte(':')
+			if err := c.write(v[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("unsupported value of type %T", v)
+	}
+	return nil
+}
+
+// canonicalString - JSON string with the minimal escaping of RFC 8785: quote, backslash and control characters.
+func canonicalString(buf *bytes.Buffer, s string) {
+	const hex = "0123456789abcdef"
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case r == '\b':
+			buf.WriteString(`\b`)
+		case r == '\t':
+			buf.WriteString(`\t`)
+		case r == '\n':
+			buf.WriteString(`\n`)
+		case r == '\f':
+			buf.WriteString(`\f`)
+		case r == '\r':
+			buf.WriteString(`\r`)
+		case r < 0x20:
+			buf.WriteString(`\u00`)
+			buf.WriteByte(hex[r>>4])
+			buf.WriteByte(hex[r&0xF])
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('"')
+}
+
+func lessUTF16(a, b string) bool {
+	ua, ub := utf16.Encode([]rune(a)), utf16.Encode([]rune(b))
+	for i := 0; i < len(ua) && i < len(ub); i++ {
+		if ua[i] != ub[i] {
+			return ua[i] < ub[i]
+		}
+	}
+	return len(ua) < len(ub)
+}
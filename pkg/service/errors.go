@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/qredo-external/go-rnov/pkg/auth"
+	"github.com/qredo-external/go-rnov/pkg/storage"
+)
+
+// Kind - category of the errors of the service, adapters map each kind to their own error responses.
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindInvalid
+	KindUnauthorized
+	KindNotFound
+	KindConflict
+	KindUnsupported
+	KindUnprocessable
+	KindNotImplemented
+	KindUnavailable
+	KindTimeout
+	KindExhausted
+	KindTooLarge
+)
+
+// Error - an error of the service taxonomy, Code identifies it and Message describes it. Sentinel errors of the
+// service are of this type, they are usually wrapped with further detail so compare them with errors.Is.
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+}
+
+// NewError - creates an error of the taxonomy, adapters use it to classify the errors of their own layer too.
+func NewError(kind Kind, code, message string) *Error {
+	return &Error{
+		Kind:    kind,
+		Code:    code,
+		Message: message,
+	}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+var (
+	// ErrInternal - an error outside of the taxonomy, its detail must not reach clients.
+	ErrInternal = NewError(KindInternal, "internal", "internal error")
+	// ErrCanceled - the operation was canceled before it finished.
+	ErrCanceled = NewError(KindUnavailable, "canceled", "operation canceled")
+	// ErrDeadlineExceeded - the operation did not finish before its deadline.
+	ErrDeadlineExceeded = NewError(KindTimeout, "deadline-exceeded", "operation deadline exceeded")
+	// ErrStorageUnavailable - a storage the operation depends on can not be reached, it may succeed if retried.
+	ErrStorageUnavailable = NewError(KindUnavailable, "storage-unavailable", "storage unavailable")
+
+	// ErrRateLimited - the client made more requests than its rate limit allows, it may retry later.
+	ErrRateLimited = NewError(KindExhausted, "rate-limited", "rate limit exceeded")
+
+	// ErrInvalidUser - the user data is not valid to authenticate.
+	ErrInvalidUser = NewError(KindInvalid, "invalid-user", "invalid user")
+	// ErrTokenInvalid - the token is malformed, of another type or its claims are not valid.
+	ErrTokenInvalid = NewError(KindUnauthorized, "invalid-token", "invalid token")
+	// ErrTokenSignature - the token was not signed by the service.
+	ErrTokenSignature = NewError(KindUnauthorized, "token-signature", "invalid token signature")
+	// ErrTokenExpired - the token expired, a new one has to be requested.
+	ErrTokenExpired = NewError(KindUnauthorized, "token-expired", "token expired")
+	// ErrTokenRevoked - the token is valid but no longer active in the service.
+	ErrTokenRevoked = NewError(KindUnauthorized, "token-revoked", "token revoked")
+	// ErrTokenBinding - the token is bound to a key, e.g. a client certificate, the request did not prove possession of.
+	ErrTokenBinding = NewError(KindUnauthorized, "token-binding", "token not bound to the presented key")
+	// ErrDPoPProof - the DPoP proof of the request is missing or not valid.
+	ErrDPoPProof = NewError(KindUnauthorized, "invalid-dpop-proof", "invalid dpop proof")
+	// ErrDPoPNonce - the DPoP proof must carry the nonce of the DPoP-Nonce response header.
+	ErrDPoPNonce = NewError(KindUnauthorized, "use-dpop-nonce", "dpop nonce required")
+)
+
+// classified - errors of the ports the service depends on along with the error of the taxonomy they stand for.
+var classified = []struct {
+	err error
+	as  *Error
+}{
+	{err: auth.ErrTokenMalformed, as: ErrTokenInvalid},
+	{err: auth.ErrTokenSignature, as: ErrTokenSignature},
+	{err: auth.ErrTokenExpired, as: ErrTokenExpired},
+	{err: auth.ErrTokenNotValidYet, as: ErrTokenInvalid},
+	{err: auth.ErrTokenType, as: ErrTokenInvalid},
+	{err: auth.ErrTokenClaims, as: ErrTokenInvalid},
+	{err: auth.ErrTokenBinding, as: ErrTokenBinding},
+	{err: auth.ErrDPoPProof, as: ErrDPoPProof},
+	{err: auth.ErrDPoPNonce, as: ErrDPoPNonce},
+	{err: storage.ErrUnavailable, as: ErrStorageUnavailable},
+}
+
+// AsError - the error of the taxonomy err is or wraps, errors of pkg/auth and pkg/storage and context errors are
+// classified as their counterpart in the taxonomy and any other error as ErrInternal.
+func AsError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	for _, c := range classified {
+		if errors.Is(err, c.err) {
+			return c.as
+		}
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrDeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return ErrCanceled
+	default:
+		return ErrInternal
+	}
+}
@@ -0,0 +1,54 @@
+package service
+
+import "context"
+
+// Contribution - a number taken into account by Sum, Added is the integer actually added (numbers are truncated) and
+// Total the running total after adding it.
+type Contribution struct {
+	Pointer string
+	Value   float64
+	Added   int
+	Total   int
+}
+
+// Ignored - a value found in the document that did not contribute to the result and why (string, bool, null,
+// excluded or not included), Value is nil for excluded members.
+type Ignored struct {
+	Pointer string
+	Value   interface{}
+	Reason  string
+}
+
+// Explanation - detail of how a Sum result was computed.
+type Explanation struct {
+	Numbers []Contribution
+	Ignored []Ignored
+	Sum     int
+	Hash    string
+}
+
+// Explain - computes Sum reporting every number that contributed with its RFC 6901 JSON Pointer and every value that
+// was ignored.
+func (om OperationManager) Explain(ctx context.Context, data interface{}, opts Options) (*Explanation, error) {
+	exp := &Explanation{
+		Numbers: []Contribution{},
+		Ignored: []Ignored{},
+	}
+	err := traceSelection(ctx, data, opts, func(ptr string, v interface{}, reason string) {
+		switch {
+		case reason == "":
+			n := v.(float64)
+			exp.Sum += int(n)
+			exp.Numbers = append(exp.Numbers, Contribution{Pointer: ptr, Value: n, Added: int(n), Total: exp.Sum})
+		case reason == reasonExcluded:
+			exp.Ignored = append(exp.Ignored, Ignored{Pointer: ptr, Reason: reason})
+		default:
+			exp.Ignored = append(exp.Ignored, Ignored{Pointer: ptr, Value: v, Reason: reason})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	exp.Hash = hashSum(exp.Sum)
+	return exp, nil
+}
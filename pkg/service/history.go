@@ -0,0 +1,97 @@
+package service
+
+import (
+	"time"
+
+	"github.com/qredo-external/go-rnov/pkg/storage"
+)
+
+const (
+	// DefaultPageSize - computations listed when no limit is given.
+	DefaultPageSize = 20
+	// MaxPageSize - upper bound of the computations listed at once.
+	MaxPageSize = 100
+	// OperationSum - operation recorded for sums, aggregations are recorded with their own name.
+	OperationSum = "sum"
+)
+
+var (
+	// ErrComputationNotFound - there is no computation with that id recorded for the subject.
+	ErrComputationNotFound = NewError(KindNotFound, "computation-not-found", "computation not found")
+	// ErrInvalidPage - offset is negative or limit is out of [1, MaxPageSize].
+	ErrInvalidPage = NewError(KindInvalid, "invalid-page", "invalid page")
+)
+
+// HistoryPage - a page of the computations of a subject, newest first, Total counts all of them.
+type HistoryPage struct {
+	Computations []storage.Computation
+	Total        int
+	Offset       int
+	Limit        int
+}
+
+// HistoryManager - records computations in the history store, they are only visible to their subject.
+type HistoryManager struct {
+	store storage.ManageHistory
+	now   func() time.Time
+}
+
+// NewHistoryService - history service constructor.
+func NewHistoryService(store storage.ManageHistory) *HistoryManager {
+	return &HistoryManager{
+		store: store,
+		now:   time.Now,
+	}
+}
+
+// Historian - defines the operations over the history of computations offered to the adapters.
+type Historian interface {
+	RecordComputation(c storage.Computation) (storage.Computation, error)
+	ListComputations(owner string, offset, limit int) (*HistoryPage, error)
+	GetComputation(owner, id string) (storage.Computation, error)
+}
+
+// RecordComputation - assigns an id to the computation and stores it, CreatedAt defaults to now.
+func (hm *HistoryManager) RecordComputation(c storage.Computation) (storage.Computation, error) {
+	id, err := newID()
+	if err != nil {
+		return storage.Computation{}, err
+	}
+	c.ID = id
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = hm.now().UTC()
+	}
+	if err := hm.store.SaveComputation(c); err != nil {
+		return storage.Computation{}, err
+	}
+	return c, nil
+}
+
+// ListComputations - a page of the computations of owner, limit 0 stands for DefaultPageSize.
+func (hm *HistoryManager) ListComputations(owner string, offset, limit int) (*HistoryPage, error) {
+	if limit == 0 {
+		limit = DefaultPageSize
+	}
+	if offset < 0 || limit < 0 || limit > MaxPageSize {
+		return nil, ErrInvalidPage
+	}
+	cs, total, err := hm.store.ListComputations(owner, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	return &HistoryPage{
+		Computations: cs,
+		Total:        total,
+		Offset:       offset,
+		Limit:        limit,
+	}, nil
+}
+
+// GetComputation - a computation of owner, the ones of other subjects are reported as not found.
+func (hm *HistoryManager) GetComputation(owner, id string) (storage.Computation, error) {
+	c, ok := hm.store.GetComputation(id)
+	if !ok || c.Subject != owner {
+		return storage.Computation{}, ErrComputationNotFound
+	}
+	return c, nil
+}
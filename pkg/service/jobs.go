@@ -0,0 +1,294 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/qredo-external/go-rnov/pkg/auth"
+	"github.com/qredo-external/go-rnov/pkg/logger"
+	"github.com/qredo-external/go-rnov/pkg/storage"
+)
+
+var (
+	// ErrJobNotFound - there is no job with that id owned by the subject.
+	ErrJobNotFound = NewError(KindNotFound, "job-not-found", "job not found")
+	// ErrJobFinished - the job already reached a final status and can not be canceled.
+	ErrJobFinished = NewError(KindConflict, "job-finished", "job already finished")
+	// ErrQueueFull - the job queue is full, the job was not accepted.
+	ErrQueueFull = NewError(KindUnavailable, "queue-full", "job queue full")
+	// ErrJobsClosed - the job service is shutting down and does not accept jobs.
+	ErrJobsClosed = NewError(KindUnavailable, "jobs-closed", "job service closed")
+)
+
+// task - a queued sum job along with its input, ctx is canceled when the job is. Digest and size are those of the raw
+// document, kept for its history, and rsv the usage reserved for it.
+type task struct {
+	ctx    context.Context
+	id     string
+	owner  string
+	data   interface{}
+	opts   Options
+	digest string
+	size   int
+	rsv    Reservation
+}
+
+// JobManager - runs sums asynchronously on a pool of workers, jobs are persisted in the job store and only visible
+// to the subject that submitted them.
+type JobManager struct {
+	ops     Operations
+	store   storage.ManageJobs
+	history Historian
+	quotas  Quotas
+	queue   chan task
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	closed  bool
+	cancels map[string]context.CancelFunc
+	log     logger.Logger
+}
+
+// NewJobService - job service constructor, starts workers goroutines consuming a queue of queueSize jobs, hs nil when
+// the sums are not recorded in the history, qt nil when usage is not limited and lg nil discards the logs.
+func NewJobService(ops Operations, store storage.ManageJobs, hs Historian, qt Quotas, workers, queueSize int, lg logger.Logger) *JobManager {
+	if lg == nil {
+		lg = logger.Nop()
+	}
+	jm := &JobManager{
+		log:     lg,
+		ops:     ops,
+		store:   store,
+		history: hs,
+		quotas:  qt,
+		queue:   make(chan task, queueSize),
+		cancels: make(map[string]context.CancelFunc),
+	}
+	for i := 0; i < workers; i++ {
+		jm.wg.Add(1)
+		go jm.work()
+	}
+	return jm
+}
+
+// Jobs - defines the asynchronous job operations offered to the adapters.
+type Jobs interface {
+	SubmitSum(ctx context.Context, owner string, raw []byte, data interface{}, opts Options) (storage.Job, error)
+	GetJob(owner, id string) (storage.Job, error)
+	CancelJob(owner, id string) (storage.Job, error)
+}
+
+// SubmitSum - queues a sum of the document, data decoded from raw, for the owner and returns the queued job. The sum
+// is counted against the quota of the owner when it is submitted and given back unless it succeeds, a *QuotaError is
+// returned when the quota does not allow it.
+func (jm *JobManager) SubmitSum(ctx context.Context, owner string, raw []byte, data interface{}, opts Options) (storage.Job, error) {
+	id, err := newID()
+	if err != nil {
+		return storage.Job{}, err
+	}
+	t := task{id: id, owner: owner, data: data, opts: opts, digest: auth.DocumentDigest(raw), size: len(raw)}
+	if jm.quotas != nil {
+		if t.rsv, err = jm.quotas.ReserveUsage(ctx, owner, len(raw)); err != nil {
+			return storage.Job{}, err
+		}
+	}
+	now := time.Now().UTC()
+	job := storage.Job{ID: id, Owner: owner, Status: storage.JobQueued, CreatedAt: now, UpdatedAt: now}
+	if err := jm.store.SaveJob(job); err != nil {
+		jm.refund(t)
+		return storage.Job{}, err
+	}
+
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+	if jm.closed {
+		jm.refund(t)
+		return storage.Job{}, jm.fail(job, ErrJobsClosed)
+	}
+	var cancel context.CancelFunc
+	t.ctx, cancel = context.WithCancel(context.Background())
+	select {
+	case jm.queue <- t:
+		jm.cancels[id] = cancel
+		return job, nil
+	default:
+		cancel()
+		jm.refund(t)
+		return storage.Job{}, jm.fail(job, ErrQueueFull)
+	}
+}
+
+// GetJob - returns the job if it is owned by owner.
+func (jm *JobManager) GetJob(owner, id string) (storage.Job, error) {
+	job, ok := jm.store.GetJob(id)
+	// note jobs of other subjects are reported as not found so their ids are not disclosed.
+	if !ok || job.Owner != owner {
+		return storage.Job{}, ErrJobNotFound
+	}
+	return job, nil
+}
+
+// CancelJob - cancels a job of owner that did not finish yet, a running sum stops as soon as it notices.
+func (jm *JobManager) CancelJob(owner, id string) (storage.Job, error) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+	job, err := jm.GetJob(owner, id)
+	if err != nil {
+		return storage.Job{}, err
+	}
+	if job.Done() {
+		return job, ErrJobFinished
+	}
+	if cancel, ok := jm.cancels[id]; ok {
+		cancel()
+	}
+	job.Status, job.UpdatedAt = storage.JobCanceled, time.Now().UTC()
+	if err := jm.store.SaveJob(job); err != nil {
+		return storage.Job{}, err
+	}
+	return job, nil
+}
+
+// Close - stops accepting jobs and waits for the queued ones to be processed.
+func (jm *JobManager) Close() {
+	jm.mu.Lock()
+	if !jm.closed {
+		jm.closed = true
+		close(jm.queue)
+	}
+	jm.mu.Unlock()
+	jm.wg.Wait()
+}
+
+// Shutdown - like Close but bounded by ctx: once ctx is done the jobs still queued or running are canceled, so the
+// workers stop as soon as they notice, and the context error is returned. Their status is set to canceled, with
+// ErrJobsClosed as error, so clients polling them are not left waiting.
+func (jm *JobManager) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		jm.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	jm.mu.Lock()
+	for id, cancel := range jm.cancels {
+		cancel()
+		job, ok := jm.store.GetJob(id)
+		if !ok || job.Done() {
+			continue
+		}
+		job.Status, job.Error, job.UpdatedAt = storage.JobCanceled, ErrJobsClosed.Error(), time.Now().UTC()
+		if err := jm.store.SaveJob(job); err != nil {
+			jm.log.Error("unable to save job", logger.F("job_id", id), logger.F("status", job.Status), logger.F("error", err))
+		}
+	}
+	jm.mu.Unlock()
+	<-done
+	jm.log.Warn("jobs canceled on shutdown", logger.F("reason", ctx.Err()))
+	return ctx.Err()
+}
+
+func (jm *JobManager) work() {
+	defer jm.wg.Done()
+	for t := range jm.queue {
+		succeeded := false
+		if jm.transition(t, func(job *storage.Job) { job.Status = storage.JobRunning }) {
+			start := time.Now()
+			res, err := jm.ops.Sum(t.ctx, t.data, t.opts)
+			if err == nil {
+				err = jm.record(t, res.Hash, start)
+			}
+			succeeded = jm.transition(t, func(job *storage.Job) {
+				if err != nil {
+					job.Status, job.Error = storage.JobFailed, err.Error()
+					return
+				}
+				job.Status, job.Result = storage.JobSucceeded, res.Hash
+			}) && err == nil
+		}
+		// note jobs that failed or were canceled, before running or while, give their usage back
+		if !succeeded {
+			jm.refund(t)
+		}
+		jm.mu.Lock()
+		jm.cancels[t.id]()
+		delete(jm.cancels, t.id)
+		jm.mu.Unlock()
+	}
+}
+
+// record - records the sum of the job in the history of its owner, if there is one.
+func (jm *JobManager) record(t task, result string, start time.Time) error {
+	if jm.history == nil {
+		return nil
+	}
+	_, err := jm.history.RecordComputation(storage.Computation{
+		Subject:   t.owner,
+		Operation: OperationSum,
+		CreatedAt: start.UTC(),
+		Digest:    t.digest,
+		Size:      t.size,
+		Result:    result,
+		Duration:  time.Since(start),
+	})
+	return err
+}
+
+// refund - gives back the usage reserved for the job, if usage is limited.
+func (jm *JobManager) refund(t task) {
+	if jm.quotas == nil {
+		return
+	}
+	if err := jm.quotas.RefundUsage(context.Background(), t.rsv); err != nil {
+		jm.log.Error("unable to refund usage", logger.F("job_id", t.id), logger.F("subject", t.owner), logger.F("error", err))
+	}
+}
+
+// transition - applies the change to the stored job unless it was canceled, in which case false is returned.
+func (jm *JobManager) transition(t task, change func(job *storage.Job)) bool {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+	if t.ctx.Err() != nil {
+		return false
+	}
+	job, ok := jm.store.GetJob(t.id)
+	if !ok {
+		return false
+	}
+	change(&job)
+	job.UpdatedAt = time.Now().UTC()
+	lg := jm.log.With(logger.F("job_id", job.ID), logger.F("subject", job.Owner))
+	if err := jm.store.SaveJob(job); err != nil {
+		lg.Error("unable to save job", logger.F("status", job.Status), logger.F("error", err))
+	} else if job.Status == storage.JobFailed {
+		lg.Warn("job failed", logger.F("error", job.Error))
+	} else if job.Done() {
+		lg.Info("job finished", logger.F("status", job.Status))
+	}
+	return true
+}
+
+// fail - records why a job could not be queued and returns the reason.
+func (jm *JobManager) fail(job storage.Job, reason error) error {
+	job.Status, job.Error, job.UpdatedAt = storage.JobFailed, reason.Error(), time.Now().UTC()
+	lg := jm.log.With(logger.F("job_id", job.ID), logger.F("subject", job.Owner))
+	lg.Warn("job not queued", logger.F("reason", reason))
+	if err := jm.store.SaveJob(job); err != nil {
+		lg.Error("unable to save job", logger.F("status", job.Status), logger.F("error", err))
+	}
+	return reason
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,28 @@
+package service
+
+import "time"
+
+// Metrics - port through which services and adapters report what they measure, implemented by a metrics adapter
+// (see pkg/metrics) so the domain does not depend on any metrics library.
+type Metrics interface {
+	// TokenIssued - a token was handed out by CreateAuth.
+	TokenIssued()
+	// TokenValidated - a token was accepted to access a protected route.
+	TokenValidated()
+	// TokenRejected - a token was refused, reason is the code of its error (e.g. token-expired).
+	TokenRejected(reason string)
+	// SumComputed - a sum was served for a document of size bytes in d, cached when it came from the result cache.
+	SumComputed(size int, d time.Duration, cached bool)
+}
+
+// NopMetrics - Metrics that discards every measurement.
+func NopMetrics() Metrics {
+	return nopMetrics{}
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) TokenIssued()                         {}
+func (nopMetrics) TokenValidated()                      {}
+func (nopMetrics) TokenRejected(string)                 {}
+func (nopMetrics) SumComputed(int, time.Duration, bool) {}
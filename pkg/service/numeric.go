@@ -0,0 +1,80 @@
+package service
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Numeric string modes, see Options.NumericStrings.
+const (
+	// NumericStringsOff - strings are never numbers, the default.
+	NumericStringsOff = ""
+	// NumericStringsLenient - strings holding a number are taken into account, ambiguous ones are ignored.
+	NumericStringsLenient = "lenient"
+	// NumericStringsStrict - like lenient but ambiguous strings fail the operation with ErrAmbiguousNumber.
+	NumericStringsStrict = "strict"
+)
+
+var (
+	// ErrInvalidOptions - an option has an unsupported value.
+	ErrInvalidOptions = NewError(KindInvalid, "invalid-options", "invalid options")
+	// ErrAmbiguousNumber - in strict mode a string looks like a number but does not follow the accepted notations.
+	ErrAmbiguousNumber = NewError(KindUnprocessable, "ambiguous-number", "ambiguous numeric string")
+)
+
+// reasonAmbiguous - reason reported for ambiguous numeric strings in lenient mode.
+const reasonAmbiguous = "ambiguous number"
+
+var (
+	// decimalNumber - decimal and scientific notation, `.` is the only decimal separator and there are no grouping
+	// separators whatever the locale of the producer.
+	decimalNumber = regexp.MustCompile(`^[+-]?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+	// hexNumber - hexadecimal integers with the 0x prefix.
+	hexNumber = regexp.MustCompile(`^([+-]?)0[xX]([0-9a-fA-F]+)$`)
+	// nearNumber - what a string looks like once surrounding spaces and grouping separators are dropped when it was
+	// meant to be a number: leading zeros, missing integer or fraction digits, or any decimal separator.
+	nearNumber = regexp.MustCompile(`^[+-]?[0-9]*[.,]?[0-9]*([eE][+-]?[0-9]+)?$`)
+	// groupingSeparators - dropped to tell whether a string was meant to be a number.
+	groupingSeparators = strings.NewReplacer(",", "", "_", "", "'", "", " ", "")
+)
+
+// numericString - parses a string as a number using locale independent rules, ambiguous is set for strings that were
+// likely meant to be numbers but do not follow the accepted notations (e.g. "1,000", " 12", "012", ".5").
+func numericString(s string) (n float64, ok bool, ambiguous bool) {
+	if decimalNumber.MatchString(s) {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			// note out of the float64 range
+			return 0, false, true
+		}
+		return f, true, false
+	}
+	if m := hexNumber.FindStringSubmatch(s); m != nil {
+		u, err := strconv.ParseUint(m[2], 16, 64)
+		if err != nil {
+			return 0, false, true
+		}
+		f := float64(u)
+		if m[1] == "-" {
+			f = -f
+		}
+		return f, true, false
+	}
+	stripped := groupingSeparators.Replace(strings.TrimSpace(s))
+	if strings.ContainsAny(stripped, "0123456789") && (nearNumber.MatchString(stripped) ||
+		decimalNumber.MatchString(strings.Replace(stripped, ".", "", -1))) {
+		return 0, false, true
+	}
+	return 0, false, false
+}
+
+// validNumericStrings - checks the numeric strings mode.
+func validNumericStrings(mode string) bool {
+	switch mode {
+	case NumericStringsOff, NumericStringsLenient, NumericStringsStrict:
+		return true
+	default:
+		return false
+	}
+}
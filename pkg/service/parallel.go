@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultSplitThreshold - containers with fewer elements are walked by the goroutine that finds them.
+const DefaultSplitThreshold = 8192
+
+// WithParallelism - Sum splits arrays and objects with at least threshold elements in chunks walked by up to workers
+// goroutines. workers <= 0 uses GOMAXPROCS, 1 disables parallelism and threshold <= 0 uses the default threshold.
+func WithParallelism(workers, threshold int) OperationsOption {
+	return func(om *OperationManager) {
+		if workers <= 0 {
+			workers = runtime.GOMAXPROCS(0)
+		}
+		if threshold <= 0 {
+			threshold = DefaultSplitThreshold
+		}
+		om.workers, om.threshold = workers, threshold
+	}
+}
+
+// splitter - walks values splitting large containers across a bounded pool, sem holds a token per extra goroutine
+// running so the pool never exceeds the configured workers; when it is full chunks are walked inline. stopped is set
+// once the walk is canceled, so every goroutine stops at the next value it walks.
+// note the result is exact and deterministic since sums of integers do not depend on the order they are added in.
+type splitter struct {
+	s         *selector
+	sem       chan struct{}
+	threshold int
+	mu        sync.Mutex
+	canceled  error
+	stopped   int32
+}
+
+// parallelSum - like getSum but splitting large containers across workers goroutines.
+func parallelSum(ctx context.Context, data interface{}, opts Options, workers, threshold int) (int, error) {
+	roots, s, err := selection(ctx, data, opts)
+	if err != nil {
+		return 0, err
+	}
+	if s.track {
+		// note pointers are needed to report ambiguous strings, it is walked sequentially so they keep their order.
+		return getSum(ctx, data, opts)
+	}
+	p := &splitter{
+		s:         s,
+		sem:       make(chan struct{}, workers-1),
+		threshold: threshold,
+	}
+	total, steps := 0, 0
+	for _, root := range roots {
+		total += p.sum(root.value, false, &steps)
+	}
+	if p.canceled != nil {
+		return 0, p.canceled
+	}
+	return total, nil
+}
+
+// sum - adds the numbers of the value, steps counts the values walked by the calling goroutine to check the context
+// every checkEvery of them. Every value checks whether the walk was stopped, by this or another goroutine.
+func (p *splitter) sum(v interface{}, included bool, steps *int) int {
+	if atomic.LoadInt32(&p.stopped) == 1 {
+		return 0
+	}
+	if *steps++; *steps%checkEvery == 0 && p.cancel(p.s.ctx.Err()) {
+		return 0
+	}
+	total := 0
+	switch data := v.(type) {
+	case []interface{}:
+		if len(data) >= p.threshold {
+			return p.split(len(data), func(i int, steps *int) int {
+				return p.sum(data[i], included, steps)
+			})
+		}
+		for _, e := range data {
+			total += p.sum(e, included, steps)
+		}
+	case map[string]interface{}:
+		member := func(k string, e interface{}, steps *int) int {
+			if p.s.exclude[k] {
+				return 0
+			}
+			return p.sum(e, included || p.s.include[k], steps)
+		}
+		if len(data) >= p.threshold {
+			keys := make([]string, 0, len(data))
+			for k := range data {
+				keys = append(keys, k)
+			}
+			return p.split(len(keys), func(i int, steps *int) int {
+				return member(keys[i], data[keys[i]], steps)
+			})
+		}
+		for k, e := range data {
+			total += member(k, e, steps)
+		}
+	default:
+		p.s.scalar(node{value: v}, included, func(_ string, n interface{}, reason string) {
+			if reason == "" {
+				total += int(n.(float64))
+			}
+		})
+	}
+	return total
+}
+
+// cancel - records err if not nil and reports whether the walk was canceled.
+func (p *splitter) cancel(err error) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.canceled == nil && err != nil {
+		p.canceled = err
+		atomic.StoreInt32(&p.stopped, 1)
+	}
+	return p.canceled != nil
+}
+
+// split - adds fn(i) for i in [0, n) walking chunks in parallel while the pool has room.
+func (p *splitter) split(n int, fn func(i int, steps *int) int) int {
+	chunk := (n + cap(p.sem)) / (cap(p.sem) + 1)
+	if chunk < p.threshold {
+		chunk = p.threshold
+	}
+	partials := make([]int, (n+chunk-1)/chunk)
+	var wg sync.WaitGroup
+	for c := range partials {
+		lo, hi := c*chunk, (c+1)*chunk
+		if hi > n {
+			hi = n
+		}
+		work := func(c, lo, hi int) {
+			steps := 0
+			for i := lo; i < hi; i++ {
+				partials[c] += fn(i, &steps)
+			}
+		}
+		select {
+		case p.sem <- struct{}{}:
+			wg.Add(1)
+			go func(c, lo, hi int) {
+				defer func() {
+					<-p.sem
+					wg.Done()
+				}()
+				work(c, lo, hi)
+			}(c, lo, hi)
+		default:
+			work(c, lo, hi)
+		}
+	}
+	wg.Wait()
+	total := 0
+	for _, partial := range partials {
+		total += partial
+	}
+	return total
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/qredo-external/go-rnov/pkg/storage"
+	"github.com/qredo-external/go-rnov/pkg/trace"
+)
+
+const (
+	// QuotaDaily - quotas reset at midnight UTC.
+	QuotaDaily = "day"
+	// QuotaMonthly - quotas reset on the first day of the month at midnight UTC.
+	QuotaMonthly = "month"
+
+	// QuotaOperations - resource counted by the operations a subject makes.
+	QuotaOperations = "operations"
+	// QuotaBytes - resource counted by the bytes of the documents a subject sends.
+	QuotaBytes = "bytes"
+)
+
+// ErrQuotaExceeded - the subject used its quota for the period, it may retry once the period resets.
+var ErrQuotaExceeded = NewError(KindExhausted, "quota-exceeded", "quota exceeded")
+
+// Quota - operations and bytes processed each subject is allowed per Period, QuotaDaily or QuotaMonthly, zero does
+// not limit them.
+type Quota struct {
+	Operations int
+	Bytes      int
+	Period     string
+}
+
+// QuotaError - the quota of Resource does not allow the operation, Used of Limit are taken until Reset. It wraps
+// ErrQuotaExceeded so adapters tell it apart with errors.Is and get the detail with errors.As.
+type QuotaError struct {
+	Resource string
+	Limit    int
+	Used     int
+	Period   string
+	Reset    time.Time
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s: %d of %d %s per %s used", ErrQuotaExceeded.Message, e.Used, e.Limit, e.Resource, e.Period)
+}
+
+func (e *QuotaError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// Usage - operations and bytes processed by a subject over the period from Start to Reset, along with its quota.
+type Usage struct {
+	Quota      Quota
+	Start      time.Time
+	Reset      time.Time
+	Operations int
+	Bytes      int
+}
+
+// RemainingOperations - operations left until Reset, -1 when they are not limited.
+func (u *Usage) RemainingOperations() int {
+	return remaining(u.Quota.Operations, u.Operations)
+}
+
+// RemainingBytes - bytes left until Reset, -1 when they are not limited.
+func (u *Usage) RemainingBytes() int {
+	return remaining(u.Quota.Bytes, u.Bytes)
+}
+
+func remaining(limit, used int) int {
+	if limit <= 0 {
+		return -1
+	}
+	if used >= limit {
+		return 0
+	}
+	return limit - used
+}
+
+// Reservation - usage of one operation over Bytes taken from the quota of Subject, for the period starting at Start,
+// before the operation is made.
+type Reservation struct {
+	Subject string
+	Start   time.Time
+	Bytes   int
+}
+
+// QuotaManager - keeps the usage of the subjects in the usage store and checks it against their quota.
+type QuotaManager struct {
+	store storage.ManageUsage
+	quota Quota
+	now   func() time.Time
+}
+
+// NewQuotaService - quota service constructor, the same quota applies to every subject.
+func NewQuotaService(store storage.ManageUsage, quota Quota) *QuotaManager {
+	return &QuotaManager{
+		store: store,
+		quota: quota,
+		now:   time.Now,
+	}
+}
+
+// Quotas - defines the quota operations offered to the adapters.
+type Quotas interface {
+	// ReserveUsage - counts an operation over size bytes against the quota of the subject before it is made, a
+	// *QuotaError when the quota does not allow it, in which case nothing is counted.
+	ReserveUsage(ctx context.Context, subject string, size int) (Reservation, error)
+	// RefundUsage - gives back the usage of a reservation whose operation was not made.
+	RefundUsage(ctx context.Context, r Reservation) error
+	// GetUsage - usage of the subject over the current period.
+	GetUsage(ctx context.Context, subject string) (*Usage, error)
+}
+
+// ReserveUsage - the usage is added and then checked in one atomic step of the store, so concurrent operations of a
+// subject never go over its quota together. An operation refused gives its usage back, meanwhile another one that
+// would have fitted may be refused too.
+func (qm *QuotaManager) ReserveUsage(ctx context.Context, subject string, size int) (Reservation, error) {
+	ctx, span := trace.Start(ctx, "QuotaManager.ReserveUsage", trace.KindInternal, trace.Attr("quota.bytes", size))
+	defer span.End()
+	start, reset := qm.period()
+	rsv := Reservation{Subject: subject, Start: start, Bytes: size}
+	u, err := qm.store.AddUsage(ctx, subject, start, 1, size)
+	if err != nil {
+		span.RecordError(err)
+		return Reservation{}, fmt.Errorf("error recording usage: %w", err)
+	}
+	exceeded := func(resource string, limit, used, requested int) error {
+		if limit > 0 && used > limit {
+			return &QuotaError{Resource: resource, Limit: limit, Used: used - requested, Period: qm.quota.Period, Reset: reset}
+		}
+		return nil
+	}
+	qe := exceeded(QuotaOperations, qm.quota.Operations, u.Operations, 1)
+	if qe == nil {
+		qe = exceeded(QuotaBytes, qm.quota.Bytes, u.Bytes, size)
+	}
+	if qe != nil {
+		if err := qm.RefundUsage(ctx, rsv); err != nil {
+			return Reservation{}, err
+		}
+		return Reservation{}, qe
+	}
+	return rsv, nil
+}
+
+// RefundUsage - the usage is given back to the period of the reservation, a period already over is left as is.
+func (qm *QuotaManager) RefundUsage(ctx context.Context, r Reservation) error {
+	ctx, span := trace.Start(ctx, "QuotaManager.RefundUsage", trace.KindInternal, trace.Attr("quota.bytes", r.Bytes))
+	defer span.End()
+	if _, err := qm.store.AddUsage(ctx, r.Subject, r.Start, -1, -r.Bytes); err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("error refunding usage: %w", err)
+	}
+	return nil
+}
+
+// GetUsage - usage of the subject over the current period.
+func (qm *QuotaManager) GetUsage(ctx context.Context, subject string) (*Usage, error) {
+	start, reset := qm.period()
+	u, err := qm.store.GetUsage(ctx, subject, start)
+	if err != nil {
+		return nil, fmt.Errorf("error reading usage: %w", err)
+	}
+	return &Usage{
+		Quota:      qm.quota,
+		Start:      start,
+		Reset:      reset,
+		Operations: u.Operations,
+		Bytes:      u.Bytes,
+	}, nil
+}
+
+// period - start and end of the current period, daily unless the quota is monthly.
+func (qm *QuotaManager) period() (time.Time, time.Time) {
+	y, m, d := qm.now().UTC().Date()
+	if qm.quota.Period == QuotaMonthly {
+		start := time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0)
+	}
+	start := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 0, 1)
+}
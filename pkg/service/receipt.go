@@ -0,0 +1,51 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/qredo-external/go-rnov/pkg/auth"
+)
+
+var (
+	// ErrMissingSubject - receipts are only issued for the subject of an authenticated request.
+	ErrMissingSubject = NewError(KindUnauthorized, "missing-subject", "receipt requires an authenticated subject")
+	// ErrInvalidReceipt - the receipt is malformed, was not signed by the service or is incomplete.
+	ErrInvalidReceipt = NewError(KindUnprocessable, "invalid-receipt", "invalid receipt")
+)
+
+// ReceiptManager - issues and verifies signed receipts for operation results.
+type ReceiptManager struct {
+	Receipts auth.Receipts
+}
+
+func NewReceiptService(rc auth.Receipts) *ReceiptManager {
+	return &ReceiptManager{
+		Receipts: rc,
+	}
+}
+
+// Receipter - defines the receipt operations offered to the adapters.
+type Receipter interface {
+	IssueReceipt(rc auth.Receipt) (string, error)
+	VerifyReceipt(receipt string) (*auth.Receipt, error)
+}
+
+// IssueReceipt - signs a receipt binding the result to the document digest and the subject that requested it, the
+// issue time is set by the service.
+func (rm ReceiptManager) IssueReceipt(rc auth.Receipt) (string, error) {
+	if rc.Subject == "" {
+		return "", ErrMissingSubject
+	}
+	rc.IssuedAt = time.Now().Unix()
+	return rm.Receipts.SignReceipt(rc)
+}
+
+// VerifyReceipt - checks a receipt issued by IssueReceipt and returns its content.
+func (rm ReceiptManager) VerifyReceipt(receipt string) (*auth.Receipt, error) {
+	rc, err := rm.Receipts.VerifyReceipt(receipt)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidReceipt, err.Error())
+	}
+	return rc, nil
+}
@@ -0,0 +1,658 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidSelection - the JSONPath, JSON Pointers or filters are malformed.
+	ErrInvalidSelection = NewError(KindInvalid, "invalid-selection", "invalid selection")
+	// ErrSelectionNotFound - a JSON Pointer does not reference any value of the document.
+	ErrSelectionNotFound = NewError(KindUnprocessable, "selection-not-found", "selection not found")
+)
+
+// Options - per request options of the operations, the zero value takes every number of the document into account.
+type Options struct {
+	// Path - JSONPath expression selecting the values to walk, e.g. `$.items[*].price`.
+	Path string
+	// Pointers - RFC 6901 JSON Pointers selecting the values to walk, exclusive with Path.
+	Pointers []string
+	// Include - when set, only numbers nested under a member with one of these keys are taken into account.
+	Include []string
+	// Exclude - members with one of these keys are skipped along with everything nested in them.
+	Exclude []string
+	// NumericStrings - whether strings holding numbers are taken into account, see the NumericStrings modes.
+	NumericStrings string
+}
+
+// String - canonical url encoded form of the options, empty for the zero value.
+func (o Options) String() string {
+	v := url.Values{}
+	if o.Path != "" {
+		v.Set("path", o.Path)
+	}
+	if o.NumericStrings != "" {
+		v.Set("numeric_strings", o.NumericStrings)
+	}
+	for k, vals := range map[string][]string{"pointer": o.Pointers, "include": o.Include, "exclude": o.Exclude} {
+		for _, val := range vals {
+			v.Add(k, val)
+		}
+	}
+	return v.Encode()
+}
+
+// Reasons a scalar is not taken into account, reported by visitors.
+const (
+	reasonString      = "string"
+	reasonBool        = "bool"
+	reasonNull        = "null"
+	reasonExcluded    = "excluded"
+	reasonNotIncluded = "not included"
+)
+
+// visitor - receives every scalar walked along with its JSON Pointer (only when tracking), reason is empty for the
+// numbers taken into account. Excluded members are reported once, with their whole value.
+type visitor func(ptr string, value interface{}, reason string)
+
+// node - a value of the document and its JSON Pointer.
+type node struct {
+	value interface{}
+	ptr   string
+}
+
+// checkEvery - number of values walked between checks of the context.
+const checkEvery = 1024
+
+// selector - walks the selected values of a document skipping the excluded members, pointers are only built when
+// track is set so plain operations do not pay for them. Ambiguous numeric strings found in strict mode are collected
+// and the walk stops once ctx is done.
+type selector struct {
+	ctx       context.Context
+	include   map[string]bool
+	exclude   map[string]bool
+	numeric   string
+	track     bool
+	ambiguous []string
+	steps     int
+	canceled  error
+}
+
+// selection - resolves the options against the document returning the values to walk, and the selector that applies
+// the include/exclude filters while walking them.
+func selection(ctx context.Context, data interface{}, opts Options) ([]node, *selector, error) {
+	if !validNumericStrings(opts.NumericStrings) {
+		return nil, nil, fmt.Errorf("%w: unknown numeric strings mode %q", ErrInvalidOptions, opts.NumericStrings)
+	}
+	s := &selector{
+		ctx:     ctx,
+		include: toSet(opts.Include),
+		exclude: toSet(opts.Exclude),
+		numeric: opts.NumericStrings,
+		// note pointers are needed to report where ambiguous strings are.
+		track: opts.NumericStrings == NumericStringsStrict,
+	}
+	switch {
+	case opts.Path != "" && len(opts.Pointers) > 0:
+		return nil, nil, fmt.Errorf("%w: path and pointers are exclusive", ErrInvalidSelection)
+	case opts.Path != "":
+		segs, err := parseJSONPath(opts.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		roots, err := evalJSONPath(ctx, data, segs)
+		if err != nil {
+			return nil, nil, err
+		}
+		return distinctRoots(roots), s, nil
+	case len(opts.Pointers) > 0:
+		roots := make([]node, 0, len(opts.Pointers))
+		for _, p := range opts.Pointers {
+			v, err := resolvePointer(data, p)
+			if err != nil {
+				return nil, nil, err
+			}
+			roots = append(roots, node{value: v, ptr: p})
+		}
+		return distinctRoots(roots), s, nil
+	default:
+		return []node{{value: data}}, s, nil
+	}
+}
+
+// distinctRoots - the selected values without those selected twice or nested in another selected value, in the order
+// they were selected, so every scalar is walked once whatever the selection overlaps.
+func distinctRoots(roots []node) []node {
+	selected := make(map[string]bool, len(roots))
+	for _, n := range roots {
+		selected[n.ptr] = true
+	}
+	res := make([]node, 0, len(roots))
+	for _, n := range roots {
+		if !selected[n.ptr] || nested(n.ptr, selected) {
+			continue
+		}
+		// note later selections of the same value are skipped
+		selected[n.ptr] = false
+		res = append(res, n)
+	}
+	return res
+}
+
+// nested - whether one of the ancestors of the JSON Pointer, the whole document included, is selected. Escaped
+// tokens never hold a `/`, so ancestors end right before one.
+func nested(ptr string, selected map[string]bool) bool {
+	for i := 0; i < len(ptr); i++ {
+		if ptr[i] == '/' {
+			if _, ok := selected[ptr[:i]]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// walk - visits the scalars of the value honouring the filters, included tells whether the value is already
+// nested under an included member.
+func (s *selector) walk(n node, included bool, visit visitor) {
+	if s.done() {
+		return
+	}
+	switch data := n.value.(type) {
+	case []interface{}:
+		for i, v := range data {
+			s.walk(node{value: v, ptr: s.child(n.ptr, strconv.Itoa(i))}, included, visit)
+		}
+	case map[string]interface{}:
+		if s.track {
+			for _, k := range sortedKeys(data) {
+				s.member(n.ptr, k, data[k], included, visit)
+			}
+			return
+		}
+		for k, v := range data {
+			s.member(n.ptr, k, v, included, visit)
+		}
+	default:
+		s.scalar(n, included, visit)
+	}
+}
+
+// done - checks the context every checkEvery steps, once it is done the walk stops.
+func (s *selector) done() bool {
+	if s.canceled != nil {
+		return true
+	}
+	if s.steps++; s.steps%checkEvery == 0 {
+		s.canceled = s.ctx.Err()
+	}
+	return s.canceled != nil
+}
+
+// scalar - visits a value that is not a container.
+func (s *selector) scalar(n node, included bool, visit visitor) {
+	switch data := n.value.(type) {
+	case float64:
+		s.number(n.ptr, n.value, included, visit)
+	case string:
+		if s.numeric == NumericStringsOff {
+			visit(n.ptr, n.value, reasonString)
+			return
+		}
+		switch f, ok, ambiguous := numericString(data); {
+		case ok:
+			s.number(n.ptr, f, included, visit)
+		case ambiguous && s.numeric == NumericStringsStrict:
+			s.ambiguous = append(s.ambiguous, fmt.Sprintf("%q at %q", data, n.ptr))
+		case ambiguous:
+			visit(n.ptr, n.value, reasonAmbiguous)
+		default:
+			visit(n.ptr, n.value, reasonString)
+		}
+	case bool:
+		visit(n.ptr, n.value, reasonBool)
+	case nil:
+		visit(n.ptr, n.value, reasonNull)
+	default:
+		break
+	}
+}
+
+func (s *selector) number(ptr string, n interface{}, included bool, visit visitor) {
+	if included || len(s.include) == 0 {
+		visit(ptr, n, "")
+		return
+	}
+	visit(ptr, n, reasonNotIncluded)
+}
+
+// err - reports why the walk stopped or the ambiguous numeric strings found in strict mode.
+func (s *selector) err() error {
+	if s.canceled != nil {
+		return s.canceled
+	}
+	if len(s.ambiguous) == 0 {
+		return nil
+	}
+	const max = 10
+	found := s.ambiguous
+	if len(found) > max {
+		found = append(found[:max:max], fmt.Sprintf("and %d more", len(s.ambiguous)-max))
+	}
+	return fmt.Errorf("%w: %s", ErrAmbiguousNumber, strings.Join(found, ", "))
+}
+
+func (s *selector) member(ptr, k string, v interface{}, included bool, visit visitor) {
+	if s.exclude[k] {
+		visit(s.child(ptr, k), v, reasonExcluded)
+		return
+	}
+	s.walk(node{value: v, ptr: s.child(ptr, k)}, included || s.include[k], visit)
+}
+
+func (s *selector) child(ptr, token string) string {
+	if !s.track {
+		return ""
+	}
+	return pointerChild(ptr, token)
+}
+
+// pointerChild - appends a reference token to a JSON Pointer escaping it as per RFC 6901.
+func pointerChild(ptr, token string) string {
+	return ptr + "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
+
+// walkSelection - visits every number selected by the options.
+func walkSelection(ctx context.Context, data interface{}, opts Options, visit func(n float64)) error {
+	roots, s, err := selection(ctx, data, opts)
+	if err != nil {
+		return err
+	}
+	for _, root := range roots {
+		s.walk(root, false, func(_ string, v interface{}, reason string) {
+			if reason == "" {
+				visit(v.(float64))
+			}
+		})
+	}
+	return s.err()
+}
+
+// traceSelection - visits every scalar of the values selected by the options along with its JSON Pointer, in
+// document order with object members sorted by key.
+func traceSelection(ctx context.Context, data interface{}, opts Options, visit visitor) error {
+	roots, s, err := selection(ctx, data, opts)
+	if err != nil {
+		return err
+	}
+	s.track = true
+	for _, root := range roots {
+		s.walk(root, false, visit)
+	}
+	return s.err()
+}
+
+func toSet(keys []string) map[string]bool {
+	set := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		set[k] = true
+	}
+	return set
+}
+
+// resolvePointer - resolves a RFC 6901 JSON Pointer against the document.
+func resolvePointer(data interface{}, pointer string) (interface{}, error) {
+	if pointer == "" {
+		return data, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: pointer %q must start with /", ErrInvalidSelection, pointer)
+	}
+	cur := data
+	for _, tok := range strings.Split(pointer[1:], "/") {
+		tok = strings.NewReplacer("~1", "/", "~0", "~").Replace(tok)
+		switch v := cur.(type) {
+		case map[string]interface{}:
+			next, ok := v[tok]
+			if !ok {
+				return nil, fmt.Errorf("%w: %q", ErrSelectionNotFound, pointer)
+			}
+			cur = next
+		case []interface{}:
+			idx, err := strconv.Atoi(tok)
+			if err != nil || idx < 0 || (len(tok) > 1 && tok[0] == '0') {
+				return nil, fmt.Errorf("%w: %q has an invalid array index", ErrInvalidSelection, pointer)
+			}
+			if idx >= len(v) {
+				return nil, fmt.Errorf("%w: %q", ErrSelectionNotFound, pointer)
+			}
+			cur = v[idx]
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrSelectionNotFound, pointer)
+		}
+	}
+	return cur, nil
+}
+
+// pathSegment - one step of a JSONPath, descendant is set for `..` steps.
+type pathSegment struct {
+	descendant bool
+	selectors  []pathSelector
+}
+
+// pathSelector - a member name, an index, a slice or a wildcard.
+type pathSelector struct {
+	wildcard bool
+	name     *string
+	index    *int
+	slice    *[3]*int
+}
+
+// parseJSONPath - parses the supported JSONPath subset: `$`, `.name`, `['name']`, `[n]`, `[start:end:step]`,
+// `[*]`, `.*`, unions `[a,b]` and recursive descent `..`; filter expressions are not supported.
+func parseJSONPath(path string) ([]pathSegment, error) {
+	invalid := func(reason string) error {
+		return fmt.Errorf("%w: path %q %s", ErrInvalidSelection, path, reason)
+	}
+	if !strings.HasPrefix(path, "$") {
+		return nil, invalid("must start with $")
+	}
+	var segs []pathSegment
+	rest := path[1:]
+	for rest != "" {
+		seg := pathSegment{}
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			seg.descendant = true
+			rest = rest[2:]
+			if strings.HasPrefix(rest, "[") {
+				break
+			}
+			name, n := dotName(rest)
+			if n == 0 {
+				return nil, invalid("has an empty member after ..")
+			}
+			seg.selectors, rest = []pathSelector{nameOrWildcard(name)}, rest[n:]
+			segs = append(segs, seg)
+			continue
+		case strings.HasPrefix(rest, "."):
+			name, n := dotName(rest[1:])
+			if n == 0 {
+				return nil, invalid("has an empty member")
+			}
+			seg.selectors, rest = []pathSelector{nameOrWildcard(name)}, rest[1+n:]
+			segs = append(segs, seg)
+			continue
+		case strings.HasPrefix(rest, "["):
+		default:
+			return nil, invalid(fmt.Sprintf("has an unexpected %q", rest[0]))
+		}
+		end := closingBracket(rest)
+		if end < 0 {
+			return nil, invalid("has an unclosed [")
+		}
+		sels, err := parseBracket(rest[1:end])
+		if err != nil {
+			return nil, invalid(err.Error())
+		}
+		seg.selectors, rest = sels, rest[end+1:]
+		segs = append(segs, seg)
+	}
+	return segs, nil
+}
+
+// dotName - returns the member name following a dot and its length.
+func dotName(s string) (string, int) {
+	n := strings.IndexAny(s, ".[")
+	if n < 0 {
+		n = len(s)
+	}
+	return s[:n], n
+}
+
+func nameOrWildcard(name string) pathSelector {
+	if name == "*" {
+		return pathSelector{wildcard: true}
+	}
+	return pathSelector{name: &name}
+}
+
+// closingBracket - index of the ] closing the bracket at s[0], skipping quoted names.
+func closingBracket(s string) int {
+	var quote byte
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+// parseBracket - parses the comma separated selectors of a bracket.
+func parseBracket(body string) ([]pathSelector, error) {
+	var sels []pathSelector
+	for _, part := range splitUnion(body) {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == "*":
+			sels = append(sels, pathSelector{wildcard: true})
+		case len(part) >= 2 && (part[0] == '\'' || part[0] == '"') && part[len(part)-1] == part[0]:
+			name := strings.NewReplacer(`\\`, `\`, `\'`, `'`, `\"`, `"`).Replace(part[1 : len(part)-1])
+			sels = append(sels, pathSelector{name: &name})
+		case strings.Contains(part, ":"):
+			bounds := strings.Split(part, ":")
+			if len(bounds) > 3 {
+				return nil, fmt.Errorf("has an invalid slice %q", part)
+			}
+			var slice [3]*int
+			for i, b := range bounds {
+				if b = strings.TrimSpace(b); b == "" {
+					continue
+				}
+				n, err := strconv.Atoi(b)
+				if err != nil {
+					return nil, fmt.Errorf("has an invalid slice %q", part)
+				}
+				slice[i] = &n
+			}
+			if slice[2] != nil && *slice[2] == 0 {
+				return nil, fmt.Errorf("has a zero slice step")
+			}
+			sels = append(sels, pathSelector{slice: &slice})
+		default:
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, fmt.Errorf("has an unsupported selector %q", part)
+			}
+			sels = append(sels, pathSelector{index: &n})
+		}
+	}
+	return sels, nil
+}
+
+// splitUnion - splits a bracket body on the commas that are not quoted.
+func splitUnion(body string) []string {
+	var parts []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(body); i++ {
+		switch c := body[i]; {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ',':
+			parts = append(parts, body[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, body[start:])
+}
+
+// evalJSONPath - applies the segments to the document returning the selected values in document order, object
+// members are visited in key order so results are deterministic. Evaluation stops once ctx is done.
+func evalJSONPath(ctx context.Context, data interface{}, segs []pathSegment) ([]node, error) {
+	nodes := []node{{value: data}}
+	for _, seg := range segs {
+		if seg.descendant {
+			d := &descent{ctx: ctx, seen: make(map[string]bool)}
+			for _, n := range nodes {
+				d.collect(n)
+			}
+			if d.canceled != nil {
+				return nil, d.canceled
+			}
+			nodes = d.nodes
+		}
+		var next []node
+		for _, n := range nodes {
+			for _, sel := range seg.selectors {
+				next = sel.apply(n, next)
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		nodes = next
+	}
+	return nodes, nil
+}
+
+// descent - collects the nodes of a recursive descent segment, the current nodes and all their nested values. A
+// value nested under several current nodes is collected once, so chained `..` segments select at most every value of
+// the document instead of growing with its depth.
+type descent struct {
+	ctx      context.Context
+	seen     map[string]bool
+	nodes    []node
+	steps    int
+	canceled error
+}
+
+// collect - appends the node and all its nested values, unless they were collected already.
+func (d *descent) collect(n node) {
+	// note pointers identify the values of the document, and the values nested under a node seen before were
+	// collected along with it
+	if d.canceled != nil || d.seen[n.ptr] {
+		return
+	}
+	if d.steps++; d.steps%checkEvery == 0 {
+		if d.canceled = d.ctx.Err(); d.canceled != nil {
+			return
+		}
+	}
+	d.seen[n.ptr] = true
+	d.nodes = append(d.nodes, n)
+	switch v := n.value.(type) {
+	case []interface{}:
+		for i, e := range v {
+			d.collect(node{value: e, ptr: pointerChild(n.ptr, strconv.Itoa(i))})
+		}
+	case map[string]interface{}:
+		for _, k := range sortedKeys(v) {
+			d.collect(node{value: v[k], ptr: pointerChild(n.ptr, k)})
+		}
+	}
+}
+
+func (ps pathSelector) apply(n node, acc []node) []node {
+	switch v := n.value.(type) {
+	case map[string]interface{}:
+		switch {
+		case ps.wildcard:
+			for _, k := range sortedKeys(v) {
+				acc = append(acc, node{value: v[k], ptr: pointerChild(n.ptr, k)})
+			}
+		case ps.name != nil:
+			if e, ok := v[*ps.name]; ok {
+				acc = append(acc, node{value: e, ptr: pointerChild(n.ptr, *ps.name)})
+			}
+		}
+	case []interface{}:
+		var idx []int
+		switch {
+		case ps.wildcard:
+			idx = sliceIndexes([3]*int{}, len(v))
+		case ps.index != nil:
+			i := *ps.index
+			if i < 0 {
+				i += len(v)
+			}
+			if i >= 0 && i < len(v) {
+				idx = []int{i}
+			}
+		case ps.slice != nil:
+			idx = sliceIndexes(*ps.slice, len(v))
+		}
+		for _, i := range idx {
+			acc = append(acc, node{value: v[i], ptr: pointerChild(n.ptr, strconv.Itoa(i))})
+		}
+	}
+	return acc
+}
+
+// sliceIndexes - indexes selected by a [start:end:step] slice over an array of length n.
+func sliceIndexes(slice [3]*int, n int) []int {
+	step := 1
+	if slice[2] != nil {
+		step = *slice[2]
+	}
+	norm := func(p *int, def int) int {
+		if p == nil {
+			return def
+		}
+		i := *p
+		if i < 0 {
+			i += n
+		}
+		return i
+	}
+	var idx []int
+	if step > 0 {
+		start, end := clamp(norm(slice[0], 0), 0, n), clamp(norm(slice[1], n), 0, n)
+		for i := start; i < end; i += step {
+			idx = append(idx, i)
+		}
+		return idx
+	}
+	start, end := clamp(norm(slice[0], n-1), -1, n-1), clamp(norm(slice[1], -n-1), -1, n-1)
+	for i := start; i > end; i += step {
+		idx = append(idx, i)
+	}
+	return idx
+}
+
+func clamp(i, lo, hi int) int {
+	if i < lo {
+		return lo
+	}
+	if i > hi {
+		return hi
+	}
+	return i
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
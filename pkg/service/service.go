@@ -1,89 +1,215 @@
 package service
 
 import (
+	"context"
 	"crypto/sha256"
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/qredo-external/go-rnov/pkg/auth"
+	"github.com/qredo-external/go-rnov/pkg/logger"
 	"github.com/qredo-external/go-rnov/pkg/storage"
+	"github.com/qredo-external/go-rnov/pkg/trace"
 	"github.com/qredo-external/go-rnov/pkg/user"
 )
 
 // note in a prod ready it would have a couple of things, a big miss the lack of data that encapsulate `operation` just like
 // auth but due the simplicity and the specifics of hashing is not worthy. I kept it due the design specifics.
 type OperationManager struct {
-	//	note it would have a logger
+	log       logger.Logger
+	workers   int
+	threshold int
+	cache     storage.ResultCache
 }
 
-func NewOperationsService() *OperationManager {
-	return &OperationManager{}
+// OperationsOption - configures optional features of OperationManager.
+type OperationsOption func(om *OperationManager)
+
+// WithCache - Sum results are cached by the RFC 8785 digest of the document and the options, so identical documents
+// are only summed once while cached. Entries are kept per subject, so whether a result was cached never tells a
+// subject what another one summed.
+func WithCache(c storage.ResultCache) OperationsOption {
+	return func(om *OperationManager) {
+		om.cache = c
+	}
+}
+
+// WithLogger - logs of the operations, requests log with the logger of their context when they carry one.
+func WithLogger(lg logger.Logger) OperationsOption {
+	return func(om *OperationManager) {
+		om.log = lg
+	}
+}
+
+// NewOperationsService - operations constructor, by default Sum walks large containers using GOMAXPROCS goroutines
+// and logs are discarded.
+func NewOperationsService(opts ...OperationsOption) *OperationManager {
+	om := &OperationManager{log: logger.Nop()}
+	WithParallelism(0, 0)(om)
+	for _, opt := range opts {
+		opt(om)
+	}
+	return om
+}
+
+// SumResult - outcome of Sum, Digest is the RFC 8785 digest of the document (only computed when a cache is configured)
+// and Cached tells whether the result was served from the cache.
+type SumResult struct {
+	Hash   string
+	Digest string
+	Cached bool
 }
 
+// Operations - defines the operations over documents, they stop with the context error once ctx is done.
 type Operations interface {
-	Sum(data interface{}) (string, error)
+	Sum(ctx context.Context, data interface{}, opts Options) (*SumResult, error)
+	Aggregate(ctx context.Context, op string, data interface{}, opts Options) (*Aggregation, error)
+	Explain(ctx context.Context, data interface{}, opts Options) (*Explanation, error)
 }
 
-// Sum - finds all the numbers throughout a valid (json) document and adds them together and hashes the expectedHash.
-func (om OperationManager) Sum(data interface{}) (string, error) {
-	sumRes := getSum(data)
-	res := strconv.Itoa(sumRes)
-	hashRes := fmt.Sprintf("%x", sha256.Sum256([]byte(res)))
-	return hashRes, nil
+// Sum - finds all the numbers selected throughout a valid (json) document and adds them together and hashes the expectedHash.
+func (om OperationManager) Sum(ctx context.Context, data interface{}, opts Options) (*SumResult, error) {
+	ctx, span := trace.Start(ctx, "OperationManager.Sum", trace.KindInternal, trace.Attr("sum.options", opts.String()))
+	defer span.End()
+	lg := om.logger(ctx)
+	res := &SumResult{}
+	var key string
+	if om.cache != nil {
+		digest, err := canonicalDigest(ctx, data)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		res.Digest, key = digest, "sum:"+cacheScope(ctx)+":"+digest+"?"+opts.String()
+		if hash, ok := om.cache.GetResult(key); ok {
+			lg.Debug("sum served from cache", logger.F("digest", digest))
+			span.SetAttributes(trace.Attr("sum.cached", true))
+			res.Hash, res.Cached = hash, true
+			return res, nil
+		}
+	}
+	span.SetAttributes(trace.Attr("sum.cached", false), trace.Attr("sum.workers", om.workers))
+	start := time.Now()
+	sumRes, err := om.sum(ctx, data, opts)
+	if err != nil {
+		if ctx.Err() != nil {
+			lg.Info("sum stopped", logger.F("reason", ctx.Err()), logger.F("elapsed", time.Since(start)))
+		}
+		span.RecordError(err)
+		return nil, err
+	}
+	lg.Debug("sum computed", logger.F("duration", time.Since(start)), logger.F("workers", om.workers))
+	res.Hash = hashSum(sumRes)
+	if om.cache != nil {
+		om.cache.SetResult(key, res.Hash)
+	}
+	return res, nil
 }
 
-// getSum - finds all the integers in a json structure and adds
+// cacheScope - the subject of the request in ctx, empty for computations not made on behalf of one.
+func cacheScope(ctx context.Context) string {
+	if c, ok := auth.FromContext(ctx); ok {
+		return c.Subject
+	}
+	return ""
+}
+
+// logger - the logger of the request in ctx if any, the one of the service otherwise.
+func (om OperationManager) logger(ctx context.Context) logger.Logger {
+	if om.log == nil {
+		return logger.FromContext(ctx, logger.Nop())
+	}
+	return logger.FromContext(ctx, om.log)
+}
+
+// sum - adds the selected numbers walking in parallel when configured so.
+func (om OperationManager) sum(ctx context.Context, data interface{}, opts Options) (int, error) {
+	if om.workers > 1 {
+		return parallelSum(ctx, data, opts, om.workers, om.threshold)
+	}
+	return getSum(ctx, data, opts)
+}
+
+// hashSum - SHA256 of the decimal representation of the sum.
+func hashSum(sum int) string {
+	res := strconv.Itoa(sum)
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(res)))
+}
+
+// getSum - finds all the selected integers in a json structure and adds
 // reason to use float64: https://golang.org/pkg/encoding/json/#Unmarshal same with decode
-func getSum(data interface{}) int {
+func getSum(ctx context.Context, data interface{}, opts Options) (int, error) {
 	var sum = 0
-	switch data := data.(type) {
-	case []interface{}:
-		for _, v := range data {
-			sum += getSum(v)
-		}
-	case map[string]interface{}:
-		for _, v := range data {
-			sum += getSum(v)
-		}
-	case float64:
-		toInt := int(data)
-		sum += toInt
-	default:
-		break
+	err := walkSelection(ctx, data, opts, func(n float64) {
+		sum += int(n)
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	return sum
+	return sum, nil
 }
 
 // AuthManager -
 type AuthManager struct {
-	// note add logger
 	AuthOps auth.Operations
 	Storage storage.ManageUsers
+	Log     logger.Logger
+	Metrics Metrics
 }
 
-func NewAuthService(auth auth.Operations, storage storage.ManageUsers) *AuthManager {
+// NewAuthService - auth service constructor, lg nil discards the logs and mt nil the measurements.
+func NewAuthService(auth auth.Operations, storage storage.ManageUsers, lg logger.Logger, mt Metrics) *AuthManager {
+	if lg == nil {
+		lg = logger.Nop()
+	}
+	if mt == nil {
+		mt = NopMetrics()
+	}
 	return &AuthManager{
 		AuthOps: auth,
 		Storage: storage,
+		Log:     lg,
+		Metrics: mt,
 	}
 }
 
 type Authorizer interface {
-	CreateAuth(usr user.User) (string, error)
+	CreateAuth(ctx context.Context, usr user.User, cnf auth.Confirmation) (string, error)
 }
 
-func (am AuthManager) CreateAuth(usr user.User) (string, error) {
+// CreateAuth - issues a token for the user, bound to the key of cnf when it is not the zero value.
+func (am AuthManager) CreateAuth(ctx context.Context, usr user.User, cnf auth.Confirmation) (string, error) {
+	ctx, span := trace.Start(ctx, "AuthManager.CreateAuth", trace.KindInternal)
+	defer span.End()
+	lg := am.Log
+	if lg == nil {
+		lg = logger.Nop()
+	}
+	lg = logger.FromContext(ctx, lg)
 	if err := usr.ValidateUser(); err != nil {
-		return "", fmt.Errorf("error validating user: %s", err.Error())
+		lg.Info("invalid user", logger.F("reason", err))
+		span.RecordError(ErrInvalidUser)
+		return "", fmt.Errorf("%w: %s", ErrInvalidUser, err.Error())
 	}
-	jwt, err := am.AuthOps.CreateJWT(usr)
+	lg = lg.With(logger.F("subject", usr.UserName))
+	span.SetAttributes(trace.Attr("enduser.id", usr.UserName))
+	jwt, err := am.AuthOps.CreateJWT(ctx, usr, cnf)
 	if err != nil {
-		return "", fmt.Errorf("error creting JWT: %s", err.Error())
+		lg.Error("unable to create token", logger.F("error", err))
+		span.RecordError(err)
+		return "", fmt.Errorf("error creting JWT: %w", err)
+	}
+	// note a token that could not be stored would never be active, so it is not handed out.
+	if err := am.Storage.AddUserToken(ctx, jwt); err != nil {
+		lg.Error("unable to store token", logger.F("error", err))
+		span.RecordError(err)
+		return "", fmt.Errorf("error storing JWT: %w", err)
 	}
-	if !am.Storage.AddUserToken(jwt) {
-		// note different ways to handle it, as right now for simplicity just log...
-		return jwt, nil
+	lg.Info("token issued", logger.F("bound", cnf.Bound()))
+	if am.Metrics != nil {
+		am.Metrics.TokenIssued()
 	}
 
 	return jwt, nil
@@ -1,9 +1,16 @@
 package service
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/qredo-external/go-rnov/pkg/auth"
 	"github.com/qredo-external/go-rnov/pkg/storage"
@@ -12,17 +19,17 @@ import (
 
 type authOperationsMock struct {
 	createJWT   func(usr user.User) (string, error)
-	validateJWT func(JWT string) error
+	validateJWT func(JWT string) (*auth.Claims, error)
 }
 
-func (a authOperationsMock) CreateJWT(usr user.User) (string, error) {
+func (a authOperationsMock) CreateJWT(ctx context.Context, usr user.User, cnf auth.Confirmation) (string, error) {
 	if a.createJWT != nil {
 		return a.createJWT(usr)
 	}
 	panic("implement me")
 }
 
-func (a authOperationsMock) ValidateJWT(JWT string) error {
+func (a authOperationsMock) ValidateJWT(ctx context.Context, JWT string) (*auth.Claims, error) {
 	if a.validateJWT != nil {
 		return a.validateJWT(JWT)
 	}
@@ -30,24 +37,45 @@ func (a authOperationsMock) ValidateJWT(JWT string) error {
 }
 
 type manageUsersMock struct {
-	addUserToken  func(token string) bool
-	isActiveToken func(token string) bool
+	addUserToken  func(token string) error
+	isActiveToken func(token string) (bool, error)
 }
 
-func (m manageUsersMock) AddUserToken(token string) bool {
+func (m manageUsersMock) AddUserToken(ctx context.Context, token string) error {
 	if m.addUserToken != nil {
 		return m.addUserToken(token)
 	}
 	panic("implement me")
 }
 
-func (m manageUsersMock) IsActiveToken(token string) bool {
+func (m manageUsersMock) IsActiveToken(ctx context.Context, token string) (bool, error) {
 	if m.isActiveToken != nil {
 		return m.isActiveToken(token)
 	}
 	panic("implement me")
 }
 
+// metricsMock - counts the tokens issued, any other measurement is unexpected.
+type metricsMock struct {
+	issued int
+}
+
+func (m *metricsMock) TokenIssued() {
+	m.issued++
+}
+
+func (m *metricsMock) TokenValidated() {
+	panic("implement me")
+}
+
+func (m *metricsMock) TokenRejected(reason string) {
+	panic("implement me")
+}
+
+func (m *metricsMock) SumComputed(size int, d time.Duration, cached bool) {
+	panic("implement me")
+}
+
 func TestOperationManager_Sum(t *testing.T) {
 	tests := []struct {
 		input        []byte
@@ -94,12 +122,12 @@ func TestOperationManager_Sum(t *testing.T) {
 				t.Errorf("error unmarshaling body: %s", err.Error())
 			}
 			os := NewOperationsService()
-			res, err := os.Sum(jsonMap)
+			res, err := os.Sum(context.Background(), jsonMap, Options{})
 			if err != nil {
 				t.Errorf("non-nil error : %s", err.Error())
 			}
-			if test.expectedHash != res {
-				t.Errorf("error in expectedHash value: expected %s got %s", test.expectedHash, res)
+			if test.expectedHash != res.Hash {
+				t.Errorf("error in expectedHash value: expected %s got %s", test.expectedHash, res.Hash)
 			}
 		})
 	}
@@ -108,6 +136,7 @@ func TestOperationManager_Sum(t *testing.T) {
 func Test_getSum(t *testing.T) {
 	tests := []struct {
 		input          []byte
+		opts           Options
 		ExpectedResult int
 	}{
 		{
@@ -134,6 +163,66 @@ func Test_getSum(t *testing.T) {
 		{
 			input: json.RawMessage(`[]`), ExpectedResult: 0,
 		},
+		{
+			input: json.RawMessage(`{"items":[{"price":3,"qty":2},{"price":4,"qty":1}],"total":7}`),
+			opts:  Options{Path: "$.items[*].price"}, ExpectedResult: 7,
+		},
+		{
+			input: json.RawMessage(`{"a":{"price":1,"b":{"price":2}},"price":[4,5]}`),
+			opts:  Options{Path: "$..price"}, ExpectedResult: 12,
+		},
+		{
+			input: json.RawMessage(`{"a":{"a":{"b":1},"b":2}}`),
+			opts:  Options{Path: "$..a..b"}, ExpectedResult: 3,
+		},
+		{
+			input: json.RawMessage(`{"a":[1,2,3,4,5],"b":{"c d":10}}`),
+			opts:  Options{Path: "$['a'][1:4:2]"}, ExpectedResult: 6,
+		},
+		{
+			input: json.RawMessage(`{"a":[1,2,3,4,5],"b":{"c d":10}}`),
+			opts:  Options{Path: `$.a[-1,0]`}, ExpectedResult: 6,
+		},
+		{
+			input: json.RawMessage(`{"a":[1,2,3,4,5],"b":{"c d":10}}`),
+			opts:  Options{Path: `$.b["c d"]`}, ExpectedResult: 10,
+		},
+		{
+			input: json.RawMessage(`{"a":[1,2,3,4,5],"b/c":{"d":10},"e~":1}`),
+			opts:  Options{Pointers: []string{"/a/4", "/b~1c", "/e~0"}}, ExpectedResult: 16,
+		},
+		{
+			input: json.RawMessage(`{"items":[{"price":3,"qty":2},{"price":4,"qty":1}],"total":7}`),
+			opts:  Options{Exclude: []string{"qty", "total"}}, ExpectedResult: 7,
+		},
+		{
+			input: json.RawMessage(`{"items":[{"price":3,"qty":2},{"price":4,"qty":1}],"total":7}`),
+			opts:  Options{Include: []string{"items"}, Exclude: []string{"price"}}, ExpectedResult: 3,
+		},
+		{
+			input: json.RawMessage(overlapping),
+			opts:  Options{Path: "$..*"}, ExpectedResult: 10,
+		},
+		{
+			input: json.RawMessage(overlapping),
+			opts:  Options{Path: "$..[*]"}, ExpectedResult: 10,
+		},
+		{
+			input: json.RawMessage(overlapping),
+			opts:  Options{Path: "$['a','a']"}, ExpectedResult: 3,
+		},
+		{
+			input: json.RawMessage(overlapping),
+			opts:  Options{Path: "$.items[0,0,1]"}, ExpectedResult: 7,
+		},
+		{
+			input: json.RawMessage(overlapping),
+			opts:  Options{Pointers: []string{"", "/a"}}, ExpectedResult: 10,
+		},
+		{
+			input: json.RawMessage(overlapping),
+			opts:  Options{Pointers: []string{"/a/c/d", "/a", "/items/1", "/items/1/price"}}, ExpectedResult: 7,
+		},
 	}
 
 	var jsonMap interface{}
@@ -142,7 +231,10 @@ func Test_getSum(t *testing.T) {
 			if err := json.Unmarshal(test.input, &jsonMap); err != nil {
 				t.Errorf("error unmarshaling body: %s", err.Error())
 			}
-			res := getSum(jsonMap)
+			res, err := getSum(context.Background(), jsonMap, test.opts)
+			if err != nil {
+				t.Errorf("non-nil error : %s", err.Error())
+			}
 			if test.ExpectedResult != res {
 				t.Errorf("error in expectedHash value: expected %d got %d", test.ExpectedResult, res)
 			}
@@ -150,6 +242,347 @@ func Test_getSum(t *testing.T) {
 	}
 }
 
+// overlapping - document whose numbers add up to 10, selections overlapping in it must not count one twice.
+const overlapping = `{"a":{"b":1,"c":{"d":2}},"items":[{"price":3},{"price":4}]}`
+
+func TestOperationManager_OverlappingSelection(t *testing.T) {
+	var jsonMap interface{}
+	if err := json.Unmarshal([]byte(overlapping), &jsonMap); err != nil {
+		t.Fatalf("error unmarshaling body: %s", err.Error())
+	}
+	for _, opts := range []Options{{Path: "$..*"}, {Path: "$..[*]"}, {Pointers: []string{"", "/a", "/a/c/d"}}} {
+		t.Run(opts.String(), func(t *testing.T) {
+			expected, _ := NewOperationsService(WithParallelism(1, 0)).Sum(context.Background(), jsonMap, Options{})
+			if res, err := NewOperationsService(WithParallelism(4, 1)).Sum(context.Background(), jsonMap, opts); err != nil || res.Hash != expected.Hash {
+				t.Errorf("expected the parallel sum to count each number once got %+v %v", res, err)
+			}
+			if agg, err := NewOperationsService().Aggregate(context.Background(), "count", jsonMap, opts); err != nil || agg.Value != float64(4) {
+				t.Errorf("expected 4 numbers got %+v %v", agg, err)
+			}
+			exp, err := NewOperationsService().Explain(context.Background(), jsonMap, opts)
+			if err != nil {
+				t.Fatalf("non-nil error : %s", err.Error())
+			}
+			if len(exp.Numbers) != 4 || exp.Sum != 10 {
+				t.Errorf("expected each number explained once got %+v", exp.Numbers)
+			}
+		})
+	}
+}
+
+func Test_getSumSelectionErrors(t *testing.T) {
+	tests := []struct {
+		opts        Options
+		expectedErr error
+	}{
+		{opts: Options{Path: "items"}, expectedErr: ErrInvalidSelection},
+		{opts: Options{Path: "$.items[?(@.price)]"}, expectedErr: ErrInvalidSelection},
+		{opts: Options{Path: "$.items[0"}, expectedErr: ErrInvalidSelection},
+		{opts: Options{Path: "$.a", Pointers: []string{"/a"}}, expectedErr: ErrInvalidSelection},
+		{opts: Options{Pointers: []string{"a"}}, expectedErr: ErrInvalidSelection},
+		{opts: Options{Pointers: []string{"/items/01"}}, expectedErr: ErrInvalidSelection},
+		{opts: Options{Pointers: []string{"/missing"}}, expectedErr: ErrSelectionNotFound},
+		{opts: Options{Pointers: []string{"/items/5"}}, expectedErr: ErrSelectionNotFound},
+	}
+
+	var jsonMap interface{}
+	if err := json.Unmarshal([]byte(`{"items":[1,2]}`), &jsonMap); err != nil {
+		t.Fatalf("error unmarshaling body: %s", err.Error())
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("generic test %d", i+1), func(t *testing.T) {
+			if _, err := getSum(context.Background(), jsonMap, test.opts); !errors.Is(err, test.expectedErr) {
+				t.Errorf("expected error %v got %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func Test_numericString(t *testing.T) {
+	tests := []struct {
+		input     string
+		expected  float64
+		ok        bool
+		ambiguous bool
+	}{
+		{input: "12", expected: 12, ok: true},
+		{input: "-12.5", expected: -12.5, ok: true},
+		{input: "+1.5e3", expected: 1500, ok: true},
+		{input: "2E-2", expected: 0.02, ok: true},
+		{input: "0x1F", expected: 31, ok: true},
+		{input: "-0X1f", expected: -31, ok: true},
+		{input: "0", expected: 0, ok: true},
+		{input: "1,000", ambiguous: true},
+		{input: "1.000,5", ambiguous: true},
+		{input: "1.000.000", ambiguous: true},
+		{input: "1_000", ambiguous: true},
+		{input: " 12 ", ambiguous: true},
+		{input: "012", ambiguous: true},
+		{input: ".5", ambiguous: true},
+		{input: "5.", ambiguous: true},
+		{input: "1e400", ambiguous: true},
+		{input: "dark"},
+		{input: "2021-03-01"},
+		{input: "0x"},
+		{input: "NaN"},
+		{input: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			n, ok, ambiguous := numericString(test.input)
+			if n != test.expected || ok != test.ok || ambiguous != test.ambiguous {
+				t.Errorf("expected (%v, %t, %t) got (%v, %t, %t)", test.expected, test.ok, test.ambiguous, n, ok, ambiguous)
+			}
+		})
+	}
+}
+
+func Test_getSumNumericStrings(t *testing.T) {
+	tests := []struct {
+		mode           string
+		ExpectedResult int
+		expectedErr    error
+	}{
+		{mode: NumericStringsOff, ExpectedResult: 1},
+		{mode: NumericStringsLenient, ExpectedResult: 44},
+		{mode: NumericStringsStrict, expectedErr: ErrAmbiguousNumber},
+		{mode: "loose", expectedErr: ErrInvalidOptions},
+	}
+
+	var jsonMap interface{}
+	if err := json.Unmarshal([]byte(`[1,"12","0x1F","1,000","dark"]`), &jsonMap); err != nil {
+		t.Fatalf("error unmarshaling body: %s", err.Error())
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("mode %q", test.mode), func(t *testing.T) {
+			res, err := getSum(context.Background(), jsonMap, Options{NumericStrings: test.mode})
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error %v got %v", test.expectedErr, err)
+			}
+			if res != test.ExpectedResult {
+				t.Errorf("error in result: expected %d got %d", test.ExpectedResult, res)
+			}
+		})
+	}
+}
+
+// bigDocument - array of n objects each holding a few numbers, strings and a nested array.
+func bigDocument(n int) interface{} {
+	doc := make([]interface{}, n)
+	for i := range doc {
+		doc[i] = map[string]interface{}{
+			"id":    float64(i),
+			"price": float64(i%97) - 48.5,
+			"name":  "item",
+			"tags":  []interface{}{float64(i % 7), "12", nil},
+		}
+	}
+	return doc
+}
+
+func TestOperationManager_SumParallel(t *testing.T) {
+	doc := bigDocument(20000)
+	wide := map[string]interface{}{"items": doc, "meta": map[string]interface{}{"total": float64(3)}}
+	tests := []Options{
+		{},
+		{Exclude: []string{"id"}},
+		{Include: []string{"tags"}},
+		{Path: "$.items[*].price"},
+		{NumericStrings: NumericStringsLenient},
+	}
+
+	for i, opts := range tests {
+		t.Run(fmt.Sprintf("generic test %d", i+1), func(t *testing.T) {
+			expected, err := NewOperationsService(WithParallelism(1, 0)).Sum(context.Background(), wide, opts)
+			if err != nil {
+				t.Fatalf("non-nil error : %s", err.Error())
+			}
+			for _, workers := range []int{2, 4, 16} {
+				res, err := NewOperationsService(WithParallelism(workers, 100)).Sum(context.Background(), wide, opts)
+				if err != nil {
+					t.Fatalf("non-nil error : %s", err.Error())
+				}
+				if res.Hash != expected.Hash {
+					t.Errorf("error in hash with %d workers: expected %s got %s", workers, expected.Hash, res.Hash)
+				}
+			}
+		})
+	}
+}
+
+func TestOperationManager_SumCanceled(t *testing.T) {
+	doc := bigDocument(20000)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, workers := range []int{1, 4} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			om := NewOperationsService(WithParallelism(workers, 100))
+			if _, err := om.Sum(ctx, doc, Options{}); !errors.Is(err, context.Canceled) {
+				t.Errorf("expected canceled sum got %v", err)
+			}
+			if _, err := om.Aggregate(ctx, "count", doc, Options{}); !errors.Is(err, context.Canceled) {
+				t.Errorf("expected canceled aggregation got %v", err)
+			}
+			if _, err := om.Explain(ctx, doc, Options{}); !errors.Is(err, context.Canceled) {
+				t.Errorf("expected canceled explanation got %v", err)
+			}
+			if _, err := om.Sum(ctx, doc, Options{Path: "$..*..*"}); !errors.Is(err, context.Canceled) {
+				t.Errorf("expected canceled path evaluation got %v", err)
+			}
+		})
+	}
+}
+
+// checkedContext - context that counts how many times it was checked.
+type checkedContext struct {
+	context.Context
+	checks int32
+}
+
+func (c *checkedContext) Err() error {
+	atomic.AddInt32(&c.checks, 1)
+	return c.Context.Err()
+}
+
+func TestParallelSum_Canceled(t *testing.T) {
+	doc := map[string]interface{}{"items": bigDocument(20000), "more": bigDocument(20000)}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	ctx := &checkedContext{Context: canceled}
+
+	if _, err := parallelSum(ctx, doc, Options{}, 4, 100); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled sum got %v", err)
+	}
+	// note a walk that kept going would check the context every checkEvery values of each of its goroutines
+	if checks := atomic.LoadInt32(&ctx.checks); checks > 8 {
+		t.Errorf("expected the walk to stop at the first checks got %d checks", checks)
+	}
+	if _, err := canonicalDigest(canceled, doc); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected canceled digest got %v", err)
+	}
+}
+
+func benchmarkSum(b *testing.B, workers int) {
+	doc := bigDocument(1 << 18)
+	om := NewOperationsService(WithParallelism(workers, 0))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := om.Sum(context.Background(), doc, Options{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSum_Sequential(b *testing.B) { benchmarkSum(b, 1) }
+
+func BenchmarkSum_Parallel(b *testing.B) { benchmarkSum(b, 0) }
+
+func Test_canonicalize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			// note RFC 8785 section 3.2.2 example
+			input:    `{"numbers":[333333333.33333329,1E30,4.50,2e-3,0.000000000000000000000000001],"string":"\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/","literals":[null,true,false]}`,
+			expected: `{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],"string":"€$\u000f\nA'B\"\\\\\"/"}`,
+		},
+		{
+			// note RFC 8785 section 3.2.3 example, members sorted by UTF-16 code units
+			input:    `{"\u20ac":"Euro Sign","\r":"Carriage Return","\ufb33":"Hebrew Letter Dalet With Dagesh","1":"One","\ud83d\ude00":"Emoji: Grinning Face","\u0080":"Control","\u00f6":"Latin Small Letter O With Diaeresis"}`,
+			expected: "{\"\\r\":\"Carriage Return\",\"1\":\"One\",\"\u0080\":\"Control\",\"\u00f6\":\"Latin Small Letter O With Diaeresis\",\"\u20ac\":\"Euro Sign\",\"\U0001F600\":\"Emoji: Grinning Face\",\"\ufb33\":\"Hebrew Letter Dalet With Dagesh\"}",
+		},
+		{input: `[-0, 1e21, 1e-7, 123456789012345680000, "<&>\u2028"]`, expected: "[0,1e+21,1e-7,123456789012345680000,\"<&>\u2028\"]"},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("generic test %d", i+1), func(t *testing.T) {
+			var jsonMap interface{}
+			if err := json.Unmarshal([]byte(test.input), &jsonMap); err != nil {
+				t.Fatalf("error unmarshaling body: %s", err.Error())
+			}
+			var buf bytes.Buffer
+			if err := canonicalize(context.Background(), &buf, jsonMap); err != nil {
+				t.Fatalf("non-nil error : %s", err.Error())
+			}
+			if buf.String() != test.expected {
+				t.Errorf("expected %q got %q", test.expected, buf.String())
+			}
+		})
+	}
+}
+
+func TestOperationManager_SumCache(t *testing.T) {
+	cache := storage.NewResultLRU(10, time.Minute)
+	om := NewOperationsService(WithCache(cache))
+	docs := []string{`{"a":1,"b":[2.0,3]}`, `{ "b": [2, 3e0], "a": 1 }`}
+	var digest string
+	for i, doc := range docs {
+		var jsonMap interface{}
+		if err := json.Unmarshal([]byte(doc), &jsonMap); err != nil {
+			t.Fatalf("error unmarshaling body: %s", err.Error())
+		}
+		res, err := om.Sum(context.Background(), jsonMap, Options{})
+		if err != nil {
+			t.Fatalf("non-nil error : %s", err.Error())
+		}
+		if res.Cached != (i > 0) {
+			t.Errorf("document %d: expected cached %t got %t", i, i > 0, res.Cached)
+		}
+		if digest != "" && res.Digest != digest {
+			t.Errorf("equivalent documents with different digests %s and %s", digest, res.Digest)
+		}
+		digest = res.Digest
+		if res.Hash != "e7f6c011776e8db7cd330b54174fd76f7d0216b612387a5ffcfb81e6f0919683" {
+			t.Errorf("error in hash value got %s", res.Hash)
+		}
+		// note other options are a different entry
+		if res, _ := om.Sum(context.Background(), jsonMap, Options{Exclude: []string{"a"}}); res.Cached != (i > 0) {
+			t.Errorf("document %d with options: expected cached %t got %t", i, i > 0, res.Cached)
+		}
+	}
+
+	// note entries are kept per subject, another one does not learn the document was summed
+	ctx := auth.NewContext(context.Background(), &auth.Claims{Subject: "another"})
+	if res, err := om.Sum(ctx, map[string]interface{}{"a": 1.0, "b": []interface{}{2.0, 3.0}}, Options{}); err != nil || res.Cached {
+		t.Errorf("expected the result of another subject not to be cached got %+v %v", res, err)
+	}
+}
+
+func TestOperationManager_Explain(t *testing.T) {
+	var jsonMap interface{}
+	input := `{"b":[1.5,"dark",null],"a":{"c":-3,"d":true},"e/f":4,"skip":[100]}`
+	if err := json.Unmarshal([]byte(input), &jsonMap); err != nil {
+		t.Fatalf("error unmarshaling body: %s", err.Error())
+	}
+	res, err := NewOperationsService().Explain(context.Background(), jsonMap, Options{Exclude: []string{"skip"}})
+	if err != nil {
+		t.Fatalf("non-nil error : %s", err.Error())
+	}
+	expectedNumbers := []Contribution{
+		{Pointer: "/a/c", Value: -3, Added: -3, Total: -3},
+		{Pointer: "/b/0", Value: 1.5, Added: 1, Total: -2},
+		{Pointer: "/e~1f", Value: 4, Added: 4, Total: 2},
+	}
+	expectedIgnored := []Ignored{
+		{Pointer: "/a/d", Value: true, Reason: reasonBool},
+		{Pointer: "/b/1", Value: "dark", Reason: reasonString},
+		{Pointer: "/b/2", Reason: reasonNull},
+		{Pointer: "/skip", Reason: reasonExcluded},
+	}
+	if !reflect.DeepEqual(res.Numbers, expectedNumbers) {
+		t.Errorf("error in numbers: expected %+v got %+v", expectedNumbers, res.Numbers)
+	}
+	if !reflect.DeepEqual(res.Ignored, expectedIgnored) {
+		t.Errorf("error in ignored: expected %+v got %+v", expectedIgnored, res.Ignored)
+	}
+	if sum, _ := NewOperationsService().Sum(context.Background(), jsonMap, Options{Exclude: []string{"skip"}}); res.Sum != 2 || res.Hash != sum.Hash {
+		t.Errorf("error in result: expected 2 (%s) got %d (%s)", sum.Hash, res.Sum, res.Hash)
+	}
+}
+
 func TestAuthManager_CreateAuth(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -171,8 +604,8 @@ func TestAuthManager_CreateAuth(t *testing.T) {
 				},
 			},
 			storage: manageUsersMock{
-				addUserToken: func(token string) bool {
-					return true
+				addUserToken: func(token string) error {
+					return nil
 				},
 			},
 			expectedResult: "aValidToken",
@@ -183,20 +616,433 @@ func TestAuthManager_CreateAuth(t *testing.T) {
 				UserName: "qwerty",
 				Password: "",
 			},
-			expectedErr: fmt.Errorf("error validating user: invalid user data, empty fields"),
+			expectedErr: ErrInvalidUser,
+		},
+		{
+			name: "error - storage unavailable",
+			usr: user.User{
+				UserName: "qwerty",
+				Password: "mnbvc",
+			},
+			authOp: authOperationsMock{
+				createJWT: func(usr user.User) (string, error) {
+					return "aValidToken", nil
+				},
+			},
+			storage: manageUsersMock{
+				addUserToken: func(token string) error {
+					return fmt.Errorf("%w: connection refused", storage.ErrUnavailable)
+				},
+			},
+			expectedErr: storage.ErrUnavailable,
 		},
 	}
 
 	for i, test := range tests {
 		t.Run(fmt.Sprintf("generic test %d", i+1), func(t *testing.T) {
-			ah := NewAuthService(test.authOp, test.storage)
-			res, err := ah.CreateAuth(test.usr)
-			if err != nil && err.Error() != test.expectedErr.Error() {
+			mt := &metricsMock{}
+			ah := NewAuthService(test.authOp, test.storage, nil, mt)
+			res, err := ah.CreateAuth(context.Background(), test.usr, auth.Confirmation{})
+			if !errors.Is(err, test.expectedErr) {
 				t.Errorf("expected: '%s' instead got: '%s'", test.expectedErr, err)
 			}
+			if expectedIssued := map[bool]int{true: 1}[test.expectedErr == nil]; mt.issued != expectedIssued {
+				t.Errorf("error in tokens issued: expected %d got %d", expectedIssued, mt.issued)
+			}
 			if test.expectedResult != res {
 				t.Errorf("error in expectedHash value: expected %s got %s", test.expectedResult, res)
 			}
 		})
 	}
 }
+
+func TestReceiptManager_IssueReceipt(t *testing.T) {
+	a := auth.NewAuth("aSecret", time.Minute)
+	doc := []byte(`[1,2,3,4]`)
+	tests := []struct {
+		name        string
+		subject     string
+		verifyWith  *auth.Auth
+		expectedErr bool
+	}{
+		{
+			name:       "successful issue and verify",
+			subject:    "qwerty",
+			verifyWith: a,
+		},
+		{
+			name:        "error - missing subject",
+			expectedErr: true,
+		},
+		{
+			name:        "error - verified with a different key",
+			subject:     "qwerty",
+			verifyWith:  auth.NewAuth("anotherSecret", time.Minute),
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rm := NewReceiptService(a)
+			res, err := rm.IssueReceipt(auth.Receipt{Subject: test.subject, Digest: auth.DocumentDigest(doc), Result: "aHash"})
+			if err == nil {
+				_, err = NewReceiptService(test.verifyWith).VerifyReceipt(res)
+			}
+			if (err != nil) != test.expectedErr {
+				t.Fatalf("expected error %t got: %v", test.expectedErr, err)
+			}
+			if test.expectedErr {
+				return
+			}
+			rc, _ := rm.VerifyReceipt(res)
+			if rc.Subject != test.subject || rc.Result != "aHash" || !rc.Covers(doc) {
+				t.Errorf("unexpected receipt content: %+v", rc)
+			}
+			if _, err := a.ValidateJWT(context.Background(), res); err == nil {
+				t.Error("receipt must not be accepted as an access token")
+			}
+		})
+	}
+}
+
+func TestOperationManager_Aggregate(t *testing.T) {
+	tests := []struct {
+		op            string
+		input         []byte
+		expectedValue interface{}
+		expectedHash  string
+		expectedErr   error
+	}{
+		{
+			op: "count", input: json.RawMessage(`{"a":[-1,1,"dark"],"b":2.5}`), expectedValue: float64(3),
+			expectedHash: "4e07408562bedb8b60ce05c1decfe3ad16b72230967de01f640b7e4729b49fce",
+		},
+		{op: "min", input: json.RawMessage(`[[[2]],-3.5,{"a":7}]`), expectedValue: -3.5},
+		{op: "max", input: json.RawMessage(`[[[2]],-3.5,{"a":7}]`), expectedValue: float64(7)},
+		{op: "mean", input: json.RawMessage(`[1,2,3,4]`), expectedValue: 2.5},
+		{op: "product", input: json.RawMessage(`{"a":{"b":4},"c":-2}`), expectedValue: float64(-8)},
+		{op: "product", input: json.RawMessage(`[]`), expectedValue: float64(1)},
+		{
+			op: "histogram", input: json.RawMessage(`[1,1.5,2,-0.5]`),
+			expectedValue: map[string]int{"-1": 1, "1": 2, "2": 1},
+		},
+		{op: "min", input: json.RawMessage(`{"a":"dark"}`), expectedErr: ErrNoNumbers},
+		{op: "mean", input: json.RawMessage(`[]`), expectedErr: ErrNoNumbers},
+		{op: "product", input: json.RawMessage(`[1e300,1e300]`), expectedErr: ErrOutOfRange},
+		{op: "median", input: json.RawMessage(`[1]`), expectedErr: ErrUnknownOperation},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%s test %d", test.op, i+1), func(t *testing.T) {
+			var jsonMap interface{}
+			if err := json.Unmarshal(test.input, &jsonMap); err != nil {
+				t.Errorf("error unmarshaling body: %s", err.Error())
+			}
+			res, err := NewOperationsService().Aggregate(context.Background(), test.op, jsonMap, Options{})
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error %v got %v", test.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(res.Value, test.expectedValue) {
+				t.Errorf("error in value: expected %v got %v", test.expectedValue, res.Value)
+			}
+			if test.expectedHash != "" && res.Hash != test.expectedHash {
+				t.Errorf("error in hash value: expected %s got %s", test.expectedHash, res.Hash)
+			}
+		})
+	}
+}
+
+type operationsMock struct {
+	sum func(ctx context.Context, data interface{}, opts Options) (*SumResult, error)
+}
+
+func (o operationsMock) Sum(ctx context.Context, data interface{}, opts Options) (*SumResult, error) {
+	if o.sum != nil {
+		return o.sum(ctx, data, opts)
+	}
+	panic("implement me")
+}
+
+func (o operationsMock) Aggregate(ctx context.Context, op string, data interface{}, opts Options) (*Aggregation, error) {
+	panic("implement me")
+}
+
+func (o operationsMock) Explain(ctx context.Context, data interface{}, opts Options) (*Explanation, error) {
+	panic("implement me")
+}
+
+// waitJob - polls the job until it reaches a final status.
+func waitJob(t *testing.T, jm *JobManager, owner, id string) storage.Job {
+	for i := 0; i < 100; i++ {
+		job, err := jm.GetJob(owner, id)
+		if err != nil {
+			t.Fatalf("non-nil error : %s", err.Error())
+		}
+		if job.Done() {
+			return job
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Fatal("job did not finish")
+	return storage.Job{}
+}
+
+func TestJobManager(t *testing.T) {
+	ops := operationsMock{
+		sum: func(ctx context.Context, data interface{}, opts Options) (*SumResult, error) {
+			if data == "block" {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			if data == "fail" {
+				return nil, errors.New("sum failed")
+			}
+			return &SumResult{Hash: "aHash"}, nil
+		},
+	}
+	hm := NewHistoryService(storage.NewHistory(0))
+	qm := NewQuotaService(storage.NewUsages(), Quota{Period: QuotaDaily})
+	jm := NewJobService(ops, storage.NewJobs(0), hm, qm, 1, 1, nil)
+	defer jm.Close()
+
+	ok, err := jm.SubmitSum(context.Background(), "qwerty", []byte("ok"), "ok", Options{})
+	if err != nil || ok.Status != storage.JobQueued {
+		t.Fatalf("expected queued job got %+v %v", ok, err)
+	}
+	if job := waitJob(t, jm, "qwerty", ok.ID); job.Status != storage.JobSucceeded || job.Result != "aHash" {
+		t.Errorf("expected succeeded job got %+v", job)
+	}
+	if page, _ := hm.ListComputations("qwerty", 0, 0); page.Total != 1 ||
+		page.Computations[0].Operation != OperationSum || page.Computations[0].Result != "aHash" || page.Computations[0].Size != 2 {
+		t.Errorf("expected the sum of the job in the history got %+v", page)
+	}
+	if _, err := jm.GetJob("another", ok.ID); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("expected job of another owner not to be found got %v", err)
+	}
+	if _, err := jm.CancelJob("qwerty", ok.ID); !errors.Is(err, ErrJobFinished) {
+		t.Errorf("expected finished job not to be canceled got %v", err)
+	}
+
+	failed, _ := jm.SubmitSum(context.Background(), "qwerty", []byte("fail"), "fail", Options{})
+	if job := waitJob(t, jm, "qwerty", failed.ID); job.Status != storage.JobFailed || job.Error != "sum failed" {
+		t.Errorf("expected failed job got %+v", job)
+	}
+
+	// note the only worker is blocked by the first job until it is canceled and the second fills the queue
+	blocked, _ := jm.SubmitSum(context.Background(), "qwerty", []byte("block"), "block", Options{})
+	for job, _ := jm.GetJob("qwerty", blocked.ID); job.Status != storage.JobRunning; job, _ = jm.GetJob("qwerty", blocked.ID) {
+		time.Sleep(time.Millisecond)
+	}
+	queued, _ := jm.SubmitSum(context.Background(), "qwerty", []byte("ok"), "ok", Options{})
+	if _, err := jm.SubmitSum(context.Background(), "qwerty", []byte("ok"), "ok", Options{}); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected full queue got %v", err)
+	}
+	if job, err := jm.CancelJob("qwerty", queued.ID); err != nil || job.Status != storage.JobCanceled {
+		t.Errorf("expected canceled job got %+v %v", job, err)
+	}
+	if _, err := jm.CancelJob("qwerty", blocked.ID); err != nil {
+		t.Errorf("non-nil error : %s", err.Error())
+	}
+	jm.Close()
+	for _, id := range []string{queued.ID, blocked.ID} {
+		if job, _ := jm.GetJob("qwerty", id); job.Status != storage.JobCanceled || job.Result != "" {
+			t.Errorf("expected canceled job without result got %+v", job)
+		}
+	}
+	if _, err := jm.SubmitSum(context.Background(), "qwerty", []byte("ok"), "ok", Options{}); !errors.Is(err, ErrJobsClosed) {
+		t.Errorf("expected closed service got %v", err)
+	}
+	// note only the job that succeeded is counted, the others gave their usage back
+	if u, _ := qm.GetUsage(context.Background(), "qwerty"); u.Operations != 1 || u.Bytes != 2 {
+		t.Errorf("expected the succeeded job to be counted alone got %+v", u)
+	}
+
+	limited := NewJobService(ops, storage.NewJobs(0), nil, NewQuotaService(storage.NewUsages(), Quota{Operations: 1, Period: QuotaDaily}), 1, 1, nil)
+	defer limited.Close()
+	if _, err := limited.SubmitSum(context.Background(), "qwerty", []byte("ok"), "ok", Options{}); err != nil {
+		t.Fatalf("non-nil error : %s", err.Error())
+	}
+	if _, err := limited.SubmitSum(context.Background(), "qwerty", []byte("ok"), "ok", Options{}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected quota exceeded at submission got %v", err)
+	}
+}
+
+func TestJobManager_Shutdown(t *testing.T) {
+	ops := operationsMock{
+		sum: func(ctx context.Context, data interface{}, opts Options) (*SumResult, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	jm := NewJobService(ops, storage.NewJobs(0), nil, nil, 1, 1, nil)
+	blocked, _ := jm.SubmitSum(context.Background(), "qwerty", []byte("block"), "block", Options{})
+	for job, _ := jm.GetJob("qwerty", blocked.ID); job.Status != storage.JobRunning; job, _ = jm.GetJob("qwerty", blocked.ID) {
+		time.Sleep(time.Millisecond)
+	}
+
+	queued, _ := jm.SubmitSum(context.Background(), "qwerty", []byte("block"), "block", Options{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	if err := jm.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded got %v", err)
+	}
+	for _, id := range []string{blocked.ID, queued.ID} {
+		if job, _ := jm.GetJob("qwerty", id); job.Status != storage.JobCanceled || job.Error != ErrJobsClosed.Error() {
+			t.Errorf("expected job canceled on shutdown got %+v", job)
+		}
+	}
+	if _, err := jm.SubmitSum(context.Background(), "qwerty", []byte("ok"), "ok", Options{}); !errors.Is(err, ErrJobsClosed) {
+		t.Errorf("expected closed service got %v", err)
+	}
+	if err := NewJobService(ops, storage.NewJobs(0), nil, nil, 1, 1, nil).Shutdown(context.Background()); err != nil {
+		t.Errorf("expected idle service to shut down got %v", err)
+	}
+}
+
+func TestHistoryManager(t *testing.T) {
+	hm := NewHistoryService(storage.NewHistory(0))
+	var ids []string
+	for i := 0; i < 3; i++ {
+		c, err := hm.RecordComputation(storage.Computation{Subject: "qwerty", Result: fmt.Sprint(i)})
+		if err != nil {
+			t.Fatalf("non-nil error : %s", err.Error())
+		}
+		if c.ID == "" || c.CreatedAt.IsZero() {
+			t.Errorf("expected id and creation time to be set got %+v", c)
+		}
+		ids = append(ids, c.ID)
+	}
+	if _, err := hm.RecordComputation(storage.Computation{Subject: "another"}); err != nil {
+		t.Fatalf("non-nil error : %s", err.Error())
+	}
+
+	page, err := hm.ListComputations("qwerty", 1, 0)
+	if err != nil {
+		t.Fatalf("non-nil error : %s", err.Error())
+	}
+	if page.Total != 3 || page.Limit != DefaultPageSize || len(page.Computations) != 2 ||
+		page.Computations[0].ID != ids[1] || page.Computations[1].ID != ids[0] {
+		t.Errorf("expected the two oldest computations newest first got %+v", page)
+	}
+	if page, _ := hm.ListComputations("qwerty", 5, 1); len(page.Computations) != 0 || page.Total != 3 {
+		t.Errorf("expected empty page past the end got %+v", page)
+	}
+	for _, p := range [][2]int{{-1, 1}, {0, -1}, {0, MaxPageSize + 1}} {
+		if _, err := hm.ListComputations("qwerty", p[0], p[1]); !errors.Is(err, ErrInvalidPage) {
+			t.Errorf("expected invalid page for %v got %v", p, err)
+		}
+	}
+
+	if c, err := hm.GetComputation("qwerty", ids[2]); err != nil || c.Result != "2" {
+		t.Errorf("expected computation got %+v %v", c, err)
+	}
+	if _, err := hm.GetComputation("another", ids[2]); !errors.Is(err, ErrComputationNotFound) {
+		t.Errorf("expected computation of another subject not to be found got %v", err)
+	}
+}
+
+func TestQuotaManager(t *testing.T) {
+	now := time.Date(2023, 2, 28, 23, 0, 0, 0, time.UTC)
+	qm := NewQuotaService(storage.NewUsages(), Quota{Operations: 2, Bytes: 100, Period: QuotaMonthly})
+	qm.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if _, err := qm.ReserveUsage(ctx, "qwerty", 60); err != nil {
+		t.Fatalf("non-nil error : %s", err.Error())
+	}
+	var qe *QuotaError
+	if _, err := qm.ReserveUsage(ctx, "qwerty", 50); !errors.As(err, &qe) || !errors.Is(err, ErrQuotaExceeded) ||
+		qe.Resource != QuotaBytes || qe.Used != 60 || qe.Limit != 100 || !qe.Reset.Equal(time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected bytes quota exceeded got %v %+v", err, qe)
+	}
+	rsv, err := qm.ReserveUsage(ctx, "qwerty", 10)
+	if err != nil {
+		t.Fatalf("expected the refused reservation not to be counted got %v", err)
+	}
+	if _, err := qm.ReserveUsage(ctx, "qwerty", 1); !errors.As(err, &qe) || qe.Resource != QuotaOperations || qe.Used != 2 {
+		t.Errorf("expected operations quota exceeded got %v", err)
+	}
+	if _, err := qm.ReserveUsage(ctx, "another", 100); err != nil {
+		t.Errorf("expected quota of another subject to be untouched got %v", err)
+	}
+
+	u, err := qm.GetUsage(ctx, "qwerty")
+	if err != nil {
+		t.Fatalf("non-nil error : %s", err.Error())
+	}
+	if u.Operations != 2 || u.Bytes != 70 || u.RemainingOperations() != 0 || u.RemainingBytes() != 30 ||
+		!u.Start.Equal(time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected usage %+v", u)
+	}
+	if err := qm.RefundUsage(ctx, rsv); err != nil {
+		t.Fatalf("non-nil error : %s", err.Error())
+	}
+	if u, _ := qm.GetUsage(ctx, "qwerty"); u.Operations != 1 || u.Bytes != 60 {
+		t.Errorf("expected the refunded usage to be given back got %+v", u)
+	}
+
+	now = now.Add(time.Hour)
+	if _, err := qm.ReserveUsage(ctx, "qwerty", 100); err != nil {
+		t.Errorf("expected quota to reset with the period got %v", err)
+	}
+	// note a refund of a period already over leaves the current one as is
+	if err := qm.RefundUsage(ctx, rsv); err != nil {
+		t.Fatalf("non-nil error : %s", err.Error())
+	}
+	if u, _ := qm.GetUsage(ctx, "qwerty"); u.Operations != 1 || u.Bytes != 100 {
+		t.Errorf("expected the usage of the new period to be untouched got %+v", u)
+	}
+
+	// note concurrent operations never go over the quota together
+	concurrent := NewQuotaService(storage.NewUsages(), Quota{Operations: 5, Period: QuotaDaily})
+	var wg sync.WaitGroup
+	var reserved int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := concurrent.ReserveUsage(ctx, "qwerty", 1); err == nil {
+				atomic.AddInt32(&reserved, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if u, _ := concurrent.GetUsage(ctx, "qwerty"); reserved > 5 || u.Operations != int(reserved) {
+		t.Errorf("expected at most 5 operations got %d reserved %+v", reserved, u)
+	}
+
+	daily := NewQuotaService(storage.NewUsages(), Quota{Period: QuotaDaily})
+	daily.now = func() time.Time { return now }
+	u, _ = daily.GetUsage(ctx, "qwerty")
+	if u.RemainingOperations() != -1 || u.RemainingBytes() != -1 || !u.Reset.Equal(time.Date(2023, 3, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected unlimited daily usage got %+v", u)
+	}
+}
+
+func TestAsError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected *Error
+	}{
+		{err: fmt.Errorf("%w: $.a", ErrInvalidSelection), expected: ErrInvalidSelection},
+		{err: fmt.Errorf("%w: Token is expired", auth.ErrTokenExpired), expected: ErrTokenExpired},
+		{err: auth.ErrTokenSignature, expected: ErrTokenSignature},
+		{err: auth.ErrTokenMalformed, expected: ErrTokenInvalid},
+		{err: fmt.Errorf("error storing JWT: %w", storage.ErrUnavailable), expected: ErrStorageUnavailable},
+		{err: context.DeadlineExceeded, expected: ErrDeadlineExceeded},
+		{err: errors.New("unexpected"), expected: ErrInternal},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("generic test %d", i+1), func(t *testing.T) {
+			if e := AsError(test.err); e != test.expected {
+				t.Errorf("expected %s got %s", test.expected.Code, e.Code)
+			}
+		})
+	}
+}
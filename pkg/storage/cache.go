@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// ResultCache - defines the operations needed to cache operation results by a content derived key.
+type ResultCache interface {
+	GetResult(key string) (string, bool)
+	SetResult(key, result string)
+}
+
+// ResultLRU - is a virtual memory bounded cache of operation results, the least recently used entry is evicted when
+// full and entries expire ttl after being set.
+type ResultLRU struct {
+	*sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries *list.List
+	index   map[string]*list.Element
+	now     func() time.Time
+}
+
+type cacheEntry struct {
+	key     string
+	result  string
+	expires time.Time
+}
+
+func NewResultLRU(size int, ttl time.Duration) *ResultLRU {
+	return &ResultLRU{
+		Mutex:   new(sync.Mutex),
+		size:    size,
+		ttl:     ttl,
+		entries: list.New(),
+		index:   make(map[string]*list.Element),
+		now:     time.Now,
+	}
+}
+
+// GetResult - returns the cached result for key if present and not expired.
+func (c *ResultLRU) GetResult(key string) (string, bool) {
+	c.Lock()
+	defer c.Unlock()
+	el, ok := c.index[key]
+	if !ok {
+		return "", false
+	}
+	entry := el.Value.(*cacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.remove(el)
+		return "", false
+	}
+	c.entries.MoveToFront(el)
+	return entry.result, true
+}
+
+// SetResult - caches the result for key evicting the least recently used entry if full.
+func (c *ResultLRU) SetResult(key, result string) {
+	c.Lock()
+	defer c.Unlock()
+	if c.size <= 0 {
+		return
+	}
+	expires := c.now().Add(c.ttl)
+	if el, ok := c.index[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		entry.result, entry.expires = result, expires
+		c.entries.MoveToFront(el)
+		return
+	}
+	c.index[key] = c.entries.PushFront(&cacheEntry{key: key, result: result, expires: expires})
+	for c.entries.Len() > c.size {
+		c.remove(c.entries.Back())
+	}
+}
+
+// Len - number of entries, expired ones included until they are looked up or evicted.
+func (c *ResultLRU) Len() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.entries.Len()
+}
+
+func (c *ResultLRU) remove(el *list.Element) {
+	c.entries.Remove(el)
+	delete(c.index, el.Value.(*cacheEntry).key)
+}
@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResultLRU(t *testing.T) {
+	now := time.Now()
+	c := NewResultLRU(2, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.SetResult("a", "1")
+	c.SetResult("b", "2")
+	if _, ok := c.GetResult("a"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+	// note b is now the least recently used entry
+	c.SetResult("c", "3")
+	if _, ok := c.GetResult("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	if res, ok := c.GetResult("c"); !ok || res != "3" {
+		t.Errorf("expected c to be cached with 3 got %s", res)
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := c.GetResult("a"); ok {
+		t.Error("expected a to be expired")
+	}
+	if c.Len() != 1 {
+		t.Errorf("expected expired entry to be removed, got %d entries", c.Len())
+	}
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"sync"
+	"time"
+)
+
+// Computation - record of an operation computed for a subject, Operation is `sum` or the aggregation computed,
+// Digest is the SHA256 of the raw input and Size its length in bytes.
+type Computation struct {
+	ID        string
+	Subject   string
+	Operation string
+	CreatedAt time.Time
+	Digest    string
+	Size      int
+	Result    string
+	Duration  time.Duration
+}
+
+// ManageHistory - defines the operations needed to persist the history of computations.
+type ManageHistory interface {
+	SaveComputation(c Computation) error
+	GetComputation(id string) (Computation, bool)
+	// ListComputations - computations of the subject, newest first, skipping offset and returning at most limit of
+	// them along with the total number the subject has.
+	ListComputations(subject string, offset, limit int) ([]Computation, int, error)
+}
+
+// History - is a virtual memory storage for the history of computations, keeping at most max per subject.
+type History struct {
+	*sync.RWMutex
+	Storage   map[string]Computation
+	bySubject map[string][]string
+	max       int
+}
+
+// NewHistory - history constructor, once a subject has max computations the oldest one is removed for each new one,
+// 0 keeps them all.
+func NewHistory(max int) *History {
+	return &History{
+		RWMutex:   new(sync.RWMutex),
+		Storage:   make(map[string]Computation),
+		bySubject: make(map[string][]string),
+		max:       max,
+	}
+}
+
+// SaveComputation - appends a computation to the history of its subject, removing the oldest one over max.
+func (h *History) SaveComputation(c Computation) error {
+	h.Lock()
+	defer h.Unlock()
+	if _, ok := h.Storage[c.ID]; !ok {
+		ids := append(h.bySubject[c.Subject], c.ID)
+		if h.max > 0 && len(ids) > h.max {
+			delete(h.Storage, ids[0])
+			ids = ids[1:]
+		}
+		h.bySubject[c.Subject] = ids
+	}
+	h.Storage[c.ID] = c
+	return nil
+}
+
+// GetComputation - returns a computation by id.
+func (h *History) GetComputation(id string) (Computation, bool) {
+	h.RLock()
+	defer h.RUnlock()
+	c, ok := h.Storage[id]
+	return c, ok
+}
+
+// ListComputations - ids are appended as computations are saved, so walking them backwards gives the newest first.
+func (h *History) ListComputations(subject string, offset, limit int) ([]Computation, int, error) {
+	h.RLock()
+	defer h.RUnlock()
+	ids := h.bySubject[subject]
+	res := make([]Computation, 0, limit)
+	for i := len(ids) - 1 - offset; i >= 0 && len(res) < limit; i-- {
+		res = append(res, h.Storage[ids[i]])
+	}
+	return res, len(ids), nil
+}
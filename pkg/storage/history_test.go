@@ -0,0 +1,22 @@
+package storage
+
+import "testing"
+
+func TestHistory(t *testing.T) {
+	h := NewHistory(2)
+	for _, id := range []string{"first", "second", "third"} {
+		_ = h.SaveComputation(Computation{ID: id, Subject: "qwerty"})
+	}
+	_ = h.SaveComputation(Computation{ID: "another", Subject: "another"})
+
+	if _, ok := h.GetComputation("first"); ok {
+		t.Error("expected oldest computation over the cap to be removed")
+	}
+	page, total, _ := h.ListComputations("qwerty", 0, 10)
+	if total != 2 || len(page) != 2 || page[0].ID != "third" || page[1].ID != "second" {
+		t.Errorf("expected the two newest computations got %+v of %d", page, total)
+	}
+	if _, ok := h.GetComputation("another"); !ok {
+		t.Error("expected computations of other subjects to be kept")
+	}
+}
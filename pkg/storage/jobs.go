@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"sync"
+	"time"
+)
+
+// Job statuses.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+)
+
+// Job - state of an asynchronous operation owned by the subject that submitted it.
+type Job struct {
+	ID        string
+	Owner     string
+	Status    string
+	Result    string
+	Error     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Done - whether the job reached a final status.
+func (j Job) Done() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed || j.Status == JobCanceled
+}
+
+// ManageJobs - defines the operations needed to persist asynchronous jobs.
+type ManageJobs interface {
+	SaveJob(job Job) error
+	GetJob(id string) (Job, bool)
+}
+
+// Jobs - is a virtual memory storage for asynchronous jobs, finished jobs are pruned retention after they finished as
+// new ones are saved.
+type Jobs struct {
+	*sync.RWMutex
+	Storage   map[string]Job
+	retention time.Duration
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// NewJobs - jobs storage constructor, a non positive retention keeps the finished jobs.
+func NewJobs(retention time.Duration) *Jobs {
+	return &Jobs{
+		RWMutex:   new(sync.RWMutex),
+		Storage:   make(map[string]Job),
+		retention: retention,
+		now:       time.Now,
+	}
+}
+
+// SaveJob - creates or replaces a job.
+func (j *Jobs) SaveJob(job Job) error {
+	j.Lock()
+	defer j.Unlock()
+	j.Storage[job.ID] = job
+	// note pruning walks every job, once a second bounds its cost whatever the rate of jobs
+	if now := j.now(); j.retention > 0 && now.Sub(j.lastPrune) >= time.Second {
+		for id, job := range j.Storage {
+			if job.Done() && now.Sub(job.UpdatedAt) >= j.retention {
+				delete(j.Storage, id)
+			}
+		}
+		j.lastPrune = now
+	}
+	return nil
+}
+
+// GetJob - returns a job by id.
+func (j *Jobs) GetJob(id string) (Job, bool) {
+	j.RLock()
+	defer j.RUnlock()
+	job, ok := j.Storage[id]
+	return job, ok
+}
+
+// Len - number of jobs, finished ones included until they are pruned.
+func (j *Jobs) Len() int {
+	j.RLock()
+	defer j.RUnlock()
+	return len(j.Storage)
+}
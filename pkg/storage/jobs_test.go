@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJobs(t *testing.T) {
+	now := time.Now()
+	js := NewJobs(time.Hour)
+	js.now = func() time.Time { return now }
+
+	_ = js.SaveJob(Job{ID: "finished", Status: JobSucceeded, UpdatedAt: now})
+	_ = js.SaveJob(Job{ID: "running", Status: JobRunning, UpdatedAt: now})
+	now = now.Add(time.Hour)
+	_ = js.SaveJob(Job{ID: "recent", Status: JobFailed, UpdatedAt: now})
+
+	if _, ok := js.GetJob("finished"); ok {
+		t.Error("expected finished job past the retention to be pruned")
+	}
+	for _, id := range []string{"running", "recent"} {
+		if _, ok := js.GetJob(id); !ok {
+			t.Errorf("expected job %s to be kept", id)
+		}
+	}
+	if js.Len() != 2 {
+		t.Errorf("expected 2 jobs got %d", js.Len())
+	}
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/qredo-external/go-rnov/pkg/trace"
+)
+
+// ReplayCache - remembers single use values, e.g. the `jti` of DPoP proofs, until they expire so they are only
+// accepted once. Implementations shared by the replicas of the service stop replays across them.
+type ReplayCache interface {
+	// Use - records key until expires, false if it was already recorded and has not expired yet.
+	Use(ctx context.Context, key string, expires time.Time) (bool, error)
+}
+
+// Replays - is a virtual memory replay cache, expired keys are pruned as new ones are recorded.
+type Replays struct {
+	*sync.Mutex
+	keys      map[string]time.Time
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+func NewReplays() *Replays {
+	return &Replays{
+		Mutex: new(sync.Mutex),
+		keys:  make(map[string]time.Time),
+		now:   time.Now,
+	}
+}
+
+// Use - records key until expires, false if it was already recorded and has not expired yet.
+func (rc *Replays) Use(ctx context.Context, key string, expires time.Time) (bool, error) {
+	_, span := trace.Start(ctx, "Replays.Use", trace.KindInternal, trace.Attr("db.system", "memory"))
+	defer span.End()
+	rc.Lock()
+	defer rc.Unlock()
+	now := rc.now()
+	if exp, ok := rc.keys[key]; ok && now.Before(exp) {
+		return false, nil
+	}
+	rc.keys[key] = expires
+	// note pruning walks every key, once a second bounds its cost whatever the rate of requests
+	if now.Sub(rc.lastPrune) >= time.Second {
+		for k, exp := range rc.keys {
+			if !now.Before(exp) {
+				delete(rc.keys, k)
+			}
+		}
+		rc.lastPrune = now
+	}
+	return true, nil
+}
+
+// Len - number of keys recorded, expired ones included until they are pruned.
+func (rc *Replays) Len() int {
+	rc.Lock()
+	defer rc.Unlock()
+	return len(rc.keys)
+}
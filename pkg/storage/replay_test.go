@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReplays(t *testing.T) {
+	now := time.Now()
+	rc := NewReplays()
+	rc.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if fresh, err := rc.Use(ctx, "a", now.Add(time.Minute)); !fresh || err != nil {
+		t.Fatalf("expected a to be fresh got %t %v", fresh, err)
+	}
+	if fresh, _ := rc.Use(ctx, "a", now.Add(time.Minute)); fresh {
+		t.Error("expected a to be replayed")
+	}
+	_, _ = rc.Use(ctx, "b", now.Add(time.Second*30))
+
+	now = now.Add(time.Minute)
+	if fresh, _ := rc.Use(ctx, "a", now.Add(time.Minute)); !fresh {
+		t.Error("expected a to be usable once expired")
+	}
+	if rc.Len() != 1 {
+		t.Errorf("expected expired keys to be pruned, got %d keys", rc.Len())
+	}
+}
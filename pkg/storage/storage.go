@@ -1,7 +1,16 @@
 package storage
 
+import (
+	"context"
+	"errors"
+)
+
+// ErrUnavailable - the storage can not be reached, storage implementations wrap it so callers can tell a failure
+// of the storage from a missing or inactive entry.
+var ErrUnavailable = errors.New("storage unavailable")
+
 // ManageUsers - defines all the operations that need to be supported by any type of storage solutions used.
 type ManageUsers interface {
-	AddUserToken(token string) bool
-	IsActiveToken(token string) bool
+	AddUserToken(ctx context.Context, token string) error
+	IsActiveToken(ctx context.Context, token string) (bool, error)
 }
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/qredo-external/go-rnov/pkg/trace"
+)
+
+// Usage - operations and bytes processed by a subject over the quota period starting at Start.
+type Usage struct {
+	Subject    string
+	Start      time.Time
+	Operations int
+	Bytes      int
+}
+
+// ManageUsage - defines the operations needed to persist the usage of the subjects, implementations shared by the
+// replicas of the service enforce the quotas across them.
+type ManageUsage interface {
+	// AddUsage - adds operations and bytes to the usage of the subject over the period starting at start and returns
+	// the result, atomic per subject. Negative amounts give usage back.
+	AddUsage(ctx context.Context, subject string, start time.Time, operations, bytes int) (Usage, error)
+	// GetUsage - usage of the subject over the period starting at start, zero when nothing was added.
+	GetUsage(ctx context.Context, subject string, start time.Time) (Usage, error)
+}
+
+// Usages - is a virtual memory storage for the usage of the subjects, only the latest period of each one is kept.
+type Usages struct {
+	*sync.RWMutex
+	bySubject map[string]Usage
+}
+
+func NewUsages() *Usages {
+	return &Usages{
+		RWMutex:   new(sync.RWMutex),
+		bySubject: make(map[string]Usage),
+	}
+}
+
+// AddUsage - a period later than the one kept for the subject starts from zero, usage added to an earlier one, e.g.
+// by a request that began before the period ended, is not kept.
+func (us *Usages) AddUsage(ctx context.Context, subject string, start time.Time, operations, bytes int) (Usage, error) {
+	_, span := trace.Start(ctx, "Usages.AddUsage", trace.KindInternal, trace.Attr("db.system", "memory"))
+	defer span.End()
+	us.Lock()
+	defer us.Unlock()
+	u, ok := us.bySubject[subject]
+	if start.Before(u.Start) {
+		return Usage{Subject: subject, Start: start, Operations: operations, Bytes: bytes}, nil
+	}
+	if !ok || u.Start.Before(start) {
+		u = Usage{Subject: subject, Start: start}
+	}
+	u.Operations += operations
+	u.Bytes += bytes
+	us.bySubject[subject] = u
+	return u, nil
+}
+
+// GetUsage - usage of the subject over the period starting at start, zero when nothing was added.
+func (us *Usages) GetUsage(ctx context.Context, subject string, start time.Time) (Usage, error) {
+	_, span := trace.Start(ctx, "Usages.GetUsage", trace.KindInternal, trace.Attr("db.system", "memory"))
+	defer span.End()
+	us.RLock()
+	defer us.RUnlock()
+	if u, ok := us.bySubject[subject]; ok && u.Start.Equal(start) {
+		return u, nil
+	}
+	return Usage{Subject: subject, Start: start}, nil
+}
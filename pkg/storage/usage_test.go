@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestUsages(t *testing.T) {
+	us := NewUsages()
+	ctx := context.Background()
+	feb, mar := time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	_, _ = us.AddUsage(ctx, "qwerty", feb, 1, 10)
+	if u, err := us.AddUsage(ctx, "qwerty", feb, 1, 5); err != nil || u.Operations != 2 || u.Bytes != 15 {
+		t.Fatalf("expected usage to add up got %+v %v", u, err)
+	}
+	if u, _ := us.GetUsage(ctx, "another", feb); u.Operations != 0 || u.Bytes != 0 || !u.Start.Equal(feb) {
+		t.Errorf("expected no usage of another subject got %+v", u)
+	}
+
+	if u, _ := us.AddUsage(ctx, "qwerty", mar, 1, 1); u.Operations != 1 || u.Bytes != 1 {
+		t.Errorf("expected a new period to start from zero got %+v", u)
+	}
+	_, _ = us.AddUsage(ctx, "qwerty", feb, 1, 100)
+	if u, _ := us.GetUsage(ctx, "qwerty", mar); u.Operations != 1 || u.Bytes != 1 {
+		t.Errorf("expected usage of a past period not to count got %+v", u)
+	}
+	if u, _ := us.GetUsage(ctx, "qwerty", feb); u.Operations != 0 {
+		t.Errorf("expected past periods not to be kept got %+v", u)
+	}
+}
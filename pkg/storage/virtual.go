@@ -1,7 +1,10 @@
 package storage
 
 import (
+	"context"
 	"sync"
+
+	"github.com/qredo-external/go-rnov/pkg/trace"
 )
 
 // UserAccess - is a virtual memory storage for user Tokens that are used to authenticate them
@@ -18,16 +21,32 @@ func NewUserAccess() *UserAccess {
 }
 
 // AddUserToken - add user token to the storage.
-func (u *UserAccess) AddUserToken(token string) bool {
+func (u *UserAccess) AddUserToken(ctx context.Context, token string) error {
+	_, span := trace.Start(ctx, "UserAccess.AddUserToken", trace.KindInternal, trace.Attr("db.system", "memory"))
+	defer span.End()
 	u.Lock()
 	defer u.Unlock()
 	u.Storage[token] = true
-	return true
+	return nil
 }
 
 // IsActiveToken - checks whether a token is still active/valid in our system.
-func (u *UserAccess) IsActiveToken(token string) bool {
+func (u *UserAccess) IsActiveToken(ctx context.Context, token string) (bool, error) {
+	_, span := trace.Start(ctx, "UserAccess.IsActiveToken", trace.KindInternal, trace.Attr("db.system", "memory"))
+	defer span.End()
 	u.RLock()
 	defer u.RUnlock()
-	return u.Storage[token]
+	return u.Storage[token], nil
+}
+
+// Len - number of tokens stored.
+func (u *UserAccess) Len() int {
+	u.RLock()
+	defer u.RUnlock()
+	return len(u.Storage)
+}
+
+// Ping - checks the storage can be reached, always the case in memory unless ctx is done.
+func (u *UserAccess) Ping(ctx context.Context) error {
+	return ctx.Err()
 }
@@ -0,0 +1,165 @@
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// JSONExporter - writes every span as one readable json object per line, e.g. to stdout.
+type JSONExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewJSONExporter(w io.Writer) *JSONExporter {
+	return &JSONExporter{w: w}
+}
+
+type jsonSpan struct {
+	Name          string                 `json:"name"`
+	Kind          string                 `json:"kind"`
+	TraceID       string                 `json:"trace_id"`
+	SpanID        string                 `json:"span_id"`
+	ParentSpanID  string                 `json:"parent_span_id,omitempty"`
+	Start         time.Time              `json:"start"`
+	DurationMS    float64                `json:"duration_ms"`
+	Attributes    map[string]interface{} `json:"attributes,omitempty"`
+	Error         bool                   `json:"error,omitempty"`
+	StatusMessage string                 `json:"status_message,omitempty"`
+}
+
+// Export - writes the span.
+func (e *JSONExporter) Export(s SpanData) error {
+	js := jsonSpan{
+		Name:          s.Name,
+		Kind:          s.Kind.String(),
+		TraceID:       s.SpanContext.TraceID.String(),
+		SpanID:        s.SpanContext.SpanID.String(),
+		Start:         s.Start.UTC(),
+		DurationMS:    float64(s.End.Sub(s.Start)) / float64(time.Millisecond),
+		Error:         s.StatusCode == StatusError,
+		StatusMessage: s.StatusMessage,
+	}
+	if s.Parent.IsValid() {
+		js.ParentSpanID = s.Parent.String()
+	}
+	if len(s.Attributes) > 0 {
+		js.Attributes = make(map[string]interface{}, len(s.Attributes))
+		for _, a := range s.Attributes {
+			js.Attributes[a.Key] = a.Value
+		}
+	}
+	return e.write(js)
+}
+
+func (e *JSONExporter) write(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.w.Write(append(b, '\n'))
+	return err
+}
+
+// OTLPExporter - writes every span as one OTLP/JSON ExportTraceServiceRequest per line, the format of the
+// OpenTelemetry collector file exporter and receiver, so the file can be replayed to any OTLP backend.
+type OTLPExporter struct {
+	JSONExporter
+	service string
+}
+
+// NewOTLPExporter - OTLP exporter constructor, service is the `service.name` resource attribute.
+func NewOTLPExporter(w io.Writer, service string) *OTLPExporter {
+	return &OTLPExporter{JSONExporter: JSONExporter{w: w}, service: service}
+}
+
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string                 `json:"key"`
+		Value map[string]interface{} `json:"value"`
+	}
+)
+
+// otlpKinds - OTLP SpanKind values.
+var otlpKinds = map[Kind]int{KindInternal: 1, KindServer: 2, KindClient: 3}
+
+// Export - writes the span in its own request.
+func (e *OTLPExporter) Export(s SpanData) error {
+	span := otlpSpan{
+		TraceID:           s.SpanContext.TraceID.String(),
+		SpanID:            s.SpanContext.SpanID.String(),
+		Name:              s.Name,
+		Kind:              otlpKinds[s.Kind],
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		Status:            otlpStatus{Code: int(s.StatusCode), Message: s.StatusMessage},
+	}
+	if s.Parent.IsValid() {
+		span.ParentSpanID = s.Parent.String()
+	}
+	for _, a := range s.Attributes {
+		span.Attributes = append(span.Attributes, otlpAttribute(a))
+	}
+	return e.write(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpKeyValue{otlpAttribute(Attr("service.name", e.service))}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "github.com/qredo-external/go-rnov/pkg/trace"}, Spans: []otlpSpan{span}}},
+	}}})
+}
+
+// otlpAttribute - OTLP AnyValue of the attribute, 64 bit integers are strings in OTLP/JSON.
+func otlpAttribute(a Attribute) otlpKeyValue {
+	var v map[string]interface{}
+	switch val := a.Value.(type) {
+	case string:
+		v = map[string]interface{}{"stringValue": val}
+	case bool:
+		v = map[string]interface{}{"boolValue": val}
+	case int:
+		v = map[string]interface{}{"intValue": strconv.Itoa(val)}
+	case int64:
+		v = map[string]interface{}{"intValue": strconv.FormatInt(val, 10)}
+	case float64:
+		v = map[string]interface{}{"doubleValue": val}
+	default:
+		v = map[string]interface{}{"stringValue": fmt.Sprint(val)}
+	}
+	return otlpKeyValue{Key: a.Key, Value: v}
+}
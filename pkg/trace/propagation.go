@@ -0,0 +1,68 @@
+package trace
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// TraceparentHeader - W3C Trace Context header carrying the trace id, parent id and flags.
+const TraceparentHeader = "traceparent"
+
+const sampledFlag = 0x01
+
+var errInvalidHex = errors.New("invalid hex field")
+
+// Extract - the span context of a W3C traceparent header, false when it is missing or invalid. Versions after 00 are
+// read as 00 as the specification asks, as long as their first four fields are well formed.
+func Extract(h http.Header) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(h.Get(TraceparentHeader)), "-")
+	if len(parts) < 4 {
+		return SpanContext{}, false
+	}
+	version, err := decodeHex(parts[0], 1)
+	if err != nil || version[0] == 0xff || (version[0] == 0 && len(parts) != 4) {
+		return SpanContext{}, false
+	}
+	var sc SpanContext
+	traceID, err := decodeHex(parts[1], len(sc.TraceID))
+	if err != nil {
+		return SpanContext{}, false
+	}
+	spanID, err := decodeHex(parts[2], len(sc.SpanID))
+	if err != nil {
+		return SpanContext{}, false
+	}
+	flags, err := decodeHex(parts[3], 1)
+	if err != nil {
+		return SpanContext{}, false
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Sampled = flags[0]&sampledFlag != 0
+	if !sc.IsValid() {
+		return SpanContext{}, false
+	}
+	return sc, true
+}
+
+// Inject - sets the traceparent header of the span context, nothing when it is invalid.
+func Inject(sc SpanContext, h http.Header) {
+	if !sc.IsValid() {
+		return
+	}
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	h.Set(TraceparentHeader, "00-"+sc.TraceID.String()+"-"+sc.SpanID.String()+"-"+flags)
+}
+
+// decodeHex - decodes exactly n bytes of lowercase hex.
+func decodeHex(s string, n int) ([]byte, error) {
+	if len(s) != 2*n || strings.ToLower(s) != s {
+		return nil, errInvalidHex
+	}
+	return hex.DecodeString(s)
+}
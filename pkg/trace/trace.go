@@ -0,0 +1,241 @@
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Kind - role of a span in the trace, as in OpenTelemetry.
+type Kind int
+
+const (
+	KindInternal Kind = iota + 1
+	KindServer
+	KindClient
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindServer:
+		return "server"
+	case KindClient:
+		return "client"
+	default:
+		return "internal"
+	}
+}
+
+// StatusCode - outcome of a span, unset unless an error was recorded.
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = iota
+	StatusOK
+	StatusError
+)
+
+// TraceID - W3C trace id, 16 bytes not all zero.
+type TraceID [16]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+// IsValid - an all zero trace id is invalid.
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+// SpanID - W3C parent (span) id, 8 bytes not all zero.
+type SpanID [8]byte
+
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// IsValid - an all zero span id is invalid.
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// SpanContext - identifies a span across process boundaries.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid - both ids must be valid.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Attribute - key value describing a span, values are strings, bools, integers or floats.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Attr - shorthand to build an Attribute.
+func Attr(key string, value interface{}) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// SpanData - what an exporter receives once a span ends.
+type SpanData struct {
+	Name          string
+	Kind          Kind
+	SpanContext   SpanContext
+	Parent        SpanID
+	Start         time.Time
+	End           time.Time
+	Attributes    []Attribute
+	StatusCode    StatusCode
+	StatusMessage string
+}
+
+// Exporter - sends ended spans to a backend.
+type Exporter interface {
+	Export(s SpanData) error
+}
+
+// Tracer - creates spans and exports those sampled once they end.
+type Tracer struct {
+	exporter Exporter
+	now      func() time.Time
+}
+
+// NewTracer - tracer constructor, exp nil discards the spans but still propagates the trace context.
+func NewTracer(exp Exporter) *Tracer {
+	return &Tracer{exporter: exp, now: time.Now}
+}
+
+// Span - a timed operation of a trace, a nil Span is valid and records nothing.
+type Span struct {
+	mu     sync.Mutex
+	tracer *Tracer
+	data   SpanData
+	ended  bool
+}
+
+// SpanContext - ids of the span, the zero value for a nil span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+// SetAttributes - adds attributes to the span.
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+}
+
+// RecordError - marks the span as failed with err, nil errors are ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.StatusCode, s.data.StatusMessage = StatusError, err.Error()
+}
+
+// End - ends the span and exports it if sampled, only the first call counts.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = s.tracer.now()
+	data := s.data
+	s.mu.Unlock()
+
+	if s.tracer.exporter != nil && data.SpanContext.Sampled {
+		// note tracing must never fail a request, spans that can not be exported are lost.
+		_ = s.tracer.exporter.Export(data)
+	}
+}
+
+// Start - starts a span child of the span of ctx, or of the remote parent when ctx has none, and returns a context
+// holding it. Without a tracer in ctx the span is nil, so callers need no checks.
+func Start(ctx context.Context, name string, kind Kind, attrs ...Attribute) (context.Context, *Span) {
+	tr := tracerFromContext(ctx)
+	if tr == nil {
+		return ctx, nil
+	}
+	parent := SpanFromContext(ctx).SpanContext()
+	if !parent.IsValid() {
+		parent = remoteFromContext(ctx)
+	}
+	sc := SpanContext{TraceID: parent.TraceID, SpanID: newSpanID(), Sampled: parent.Sampled}
+	if !parent.IsValid() {
+		// note a new trace is always sampled, a remote parent decides for the traces it started.
+		sc.TraceID, sc.Sampled = newTraceID(), true
+	}
+	s := &Span{
+		tracer: tr,
+		data: SpanData{
+			Name:        name,
+			Kind:        kind,
+			SpanContext: sc,
+			Parent:      parent.SpanID,
+			Start:       tr.now(),
+			Attributes:  attrs,
+		},
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+type (
+	tracerKey struct{}
+	spanKey   struct{}
+	remoteKey struct{}
+)
+
+// NewContext - returns a context carrying the tracer, spans are only created under such a context.
+func NewContext(ctx context.Context, tr *Tracer) context.Context {
+	return context.WithValue(ctx, tracerKey{}, tr)
+}
+
+// WithRemoteParent - returns a context whose next span continues the trace of a remote parent.
+func WithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// SpanFromContext - the current span of ctx, nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+func tracerFromContext(ctx context.Context) *Tracer {
+	tr, _ := ctx.Value(tracerKey{}).(*Tracer)
+	return tr
+}
+
+func remoteFromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
@@ -0,0 +1,120 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+type recorder struct {
+	spans []SpanData
+}
+
+func (r *recorder) Export(s SpanData) error {
+	r.spans = append(r.spans, s)
+	return nil
+}
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		valid   bool
+		sampled bool
+	}{
+		{name: "sampled", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", valid: true, sampled: true},
+		{name: "not sampled", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", valid: true},
+		{name: "future version", header: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-what", valid: true, sampled: true},
+		{name: "extra field", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-what"},
+		{name: "invalid version", header: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{name: "zero trace id", header: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{name: "zero span id", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01"},
+		{name: "uppercase", header: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"},
+		{name: "short", header: "00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01"},
+		{name: "missing"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := http.Header{}
+			if test.header != "" {
+				h.Set(TraceparentHeader, test.header)
+			}
+			sc, ok := Extract(h)
+			if ok != test.valid || sc.Sampled != test.sampled {
+				t.Errorf("expected valid %t sampled %t got %t %t", test.valid, test.sampled, ok, sc.Sampled)
+			}
+			if !ok {
+				return
+			}
+			out := http.Header{}
+			Inject(sc, out)
+			if got := out.Get(TraceparentHeader); got[3:52] != test.header[3:52] {
+				t.Errorf("expected ids of %s injected got %s", test.header, got)
+			}
+		})
+	}
+}
+
+func TestStart(t *testing.T) {
+	if _, span := Start(context.Background(), "untraced", KindInternal); span != nil {
+		t.Fatalf("expected no span without a tracer")
+	}
+
+	rec := &recorder{}
+	ctx := NewContext(context.Background(), NewTracer(rec))
+	remote := SpanContext{TraceID: TraceID{1}, SpanID: SpanID{2}, Sampled: true}
+	ctx, server := Start(WithRemoteParent(ctx, remote), "server", KindServer)
+	_, child := Start(ctx, "child", KindInternal, Attr("a", 1))
+	child.RecordError(errors.New("boom"))
+	child.End()
+	child.End()
+	server.End()
+
+	if len(rec.spans) != 2 {
+		t.Fatalf("expected 2 spans exported got %d", len(rec.spans))
+	}
+	c, s := rec.spans[0], rec.spans[1]
+	if s.SpanContext.TraceID != remote.TraceID || s.Parent != remote.SpanID {
+		t.Errorf("expected server span to continue the remote trace got %+v", s)
+	}
+	if c.SpanContext.TraceID != remote.TraceID || c.Parent != s.SpanContext.SpanID {
+		t.Errorf("expected child span under the server span got %+v", c)
+	}
+	if c.StatusCode != StatusError || c.StatusMessage != "boom" || len(c.Attributes) != 1 {
+		t.Errorf("unexpected child span %+v", c)
+	}
+
+	rec.spans = nil
+	ctx = NewContext(context.Background(), NewTracer(rec))
+	_, span := Start(WithRemoteParent(ctx, SpanContext{TraceID: TraceID{1}, SpanID: SpanID{2}}), "unsampled", KindServer)
+	span.End()
+	if len(rec.spans) != 0 || !span.SpanContext().IsValid() {
+		t.Errorf("expected an unsampled span with ids not exported got %+v", rec.spans)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	ctx := NewContext(context.Background(), NewTracer(NewOTLPExporter(buf, "aService")))
+	_, span := Start(ctx, "aSpan", KindServer, Attr("http.status_code", 200), Attr("http.route", "/sum"))
+	span.End()
+
+	req := otlpRequest{}
+	if err := json.Unmarshal(buf.Bytes(), &req); err != nil {
+		t.Fatalf("invalid OTLP/JSON %s: %v", buf.String(), err)
+	}
+	rs := req.ResourceSpans[0]
+	got := rs.ScopeSpans[0].Spans[0]
+	if rs.Resource.Attributes[0].Value["stringValue"] != "aService" {
+		t.Errorf("expected service name in %s", buf.String())
+	}
+	if got.Name != "aSpan" || got.Kind != 2 || got.TraceID != span.SpanContext().TraceID.String() || got.ParentSpanID != "" {
+		t.Errorf("unexpected span %+v", got)
+	}
+	if got.Attributes[0].Value["intValue"] != "200" || got.Attributes[1].Value["stringValue"] != "/sum" {
+		t.Errorf("unexpected attributes %+v", got.Attributes)
+	}
+}